@@ -0,0 +1,245 @@
+// Package acquisition turns a stream of samples and presence events into
+// captures: the samples while the knob is touched, with a margin of samples
+// before the touch and after the release.
+//
+// The Machine goes through explicit states,
+//
+//	PRE_TRIGGER -> CAPTURING -> POST_MARGIN -> FLUSHING -> PRE_TRIGGER
+//
+// and reports every transition. A touch during POST_MARGIN goes back to
+// CAPTURING. Samples come from a Source and finished captures go to a Sink,
+// so the same machine runs on the knob, on simulated sensors or on scripted
+// presence sequences.
+package acquisition
+
+import (
+	"../presence"
+	"../ring"
+	"fmt"
+	"time"
+)
+
+// State of the acquisition.
+type State int
+
+const (
+	PRE_TRIGGER State = iota // idle, keeping the last margin of samples
+	CAPTURING                // present, keeping every sample
+	POST_MARGIN              // released, keeping the margin after the release
+	FLUSHING                 // handing the capture to the sink
+)
+
+func (s State) String() string {
+	switch s {
+	case PRE_TRIGGER:
+		return "pre-trigger"
+	case CAPTURING:
+		return "capturing"
+	case POST_MARGIN:
+		return "post-margin"
+	case FLUSHING:
+		return "flushing"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Transition between two states.
+type Transition struct {
+	From, To State
+	Time     time.Time
+	Num      int // number of the capture involved
+}
+
+// Source gives the samples taken since the previous Read, oldest first.
+type Source[T any] interface {
+	Read() ([]T, error)
+}
+
+// Sink receives the finished captures.
+type Sink[T any] interface {
+	Write(c *Capture[T]) error
+}
+
+// SourceFunc adapts a function to a Source.
+type SourceFunc[T any] func() ([]T, error)
+
+func (f SourceFunc[T]) Read() ([]T, error) {
+	return f()
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc[T any] func(c *Capture[T]) error
+
+func (f SinkFunc[T]) Write(c *Capture[T]) error {
+	return f(c)
+}
+
+// Capture is one touch of the knob.
+type Capture[T any] struct {
+	Num   int       // captures are numbered from 0
+	Start time.Time // presence
+	End   time.Time // release, the last one if touched again in the margin
+	Pre   []T       // up to the margin before the presence
+	Data  []T       // samples while present
+	Post  []T       // margin after the release
+}
+
+// Len is the total number of samples.
+func (c *Capture[T]) Len() int {
+	return len(c.Pre) + len(c.Data) + len(c.Post)
+}
+
+// Machine is the acquisition state machine. Drive it with SetPresence and
+// Feed, or let Run do it.
+type Machine[T any] struct {
+	Source Source[T]
+	Sink   Sink[T]
+	// Notify, if not nil, is called on every transition.
+	Notify func(Transition)
+
+	state   State
+	present bool
+	num     int
+	pre     *ring.Ring[T] // the margin before the presence
+	post    *ring.Ring[T] // the margin after the release, full when done
+	cur     *Capture[T]
+}
+
+// New builds a machine in PRE_TRIGGER with a margin of samples before the
+// presence and after the release.
+func New[T any](src Source[T], sink Sink[T], margin int) *Machine[T] {
+	return &Machine[T]{
+		Source: src,
+		Sink:   sink,
+		pre:    ring.New[T](margin),
+		post:   ring.New[T](margin),
+	}
+}
+
+// NewDuration builds a machine in PRE_TRIGGER with a margin of time before
+// the presence and after the release, the samples are timed by stamp.
+func NewDuration[T any](src Source[T], sink Sink[T], margin time.Duration, stamp func(T) time.Time) *Machine[T] {
+	return &Machine[T]{
+		Source: src,
+		Sink:   sink,
+		pre:    ring.NewDuration(margin, stamp),
+		post:   ring.NewDuration(margin, stamp),
+	}
+}
+
+// State returns the current state.
+func (m *Machine[T]) State() State {
+	return m.state
+}
+
+// Present returns the last presence reported.
+func (m *Machine[T]) Present() bool {
+	return m.present
+}
+
+// Captures returns the number of captures flushed so far.
+func (m *Machine[T]) Captures() int {
+	return m.num
+}
+
+// SetNum sets the number of the next capture.
+func (m *Machine[T]) SetNum(num int) {
+	m.num = num
+}
+
+func (m *Machine[T]) transition(to State, t time.Time) {
+	tr := Transition{From: m.state, To: to, Time: t, Num: m.num}
+	m.state = to
+	if m.Notify != nil {
+		m.Notify(tr)
+	}
+}
+
+// SetPresence reports a change of presence at time t.
+func (m *Machine[T]) SetPresence(present bool, t time.Time) {
+	if present == m.present {
+		return
+	}
+	m.present = present
+	switch {
+	case present && m.state == PRE_TRIGGER:
+		m.cur = &Capture[T]{Num: m.num, Start: t, Pre: m.pre.Snapshot()}
+		m.pre.Reset()
+		m.transition(CAPTURING, t)
+	case present && m.state == POST_MARGIN:
+		//touched again during the post-margin, keep capturing
+		m.cur.Data = append(m.cur.Data, m.post.Snapshot()...)
+		m.post.Reset()
+		m.transition(CAPTURING, t)
+	case !present && m.state == CAPTURING:
+		m.cur.End = t
+		m.transition(POST_MARGIN, t)
+	}
+}
+
+// Feed processes new samples. The capture is flushed to the sink as soon as
+// the post-margin is complete, the remaining samples go to the pre-trigger
+// buffer of the next one. The sink error, if any, is returned once all
+// the samples are processed.
+func (m *Machine[T]) Feed(samples []T) error {
+	var err error
+	if m.state == POST_MARGIN && m.post.Full() {
+		err = m.flush()
+	}
+	for _, s := range samples {
+		switch m.state {
+		case PRE_TRIGGER:
+			m.pre.Push(s)
+		case CAPTURING:
+			m.cur.Data = append(m.cur.Data, s)
+		case POST_MARGIN:
+			m.post.Push(s)
+		}
+		if m.state == POST_MARGIN && m.post.Full() {
+			if e := m.flush(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+func (m *Machine[T]) flush() error {
+	m.cur.Post = m.post.Snapshot()
+	m.post.Reset()
+	m.transition(FLUSHING, time.Now())
+	var err error
+	if m.Sink != nil {
+		err = m.Sink.Write(m.cur)
+	}
+	m.cur = nil
+	m.transition(PRE_TRIGGER, time.Now())
+	m.num++
+	return err
+}
+
+// Poll reads the source and feeds the samples.
+func (m *Machine[T]) Poll() error {
+	samples, err := m.Source.Read()
+	if ferr := m.Feed(samples); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// Run polls the source on every tick and follows the presence events until
+// stop is closed or an error occurs.
+func (m *Machine[T]) Run(events <-chan presence.Event, tick <-chan time.Time, stop <-chan struct{}) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+		case ev := <-events:
+			m.SetPresence(ev.Present, ev.Time)
+		case <-tick:
+			if err := m.Poll(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package acquisition
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// step of a scripted presence sequence: a change of presence, if any, then
+// the samples read from the source.
+type step struct {
+	touch   bool // change the presence to present
+	release bool // change the presence to absent
+	samples []int
+}
+
+// script drives a machine with the steps through a SourceFunc, as Run does
+// with the presence events and the ticks. It returns the transitions, the
+// captures written and the errors of Poll.
+func script(margin int, sinkErr error, steps []step) ([]Transition, []*Capture[int], []error) {
+	var next []int
+	src := SourceFunc[int](func() ([]int, error) {
+		return next, nil
+	})
+	var captures []*Capture[int]
+	sink := SinkFunc[int](func(c *Capture[int]) error {
+		captures = append(captures, c)
+		return sinkErr
+	})
+	m := New[int](src, sink, margin)
+	var trs []Transition
+	m.Notify = func(tr Transition) {
+		trs = append(trs, tr)
+	}
+	var errs []error
+	t0 := time.Unix(0, 0)
+	for i, s := range steps {
+		t := t0.Add(time.Duration(i) * time.Millisecond)
+		switch {
+		case s.touch:
+			m.SetPresence(true, t)
+		case s.release:
+			m.SetPresence(false, t)
+		}
+		next = s.samples
+		errs = append(errs, m.Poll())
+	}
+	return trs, captures, errs
+}
+
+func states(trs []Transition) []State {
+	var out []State
+	for _, tr := range trs {
+		out = append(out, tr.To)
+	}
+	return out
+}
+
+func TestMachine(t *testing.T) {
+	tests := []struct {
+		name     string
+		margin   int
+		steps    []step
+		states   []State
+		captures []Capture[int]
+	}{
+		{
+			name:   "touch and release",
+			margin: 2,
+			steps: []step{
+				{samples: []int{1, 2, 3}},
+				{touch: true, samples: []int{4, 5}},
+				{release: true, samples: []int{6}},
+				{samples: []int{7, 8, 9}},
+			},
+			states:   []State{CAPTURING, POST_MARGIN, FLUSHING, PRE_TRIGGER},
+			captures: []Capture[int]{{Pre: []int{2, 3}, Data: []int{4, 5}, Post: []int{6, 7}}},
+		},
+		{
+			name:   "touched again in the post-margin",
+			margin: 3,
+			steps: []step{
+				{samples: []int{1}},
+				{touch: true, samples: []int{2}},
+				{release: true, samples: []int{3, 4}},
+				{touch: true, samples: []int{5}},
+				{release: true, samples: []int{6, 7, 8, 9}},
+			},
+			states:   []State{CAPTURING, POST_MARGIN, CAPTURING, POST_MARGIN, FLUSHING, PRE_TRIGGER},
+			captures: []Capture[int]{{Pre: []int{1}, Data: []int{2, 3, 4, 5}, Post: []int{6, 7, 8}}},
+		},
+		{
+			name:   "no margin",
+			margin: 0,
+			steps: []step{
+				{samples: []int{1, 2}},
+				{touch: true, samples: []int{3}},
+				{release: true, samples: []int{4}},
+				{touch: true, samples: []int{5}},
+				{release: true},
+			},
+			states: []State{
+				CAPTURING, POST_MARGIN, FLUSHING, PRE_TRIGGER,
+				CAPTURING, POST_MARGIN, FLUSHING, PRE_TRIGGER,
+			},
+			captures: []Capture[int]{{Pre: []int{}, Data: []int{3}, Post: []int{}}, {Pre: []int{}, Data: []int{5}, Post: []int{}}},
+		},
+		{
+			name:   "released in the same poll as the margin ends",
+			margin: 1,
+			steps: []step{
+				{touch: true, samples: []int{1}},
+				{release: true, samples: []int{2, 3, 4}},
+				{touch: true, samples: []int{5}},
+			},
+			states:   []State{CAPTURING, POST_MARGIN, FLUSHING, PRE_TRIGGER, CAPTURING},
+			captures: []Capture[int]{{Pre: []int{}, Data: []int{1}, Post: []int{2}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trs, captures, errs := script(tt.margin, nil, tt.steps)
+			for i, err := range errs {
+				if err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+			}
+			if got := states(trs); !reflect.DeepEqual(got, tt.states) {
+				t.Errorf("states %v, want %v", got, tt.states)
+			}
+			if len(captures) != len(tt.captures) {
+				t.Fatalf("%d captures, want %d", len(captures), len(tt.captures))
+			}
+			for i, c := range captures {
+				want := tt.captures[i]
+				if c.Num != i {
+					t.Errorf("capture %d numbered %d", i, c.Num)
+				}
+				if !reflect.DeepEqual(c.Pre, want.Pre) || !reflect.DeepEqual(c.Data, want.Data) || !reflect.DeepEqual(c.Post, want.Post) {
+					t.Errorf("capture %d: %v %v %v, want %v %v %v", i, c.Pre, c.Data, c.Post, want.Pre, want.Data, want.Post)
+				}
+			}
+		})
+	}
+}
+
+func TestMachineSinkError(t *testing.T) {
+	errSink := errors.New("sink failed")
+	trs, captures, errs := script(1, errSink, []step{
+		{touch: true, samples: []int{1}},
+		{release: true, samples: []int{2, 3}},
+		{touch: true, samples: []int{4}},
+		{release: true, samples: []int{5}},
+	})
+	if errs[1] != errSink || errs[3] != errSink {
+		t.Errorf("errors %v, want the sink error on the flushes", errs)
+	}
+	//the machine goes on with the next capture
+	want := []State{CAPTURING, POST_MARGIN, FLUSHING, PRE_TRIGGER, CAPTURING, POST_MARGIN, FLUSHING, PRE_TRIGGER}
+	if got := states(trs); !reflect.DeepEqual(got, want) {
+		t.Errorf("states %v, want %v", got, want)
+	}
+	if len(captures) != 2 || captures[1].Num != 1 || !reflect.DeepEqual(captures[1].Pre, []int{3}) {
+		t.Errorf("captures %v, want 2, the second after the first", captures)
+	}
+}
+
+func TestAsyncSinkQueueFull(t *testing.T) {
+	errSink := errors.New("sink failed")
+	started := make(chan int)
+	release := make(chan struct{})
+	a := NewAsync[int](SinkFunc[int](func(c *Capture[int]) error {
+		started <- c.Num
+		<-release
+		if c.Num == 0 {
+			return errSink
+		}
+		return nil
+	}), 1)
+
+	if err := a.Write(&Capture[int]{Num: 0}); err != nil {
+		t.Fatalf("write 0: %v", err)
+	}
+	if num := <-started; num != 0 {
+		t.Fatalf("writing %d, want 0", num)
+	}
+	//0 is being written, 1 fills the queue
+	if err := a.Write(&Capture[int]{Num: 1}); err != nil {
+		t.Fatalf("write 1: %v", err)
+	}
+	if err := a.Write(&Capture[int]{Num: 2}); err != ErrQueueFull {
+		t.Fatalf("write 2: %v, want %v", err, ErrQueueFull)
+	}
+	if a.Dropped() != 1 || a.Pending() != 1 {
+		t.Errorf("%d dropped, %d pending, want 1 and 1", a.Dropped(), a.Pending())
+	}
+
+	//0 fails, the error comes with the next write
+	release <- struct{}{}
+	if num := <-started; num != 1 {
+		t.Fatalf("writing %d, want 1", num)
+	}
+	if err := a.Write(&Capture[int]{Num: 3}); err != errSink {
+		t.Errorf("write 3: %v, want the error of 0", err)
+	}
+	release <- struct{}{}
+	if num := <-started; num != 3 {
+		t.Fatalf("writing %d, want 3", num)
+	}
+	release <- struct{}{}
+	if err := a.Close(); err != nil {
+		t.Errorf("close: %v, the error was reported", err)
+	}
+}
+
+// closeSink fails every capture and records its Close.
+type closeSink struct {
+	closed bool
+}
+
+func (s *closeSink) Write(c *Capture[int]) error {
+	return errors.New("sink failed")
+}
+
+func (s *closeSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestAsyncSinkCloseError(t *testing.T) {
+	sink := &closeSink{}
+	a := NewAsync[int](sink, 4)
+	if err := a.Write(&Capture[int]{}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	//the error of the last capture is only known once it is written
+	if err := a.Close(); err == nil {
+		t.Error("close: no error, want the sink one")
+	}
+	if !sink.closed {
+		t.Error("sink not closed")
+	}
+}
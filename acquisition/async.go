@@ -0,0 +1,114 @@
+package acquisition
+
+import (
+	"../ring"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrQueueFull is returned by AsyncSink.Write when the background writer is
+// late by the whole queue, the capture is dropped.
+var ErrQueueFull = errors.New("acquisition: sink queue full, capture dropped")
+
+// AsyncSink hands the captures to another sink on a background goroutine,
+// so the machine keeps sampling while they are written. The queue between
+// them is bounded: Write never blocks and reports ErrQueueFull instead.
+// Write must be called from a single goroutine, the one running the machine.
+type AsyncSink[T any] struct {
+	sink    Sink[T]
+	queue   *ring.SPSC[*Capture[T]]
+	wake    chan struct{}
+	done    chan struct{}
+	closing atomic.Bool
+	dropped atomic.Int64
+	mu      sync.Mutex
+	err     error // first error of the sink not yet reported
+}
+
+// NewAsync starts writing the captures to sink in the background, with room
+// for capacity captures waiting.
+func NewAsync[T any](sink Sink[T], capacity int) *AsyncSink[T] {
+	a := &AsyncSink[T]{
+		sink:  sink,
+		queue: ring.NewSPSC[*Capture[T]](capacity),
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *AsyncSink[T]) run() {
+	defer close(a.done)
+	for {
+		for c, ok := a.queue.Pop(); ok; c, ok = a.queue.Pop() {
+			if err := a.sink.Write(c); err != nil {
+				a.mu.Lock()
+				if a.err == nil {
+					a.err = err
+				}
+				a.mu.Unlock()
+			}
+		}
+		if a.closing.Load() && a.queue.Len() == 0 {
+			return
+		}
+		<-a.wake
+	}
+}
+
+func (a *AsyncSink[T]) signal() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Take the pending sink error.
+func (a *AsyncSink[T]) takeErr() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.err
+	a.err = nil
+	return err
+}
+
+// Write queues the capture. It returns ErrQueueFull if there is no room
+// left, otherwise the error of a previous capture the sink failed to write,
+// if any.
+func (a *AsyncSink[T]) Write(c *Capture[T]) error {
+	if !a.queue.Push(c) {
+		a.dropped.Add(1)
+		return ErrQueueFull
+	}
+	a.signal()
+	return a.takeErr()
+}
+
+// Pending is the number of captures waiting to be written, the one being
+// written excluded.
+func (a *AsyncSink[T]) Pending() int {
+	return a.queue.Len()
+}
+
+// Dropped is the number of captures dropped because the queue was full.
+func (a *AsyncSink[T]) Dropped() int {
+	return int(a.dropped.Load())
+}
+
+// Close waits for the queued captures to be written and closes the sink if
+// it is an io.Closer. It returns the sink error not yet reported, if any.
+func (a *AsyncSink[T]) Close() error {
+	a.closing.Store(true)
+	a.signal()
+	<-a.done
+	err := a.takeErr()
+	if closer, ok := a.sink.(io.Closer); ok {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,283 @@
+// Package ak8963 drives the AK8963 3-axis magnetometer found inside the
+// MPU-9250 package, over an i2c.Bus. The bus is either the main i2c bus,
+// with the MPU-9250 in bypass mode, or the MPU-9250 auxiliary i2c master
+// (see mpu9250.AuxBus).
+package ak8963
+
+import (
+	"../i2c"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Register map, from the MPU-9250 Register Map and Descriptions rev 1.6
+const (
+	DEVICE_ADDRESS = 0x0c
+
+	REG_WIA    = 0x00 // device id
+	REG_INFO   = 0x01
+	REG_ST1    = 0x02 // status 1
+	REG_HXL    = 0x03 // measurement data, 16 bits little endian
+	REG_HXH    = 0x04
+	REG_HYL    = 0x05
+	REG_HYH    = 0x06
+	REG_HZL    = 0x07
+	REG_HZH    = 0x08
+	REG_ST2    = 0x09 // status 2, must be read after the data
+	REG_CNTL1  = 0x0a
+	REG_CNTL2  = 0x0b
+	REG_ASTC   = 0x0c // self test
+	REG_I2CDIS = 0x0f
+	REG_ASAX   = 0x10 // sensitivity adjustment values, fuse ROM
+	REG_ASAY   = 0x11
+	REG_ASAZ   = 0x12
+
+	// ST1 bits
+	PARAM_DRDY = 0x01 // data ready
+	PARAM_DOR  = 0x02 // data overrun, a measurement was skipped
+
+	// ST2 bits
+	PARAM_HOFL = 0x08 // magnetic sensor overflow
+	PARAM_BITM = 0x10 // output bit setting mirror
+
+	// CNTL1 bits
+	PARAM_BIT_16    = 0x10 // 16 bits output
+	PARAM_MODE_MASK = 0x0f
+
+	// CNTL2 bits
+	PARAM_SRST = 0x01 // soft reset
+
+	WIA_AK8963 = 0x48
+
+	// Sensitivity in uT/LSB, 16 bits output
+	SENSITIVITY = 4912.0 / 32760.0
+
+	// Bytes from ST1 to ST2
+	DATA_SIZE = 8
+)
+
+// Time to switch between modes, from the datasheet.
+const MODE_SETTLE_TIME = 10 * time.Millisecond
+
+// Operation mode, the MODE field of CNTL1.
+type Mode byte
+
+const (
+	MODE_POWER_DOWN Mode = 0x00
+	MODE_SINGLE     Mode = 0x01
+	MODE_CONT_8HZ   Mode = 0x02
+	MODE_CONT_100HZ Mode = 0x06
+	MODE_SELF_TEST  Mode = 0x08
+	MODE_FUSE_ROM   Mode = 0x0f
+)
+
+// Continuous measurement mode from its rate in Hz (8, 100).
+func ModeFromRate(rate int) (Mode, error) {
+	switch rate {
+	case 8:
+		return MODE_CONT_8HZ, nil
+	case 100:
+		return MODE_CONT_100HZ, nil
+	}
+	return MODE_CONT_100HZ, fmt.Errorf("invalid magnetometer rate %d Hz", rate)
+}
+
+// Rate in Hz of the continuous measurement modes, 0 for the others.
+func (m Mode) Rate() int {
+	switch m {
+	case MODE_CONT_8HZ:
+		return 8
+	case MODE_CONT_100HZ:
+		return 100
+	}
+	return 0
+}
+
+func (m Mode) String() string {
+	switch m {
+	case MODE_POWER_DOWN:
+		return "power down"
+	case MODE_SINGLE:
+		return "single"
+	case MODE_SELF_TEST:
+		return "self test"
+	case MODE_FUSE_ROM:
+		return "fuse ROM"
+	}
+	return fmt.Sprintf("%dHz", m.Rate())
+}
+
+// ErrOverflow is returned when the magnetic field went beyond the range of
+// the sensor (|X|+|Y|+|Z| > 4912 uT), the data is not valid.
+var ErrOverflow = errors.New("ak8963: magnetic sensor overflow")
+
+// Raw counts of one measurement.
+type Sample struct {
+	X, Y, Z int16
+	Ready   bool // a new measurement, DRDY was set
+	Overrun bool // measurements were skipped since the last read
+}
+
+// Magnetic field in uT.
+type Field struct {
+	X, Y, Z float64
+}
+
+type AK8963 struct {
+	i2c  i2c.Bus
+	asa  [3]float64 // sensitivity adjustment factors
+	mode Mode
+	buf  []byte
+}
+
+// New drives the device behind dev. The adjustment factors are 1 until
+// ReadAdjustment is called.
+func New(dev i2c.Bus) *AK8963 {
+	return &AK8963{
+		i2c: dev,
+		asa: [3]float64{1, 1, 1},
+		buf: make([]byte, DATA_SIZE),
+	}
+}
+
+// Open the device at its address on the given bus. The MPU-9250 must be in
+// bypass mode for the AK8963 to be visible on the main bus.
+func Open(open i2c.Opener, bus int) (*AK8963, error) {
+	dev, err := open(DEVICE_ADDRESS, bus)
+	if err != nil {
+		return nil, err
+	}
+	return New(dev), nil
+}
+
+// Close the connection to the device.
+func (ak *AK8963) Close() error {
+	return ak.i2c.Close()
+}
+
+// Verify checks that the device answers as an AK8963.
+func (ak *AK8963) Verify() error {
+	id, err := ak.i2c.ReadRegU8(REG_WIA)
+	if err != nil {
+		return err
+	}
+	if id != WIA_AK8963 {
+		return fmt.Errorf("ak8963: unexpected WIA %#02x", id)
+	}
+	return nil
+}
+
+// Reset all the registers, the device is powered down afterwards.
+func (ak *AK8963) Reset() error {
+	if err := ak.i2c.WriteRegU8(REG_CNTL2, PARAM_SRST); err != nil {
+		return err
+	}
+	time.Sleep(MODE_SETTLE_TIME)
+	ak.mode = MODE_POWER_DOWN
+	return nil
+}
+
+// SetMode switches the operation mode with 16 bits output. As required by
+// the datasheet, the device goes through power down first.
+func (ak *AK8963) SetMode(mode Mode) error {
+	if err := ak.i2c.WriteRegU8(REG_CNTL1, byte(MODE_POWER_DOWN)); err != nil {
+		return err
+	}
+	time.Sleep(MODE_SETTLE_TIME)
+	if mode != MODE_POWER_DOWN {
+		if err := ak.i2c.WriteRegU8(REG_CNTL1, PARAM_BIT_16|byte(mode)); err != nil {
+			return err
+		}
+		time.Sleep(MODE_SETTLE_TIME)
+	}
+	ak.mode = mode
+	return nil
+}
+
+// Mode returns the operation mode.
+func (ak *AK8963) Mode() Mode {
+	return ak.mode
+}
+
+// ReadAdjustment reads the sensitivity adjustment values from the fuse ROM.
+// The device is left powered down.
+func (ak *AK8963) ReadAdjustment() ([3]float64, error) {
+	if err := ak.SetMode(MODE_FUSE_ROM); err != nil {
+		return ak.asa, err
+	}
+	buf := make([]byte, 3)
+	if _, err := ak.i2c.Write([]byte{REG_ASAX}); err != nil {
+		return ak.asa, err
+	}
+	if _, err := ak.i2c.Read(buf); err != nil {
+		return ak.asa, err
+	}
+	for i, asa := range buf {
+		ak.asa[i] = (float64(asa)-128)*0.5/128 + 1
+	}
+	return ak.asa, ak.SetMode(MODE_POWER_DOWN)
+}
+
+// Adjustment returns the sensitivity adjustment factors.
+func (ak *AK8963) Adjustment() [3]float64 {
+	return ak.asa
+}
+
+// Decode the DATA_SIZE bytes from ST1 to ST2, as read by ReadSample or by
+// the MPU-9250 i2c master into EXT_SENS_DATA. It returns ErrOverflow along
+// with the sample if HOFL is set.
+func Decode(buf []byte) (Sample, error) {
+	s := Sample{
+		X:       int16(uint16(buf[2])<<8 | uint16(buf[1])),
+		Y:       int16(uint16(buf[4])<<8 | uint16(buf[3])),
+		Z:       int16(uint16(buf[6])<<8 | uint16(buf[5])),
+		Ready:   buf[0]&PARAM_DRDY != 0,
+		Overrun: buf[0]&PARAM_DOR != 0,
+	}
+	if buf[7]&PARAM_HOFL != 0 {
+		return s, ErrOverflow
+	}
+	return s, nil
+}
+
+// ReadSample reads ST1, the measurement and ST2 in one burst. Reading ST2
+// lets the device update the data registers again.
+func (ak *AK8963) ReadSample() (Sample, error) {
+	if _, err := ak.i2c.Write([]byte{REG_ST1}); err != nil {
+		return Sample{}, err
+	}
+	if _, err := ak.i2c.Read(ak.buf); err != nil {
+		return Sample{}, err
+	}
+	return Decode(ak.buf)
+}
+
+// MicroTesla converts raw counts to uT with the sensitivity adjustment.
+func (ak *AK8963) MicroTesla(s Sample) Field {
+	return Field{
+		X: float64(s.X) * ak.asa[0] * SENSITIVITY,
+		Y: float64(s.Y) * ak.asa[1] * SENSITIVITY,
+		Z: float64(s.Z) * ak.asa[2] * SENSITIVITY,
+	}
+}
+
+// Aligned returns the field in the accelerometer and gyroscope axes of the
+// MPU-9250: the AK8963 X and Y are swapped and its Z points the other way.
+func (f Field) Aligned() Field {
+	return Field{X: f.Y, Y: f.X, Z: -f.Z}
+}
+
+// Configuration registers, as recorded in the metadata of the acquisitions.
+// The sensitivity adjustment values are only readable in fuse ROM mode, see
+// Adjustment.
+var CONFIG_REGISTERS = map[byte]string{
+	REG_CNTL1:  "CNTL1",
+	REG_ASTC:   "ASTC",
+	REG_I2CDIS: "I2CDIS",
+}
+
+// ReadConfig reads the configuration registers.
+func (ak *AK8963) ReadConfig() ([]i2c.Register, error) {
+	return i2c.ReadRegisters(ak.i2c, CONFIG_REGISTERS)
+}
@@ -0,0 +1,89 @@
+package ak8963
+
+import (
+	"../i2c"
+	"math"
+	"sync"
+	"time"
+)
+
+// A simulated AK8963 on top of a register file.
+type simAK struct {
+	dev  *i2c.SimDevice
+	asa  [3]byte
+	fn   func() Field
+	mu   sync.Mutex
+	mode Mode
+	last time.Time // last measurement
+	data []byte    // last measurement, ST1 to ST2
+}
+
+// Simulate makes a simulated i2c device behave like an AK8963 with the
+// given fuse ROM sensitivity adjustment values. In the continuous modes a
+// measurement is taken from fn, in uT and in the AK8963 axes, at the
+// configured rate and is reported with DRDY on the next read from ST1.
+func Simulate(dev *i2c.SimDevice, asa [3]byte, fn func() Field) {
+	sim := &simAK{dev: dev, asa: asa, fn: fn}
+	sim.powerOn()
+	dev.OnWrite(sim.onWrite)
+	dev.Generate(REG_ST1, sim.read)
+}
+
+func (sim *simAK) powerOn() {
+	for reg := 0; reg < 256; reg++ {
+		sim.dev.Set(byte(reg), 0)
+	}
+	sim.dev.Set(REG_WIA, WIA_AK8963)
+	sim.dev.Set(REG_ASAX, sim.asa[:]...)
+	sim.mu.Lock()
+	sim.mode = MODE_POWER_DOWN
+	sim.data = make([]byte, DATA_SIZE)
+	sim.mu.Unlock()
+}
+
+func (sim *simAK) onWrite(reg byte, value byte) {
+	switch reg {
+	case REG_CNTL1:
+		sim.mu.Lock()
+		sim.mode = Mode(value & PARAM_MODE_MASK)
+		sim.last = time.Now()
+		sim.mu.Unlock()
+	case REG_CNTL2:
+		if value&PARAM_SRST != 0 {
+			sim.powerOn()
+		}
+	}
+}
+
+// Take a measurement if one is due and return ST1 to ST2.
+func (sim *simAK) read() []byte {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	st1 := byte(0)
+	if rate := sim.mode.Rate(); rate > 0 {
+		period := time.Second / time.Duration(rate)
+		if due := time.Since(sim.last) / period; due > 0 {
+			st1 = PARAM_DRDY
+			if due > 1 {
+				st1 |= PARAM_DOR
+			}
+			sim.last = sim.last.Add(due * period)
+			sim.measure()
+		}
+	}
+	out := append([]byte{st1}, sim.data[1:]...)
+	return out
+}
+
+func (sim *simAK) measure() {
+	f := sim.fn()
+	var st2 byte = PARAM_BITM
+	if math.Abs(f.X)+math.Abs(f.Y)+math.Abs(f.Z) > 4912 {
+		st2 |= PARAM_HOFL
+	}
+	counts := func(v float64, asa byte) int16 {
+		return int16(v / SENSITIVITY / ((float64(asa)-128)*0.5/128 + 1))
+	}
+	x, y, z := counts(f.X, sim.asa[0]), counts(f.Y, sim.asa[1]), counts(f.Z, sim.asa[2])
+	sim.data = []byte{0, byte(x), byte(uint16(x) >> 8), byte(y), byte(uint16(y) >> 8), byte(z), byte(uint16(z) >> 8), st2}
+}
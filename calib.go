@@ -1,104 +1,69 @@
+// calib calibrates the accelerometer of the knob in six positions and saves
+// the profile of the device, applied by knobID, then records like knobID.
+// With -offsets the accelerometer and gyroscope biases are cancelled by the
+// offset registers of the MPU9250, saved in the profile too: knobID writes
+// them again at every start, -load does it for the other tools. With
+// -warmup the knob is then left still while it warms up and the drift of
+// the biases with the temperature is fitted, knobID compensates it. With
+// -ui the calibration is shown on the terminal: the deviation of every axis
+// from the position to reach, the instruction, the time left and the
+// residuals at the end.
+//
+//	go run calib.go -acc 8 -rec=false
+//	go run calib.go -misalign -profile data/calibration/knob2.json
+//	go run calib.go -offsets -rec=false
+//	go run calib.go -load
+//	go run calib.go -warmup 15m -rec=false
+//	go run calib.go -ui -offsets -rec=false
+//	go run calib.go -sim -rec=false
+//
 // derived from d2r2, hathan-osman and mrmorphic
 
 package main
 
 import (
+	"./calibration"
 	"./gpio"
 	"./i2c"
+	"./metadata"
+	"./mpu9250"
+	"./termui"
 	//"bufio"
 	"flag"
 	"fmt"
 	"log"
 	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"time"
 )
 
-const (
-	// based on mrmorphic/hwio/gy520.go
-	// This is the default address. Some devices may also respond to 0x69
-	DEVICE_ADDRESS = 0x68
-
-	REG_CONFIG         = 0x1a
-	REG_GYRO_CONFIG    = 0x1b
-	REG_ACCEL_CONFIG   = 0x1c
-	REG_ACCEL_CONFIG_2 = 0x1d
-
-	// accelerometer sensor registers, read-only
-	REG_ACCEL_XOUT_H = 0x3b
-	REG_ACCEL_XOUT_L = 0x3c
-	REG_ACCEL_YOUT_H = 0x3d
-	REG_ACCEL_YOUT_L = 0x3e
-	REG_ACCEL_ZOUT_H = 0x3f
-	REG_ACCEL_ZOUT_L = 0x40
-
-	// temperature sensor registers, read-only
-	REG_TEMP_OUT_H = 0x41
-	REG_TEMP_OUT_L = 0x42
-
-	// gyroscope sensor registers, read-only
-	REG_GYRO_XOUT_H = 0x43
-	REG_GYRO_XOUT_L = 0x44
-	REG_GYRO_YOUT_H = 0x45
-	REG_GYRO_YOUT_L = 0x46
-	REG_GYRO_ZOUT_H = 0x47
-	REG_GYRO_ZOUT_L = 0x48
-
-	REG_PWR_MGMT_1 = 0x6b
-
-	PARAM_SLEEP = 0x40
-
-	PARAM_ACCEL_FS_2G     = 0x00
-	PARAM_ACCEL_FS_4G     = 0x08
-	PARAM_ACCEL_FS_8G     = 0x10
-	PARAM_ACCEL_FS_16G    = 0x18
-	PARAM_ACCEL_FCHOICE_b = 0x08
-
-	SENSITIVITY_ACCEL_SF_FS_2G  = 16384.0
-	SENSITIVITY_ACCEL_SF_FS_4G  = 8192.0
-	SENSITIVITY_ACCEL_SF_FS_8G  = 4096.0
-	SENSITIVITY_ACCEL_SF_FS_16G = 2048.0
-
-	PARAM_GYRO_FS_250  = 0x00
-	PARAM_GYRO_FS_500  = 0x08
-	PARAM_GYRO_FS_1000 = 0x10
-	PARAM_GYRO_FS_2000 = 0x18
-
-	SENSITIVITY_GYRO_SF_FS_250  = 131.0
-	SENSITIVITY_GYRO_SF_FS_500  = 65.5
-	SENSITIVITY_GYRO_SF_FS_1000 = 32.8
-	SENSITIVITY_GYRO_SF_FS_2000 = 16.4
-
-	REG_CLOCK_PLL_XGYRO   = 0x01
-	REG_SMPLRT_DIV_CONFIG = 0x19
-	REG_SMPLRT_DIV        = 0x07
-)
-
-type ThreeDData struct {
-	X int16
-	Y int16
-	Z int16
-}
-
 type TimAccGyr struct {
 	Tim time.Duration
-	Acc ThreeDData //X, Y, Z  int16
-	Gyr ThreeDData //X, Y, Z  int16
+	Acc mpu9250.ThreeDData //X, Y, Z  int16
+	Gyr mpu9250.ThreeDData //X, Y, Z  int16
 }
 
 const (
-	DATA_CAP                  int    = 1000 //DATA_CAP initial capacity of slice Data
-	DATAFILE_EXTENSION        string = ".csv"
-	CALIBRATION_SAMPLE_PERIOD int    = 200 //milliseconds
-	BLINK_PERIOD              int    = 500 //milliseconds
+	DATA_CAP                int    = 1000 //DATA_CAP initial capacity of slice Data
+	DATAFILE_EXTENSION      string = ".csv"
+	CALIBRATION_SAMPLES     int    = 200 //averaged in every position
+	STILL_WINDOW            int    = 25  //samples checked together for the position and the stillness
+	CALIBRATION_READ_PERIOD        = 10 * time.Millisecond
+)
+
+// Bias the offset registers may leave, in their check.
+const (
+	OFFSET_ACC_TOLERANCE  = 0.005 //g
+	OFFSET_GYRO_TOLERANCE = 0.2   //o/s
 )
 
 // GPIO section =================
 // GPIO section =================
 // GPIO section =================
 
-func readIR(ir *gpio.Pin, led *gpio.Pin, presence chan<- bool) {
+func readIR(ir gpio.Pin, led gpio.Pin, presence chan<- bool) {
 
 	var valueOld = ir.GetStatus() //at beginning
 	var valueNow gpio.Value
@@ -115,22 +80,14 @@ func readIR(ir *gpio.Pin, led *gpio.Pin, presence chan<- bool) {
 	}
 }
 
-func blink(led *gpio.Pin, percent <-chan float64) {
-	//makes led to blink at frec frequency
-	//freq is in [0..1], where 0 means no blink, exit
-	var frequency int
-	for p := range percent {
-		if p == 0.0 {
+// Blink the led of the axis to turn up until the position is reached.
+func blinkAxis(leds [3]gpio.Pin, axis int, on bool) {
+	for i, led := range leds {
+		if i != axis || !on {
 			led.Write(gpio.LOW)
-			return
+		} else {
+			led.Toggle()
 		}
-		frequenc12
-		int(p * float64(BLINK_PERIOD/2))
-		log.Printf("frequency %d\n", frequency)
-		led.Write(gpio.HIGH)
-		time.Sleep(time.Duration(frequency) * time.Millisecond)
-		led.Write(gpio.LOW)
-		time.Sleep(time.Duration(frequency) * time.Millisecond)
 	}
 }
 
@@ -138,327 +95,439 @@ func blink(led *gpio.Pin, percent <-chan float64) {
 // MPU section ==================
 // MPU section ==================
 
-type MPU9250 struct {
-	i2c      *i2c.I2C
-	accel_fs int
-	gyro_fs  int
-}
-
-func NewMPU9250(devAddress int, a_fs int, g_fs int) (*MPU9250, error) {
-	thei2c, err := i2c.NewI2C(DEVICE_ADDRESS, devAddress)
+func checkError(err error) {
 	if err != nil {
-		return nil, err
+		log.Fatal(err)
 	}
-	theMPU := MPU9250{i2c: thei2c, accel_fs: a_fs, gyro_fs: g_fs}
-	return &theMPU, nil
 }
 
-// ConfigAcc Config the accelerometer Full Scale
-func (mpu *MPU9250) Config() error {
-	// config accel
-	v, e := mpu.i2c.ReadRegU8(REG_ACCEL_CONFIG)
-	if e != nil {
-		return e
-	}
-
-	v |= byte(PARAM_ACCEL_FS_16G) //has bits 4:3 set to 1
-	switch mpu.accel_fs {
-
-	case 2:
-		v &= byte(PARAM_ACCEL_FS_2G)
-	case 4:
-		v &= byte(PARAM_ACCEL_FS_4G)
-	case 8:
-		v &= byte(PARAM_ACCEL_FS_8G)
-	case 16:
-		v &= byte(PARAM_ACCEL_FS_16G)
-	default:
-		v &= byte(PARAM_ACCEL_FS_2G)
-	}
-
-	e = mpu.i2c.WriteRegU8(REG_ACCEL_CONFIG, v)
-	if e != nil {
-		return e
-	}
-
-	time.Sleep(10 * time.Millisecond)
-
-	// config gyro
-
-	v, e = mpu.i2c.ReadRegU8(REG_GYRO_CONFIG)
-	if e != nil {
-		return e
-	}
-
-	v |= byte(PARAM_GYRO_FS_2000) //has bits 4:3 set to 1
-	switch mpu.gyro_fs {
-	case 2:
-		v &= byte(PARAM_GYRO_FS_250)
-	case 4:
-		v &= byte(PARAM_GYRO_FS_500)
-	case 8:
-		v &= byte(PARAM_GYRO_FS_1000)
-	case 16:
-		v &= byte(PARAM_GYRO_FS_2000)
-	default:
-		v &= byte(PARAM_GYRO_FS_250)
-	}
-
-	e = mpu.i2c.WriteRegU8(REG_GYRO_CONFIG, v)
-	if e != nil {
-		return e
-	}
-
-	time.Sleep(10 * time.Millisecond)
-
-	return nil
+// Acceleration, g, rotation rate, o/s, and temperature, oC, of a sample.
+func readAccGyr(mpu *mpu9250.MPU9250) ([3]float64, [3]float64, float64, error) {
+	sample, err := mpu.ReadSample()
+	accSens, gyrSens := mpu.AccelFS().Sensitivity(), mpu.GyroFS().Sensitivity()
+	acc := [3]float64{float64(sample.Acc.X) / accSens, float64(sample.Acc.Y) / accSens, float64(sample.Acc.Z) / accSens}
+	gyr := [3]float64{float64(sample.Gyr.X) / gyrSens, float64(sample.Gyr.Y) / gyrSens, float64(sample.Gyr.Z) / gyrSens}
+	return acc, gyr, mpu9250.TempCelsius(sample.Temp), err
 }
 
-// Wake the device. By default on power on, the device is asleep.
-// based on mrmorphic/hwio/gy520.go
-func (mpu *MPU9250) Wake() error {
-	v, e := mpu.i2c.ReadRegU8(REG_PWR_MGMT_1)
-	if e != nil {
-		return e
-	}
-
-	v &= ^byte(PARAM_SLEEP)
+// Acceleration of a sample, g.
+func readAcc(mpu *mpu9250.MPU9250) ([3]float64, error) {
+	acc, _, _, err := readAccGyr(mpu)
+	return acc, err
+}
 
-	e = mpu.i2c.WriteRegU8(REG_PWR_MGMT_1, v)
-	if e != nil {
-		return e
+// Read a window of accelerations, g.
+func readWindow(mpu *mpu9250.MPU9250, n int) ([][3]float64, error) {
+	window := make([][3]float64, 0, n)
+	for len(window) < n {
+		acc, err := readAcc(mpu)
+		if err != nil {
+			return nil, err
+		}
+		window = append(window, acc)
+		time.Sleep(CALIBRATION_READ_PERIOD)
 	}
-
-	return nil
+	return window, nil
 }
 
-// Put the device back to sleep.
-// based on mrmorphic/hwio/gy520.go
-func (mpu *MPU9250) Sleep() error {
-	v, e := mpu.i2c.ReadRegU8(REG_PWR_MGMT_1)
-	if e != nil {
-		return e
+// calibrateAcc guides the operator through the six positions of the
+// calibration: the led of the axis to turn up or down blinks until the knob
+// is still in the position, stays on while the samples are averaged and
+// goes off when the position is done. The progress is reported along.
+func calibrateAcc(mpu *mpu9250.MPU9250, leds [3]gpio.Pin, misalignment bool, prog progress) (*calibration.Accel, error) {
+	var measures []calibration.Measure
+	for i, p := range calibration.POSITIONS {
+		prog.Position(i, p)
+		var samples [][3]float64
+		for len(samples) < CALIBRATION_SAMPLES {
+			window, err := readWindow(mpu, STILL_WINDOW)
+			if err != nil {
+				return nil, err
+			}
+			w := calibration.Average(p.Name, window)
+			at, ok := calibration.Orientation(w.Mean)
+			state := WINDOW_STILL
+			switch {
+			case !ok || at.Name != p.Name:
+				state = WINDOW_AWAY
+				samples = nil
+				blinkAxis(leds, p.Axis, true)
+			case !calibration.Still(window):
+				state = WINDOW_MOVING
+				samples = nil
+				blinkAxis(leds, p.Axis, true)
+			default:
+				leds[p.Axis].Write(gpio.HIGH)
+				samples = append(samples, window...)
+			}
+			prog.Window(p, w, state, len(samples))
+		}
+		m := calibration.Average(p.Name, samples)
+		prog.Measured(m)
+		measures = append(measures, m)
+		blinkAxis(leds, p.Axis, false)
 	}
-
-	v |= ^byte(PARAM_SLEEP)
-
-	e = mpu.i2c.WriteRegU8(REG_PWR_MGMT_1, v)
-	if e != nil {
-		return e
+	acc, err := calibration.Solve(measures, misalignment)
+	if err != nil {
+		return nil, err
 	}
-
-	return nil
+	acc.FS = mpu.AccelFS().G()
+	if acc.Temp, err = mpu.Temperature(); err != nil {
+		return nil, err
+	}
+	return acc, nil
 }
 
-func (mpu *MPU9250) GetAccGyroData() (accelX int, accelY int, accelZ int, gyroX int, gyroY int, gyroZ int, err error) {
-	// based on mrmorphic/hwio/gy520.go
-	//read the acc and gyro data in one step
-	var (
-		ax uint16
-		ay uint16
-		az uint16
-		gx uint16
-		gy uint16
-		gz uint16
-	)
+// readStill averages n samples taken while the knob is still.
+func readStill(mpu *mpu9250.MPU9250, n int) (calibration.ThermalPoint, error) {
+	var accs, gyrs [][3]float64
+	temp := 0.0
+	for len(accs) < n {
+		var accWindow, gyrWindow [][3]float64
+		windowTemp := 0.0
+		for len(accWindow) < STILL_WINDOW {
+			acc, gyr, t, err := readAccGyr(mpu)
+			if err != nil {
+				return calibration.ThermalPoint{}, err
+			}
+			accWindow, gyrWindow = append(accWindow, acc), append(gyrWindow, gyr)
+			windowTemp += t
+			time.Sleep(CALIBRATION_READ_PERIOD)
+		}
+		if !calibration.Still(accWindow) {
+			accs, gyrs, temp = nil, nil, 0
+			log.Printf("Waiting: keep the knob still")
+			continue
+		}
+		accs, gyrs = append(accs, accWindow...), append(gyrs, gyrWindow...)
+		temp += windowTemp
+	}
+	return calibration.ThermalPoint{
+		Temp: temp / float64(len(accs)),
+		Acc:  calibration.Average("", accs).Mean,
+		Gyr:  calibration.Average("", gyrs).Mean,
+	}, nil
+}
 
-	_, err = mpu.i2c.Write([]byte{REG_ACCEL_XOUT_H})
+// calibrateOffsets cancels the bias of the accelerometer calibration and
+// the one of the gyroscope, measured with the knob still, in the offset
+// registers, then measures again, in one of the positions of the
+// calibration, to check they are gone. The calibration is shifted to the
+// outputs with the offsets. If the check fails the previous offsets are
+// written back.
+func calibrateOffsets(mpu *mpu9250.MPU9250, acc *calibration.Accel) (*calibration.Hardware, error) {
+	log.Printf("Offset registers: keep the knob still")
+	still, err := readStill(mpu, CALIBRATION_SAMPLES)
 	if err != nil {
-		return 0, 0, 0, 0, 0, 0, err //error!
+		return nil, err
 	}
-	buf := make([]byte, 14) //to store 14 bytes
-	_, err = mpu.i2c.Read(buf)
+	gyrBias := still.Gyr
+	previous, err := mpu.ReadOffsets()
 	if err != nil {
-		return 0, 0, 0, 0, 0, 0, err //error!
+		return nil, err
 	}
-	ax = uint16(buf[0])<<8 | uint16(buf[1])
-	if ax > 32767 {
-		ax = ^ax + 0x01
-		accelX = -1 * int(ax)
-	} else {
-		accelX = int(ax)
+	offsets, err := mpu.WriteOffsets(previous.Cancel(acc.Bias, gyrBias))
+	if err != nil {
+		return nil, err
 	}
-
-	ay = uint16(buf[2])<<8 | uint16(buf[3])
-	if ay > 32767 {
-		ay = ^ay + 0x01
-		accelY = -1 * int(ay)
-	} else {
-		accelY = int(ay)
+	accMoved, _ := offsets.Sub(previous)
+	time.Sleep(mpu9250.SETTLE_TIME)
+	var after calibration.ThermalPoint
+	var before *calibration.Measure
+	for before == nil {
+		log.Printf("Check of the offsets: put the knob in a position of the calibration")
+		if after, err = readStill(mpu, CALIBRATION_SAMPLES); err != nil {
+			return nil, err
+		}
+		if p, ok := calibration.Orientation(after.Acc); ok {
+			for i := range acc.Measures {
+				if acc.Measures[i].Position == p.Name {
+					before = &acc.Measures[i]
+				}
+			}
+		}
 	}
-
-	az = uint16(buf[4])<<8 | uint16(buf[5])
-	if az > 32767 {
-		az = ^az + 0x01
-		accelZ = -1 * int(az)
-	} else {
-		accelZ = int(az)
+	hw := &calibration.Hardware{AccBias: acc.Bias, GyrBias: gyrBias, GyrResidual: after.Gyr, Written: time.Now()}
+	hw.Accel, hw.Gyro = offsets.Counts()
+	for i := range hw.AccResidual {
+		hw.AccResidual[i] = acc.Bias[i] + after.Acc[i] - before.Mean[i]
+		if math.Abs(hw.AccResidual[i]) > OFFSET_ACC_TOLERANCE || math.Abs(hw.GyrResidual[i]) > OFFSET_GYRO_TOLERANCE {
+			if _, err := mpu.WriteOffsets(previous); err != nil {
+				log.Printf("Error writing back the offsets: %v", err)
+			}
+			return nil, fmt.Errorf("offsets not verified, bias left %.4f g, %.3f o/s", hw.AccResidual, hw.GyrResidual)
+		}
 	}
+	acc.Shift(accMoved)
+	return hw, nil
+}
 
-	gx = uint16(buf[8])<<8 | uint16(buf[9])
-	if gx > 32767 {
-		gx = ^gx + 0x01
-		gyroX = -1 * int(gx)
-	} else {
-		gyroX = int(gx)
+// calibrateThermal records the knob still in a position while it warms up,
+// for the duration, and fits the drift of the biases from ref, the
+// temperature of the accelerometer calibration. The led toggles at every
+// point recorded.
+func calibrateThermal(mpu *mpu9250.MPU9250, led gpio.Pin, duration time.Duration, ref float64) (*calibration.Thermal, error) {
+	log.Printf("Warm-up for %v: leave the knob still in a position", duration)
+	var points []calibration.ThermalPoint
+	position := ""
+	for start := time.Now(); time.Since(start) < duration; {
+		point, err := readStill(mpu, CALIBRATION_SAMPLES)
+		if err != nil {
+			return nil, err
+		}
+		at, ok := calibration.Orientation(point.Acc)
+		if position == "" && ok {
+			position = at.Name
+		}
+		if !ok || at.Name != position {
+			log.Printf("Warm-up: knob moved from %s, point skipped", position)
+			continue
+		}
+		points = append(points, point)
+		led.Toggle()
+		log.Printf("Warm-up %s: %.2f oC, %.4f g, %.3f o/s", position, point.Temp, point.Acc, point.Gyr)
 	}
+	led.Write(gpio.LOW)
+	return calibration.FitThermal(points, ref)
+}
 
-	gy = uint16(buf[10])<<8 | uint16(buf[11])
-	if gy > 32767 {
-		gy = ^gy + 0x01
-		gyroY = -1 * int(gy)
-	} else {
-		gyroY = int(gy)
-	}
+// Progress section =============
+// Progress section =============
+// Progress section =============
 
-	gz = uint16(buf[12])<<8 | uint16(buf[13])
-	if gz > 32767 {
-		gz = ^gz + 0x01
-		gyroZ = -1 * int(gz)
-	} else {
-		gyroZ = int(gz)
-	}
+// State of the knob in a window of the position to reach.
+type windowState int
 
-	return accelX, accelY, accelZ, gyroX, gyroY, gyroZ, nil
-}
+const (
+	WINDOW_AWAY   windowState = iota //not in the position
+	WINDOW_MOVING                    //in the position, not still
+	WINDOW_STILL                     //averaged
+)
+
+// Scales of the bars of the terminal, and the lines of the log kept under.
+const (
+	UI_DEVIATION_SCALE = 0.25 //g
+	UI_RESIDUAL_SCALE  = 0.01 //g
+	UI_BAR_WIDTH       = 30
+	UI_LOG_LINES       = 6
+)
 
-/*
-// These functions are wrong!!!
-// These functions are wrong!!!
-// These functions are wrong!!!
+// progress of the calibration for the operator, besides the leds.
+type progress interface {
+	Position(i int, p calibration.Position) //to reach
+	Window(p calibration.Position, w calibration.Measure, state windowState, collected int)
+	Measured(m calibration.Measure)
+	Stage(title, instruction string) //after the positions
+	Report(profile *calibration.Profile)
+}
 
-func (mpu *MPU9250) GetData() (accelX int, accelY int, accelZ int, gyroX int, gyroY int, gyroZ int) {
-	// based on mrmorphic/hwio/gy520.go
+// logProgress reports in the log, for headless use.
+type logProgress struct {
+	collected int
+}
 
-	theAccelX, _ := mpu.i2c.ReadRegU16BE(REG_ACCEL_XOUT_H)
-	theAccelY, _ := mpu.i2c.ReadRegU16BE(REG_ACCEL_YOUT_H)
-	theAccelZ, _ := mpu.i2c.ReadRegU16BE(REG_ACCEL_ZOUT_H)
-	theGyroX, _ := mpu.i2c.ReadRegU16BE(REG_GYRO_XOUT_H)
-	theGyroY, _ := mpu.i2c.ReadRegU16BE(REG_GYRO_YOUT_H)
-	theGyroZ, _ := mpu.i2c.ReadRegU16BE(REG_GYRO_ZOUT_H)
+func (l *logProgress) Position(i int, p calibration.Position) {
+	log.Printf("Position %d of %d, %s: %s", i+1, len(calibration.POSITIONS), p.Name, p.Instruction)
+	l.collected = 0
+}
 
-	return int(theAccelX), int(theAccelY), int(theAccelZ), int(theGyroX), int(theGyroY), int(theGyroZ)
+func (l *logProgress) Window(p calibration.Position, w calibration.Measure, state windowState, collected int) {
+	switch state {
+	case WINDOW_AWAY:
+		if l.collected > 0 {
+			log.Printf("Position lost, %s", p.Instruction)
+		}
+		log.Printf("Waiting for %s: %.3f, %.3f, %.3f g", p.Name, w.Mean[0], w.Mean[1], w.Mean[2])
+	case WINDOW_MOVING:
+		log.Printf("Waiting for %s: keep the knob still", p.Name)
+	}
+	l.collected = collected
 }
 
+func (l *logProgress) Measured(m calibration.Measure) {
+	log.Printf("Position %s: %.4f, %.4f, %.4f g (std %.4f, %.4f, %.4f)", m.Position, m.Mean[0], m.Mean[1], m.Mean[2], m.Std[0], m.Std[1], m.Std[2])
+}
 
-func (mpu *MPU9250) GetAccel() (accelX int, accelY int, accelZ int, e error) {
-	// based on mrmorphic/hwio/gy520.go
+// Stage is in the log of the stage already.
+func (l *logProgress) Stage(title, instruction string) {}
 
-	theAccelX, err := mpu.i2c.ReadRegU16BE(REG_ACCEL_XOUT_H)
-	if err != nil {
-		return 0, 0, 0, err
+func (l *logProgress) Report(profile *calibration.Profile) {
+	if acc := profile.Accel; acc != nil {
+		log.Printf("Bias %.4f, %.4f, %.4f g", acc.Bias[0], acc.Bias[1], acc.Bias[2])
+		log.Printf("Scale %.4f, %.4f, %.4f", acc.Scale[0], acc.Scale[1], acc.Scale[2])
+		if acc.Misalignment != nil {
+			for _, row := range acc.Misalignment {
+				log.Printf("Misalignment %.4f, %.4f, %.4f", row[0], row[1], row[2])
+			}
+		}
+		for i, r := range acc.Residuals() {
+			log.Printf("Position %s: magnitude error %+.4f g", acc.Measures[i].Position, r)
+		}
+		log.Printf("Residual %.4f g RMS", acc.Residual)
 	}
-	theAccelY, err := mpu.i2c.ReadRegU16BE(REG_ACCEL_YOUT_H)
-	if err != nil {
-		return 0, 0, 0, err
+	if h := profile.Hardware; h != nil {
+		log.Printf("Offset registers: accel %v, gyro %v", h.Accel, h.Gyro)
+		log.Printf("Bias cancelled %.4f g, %.3f o/s, left %.4f g, %.3f o/s", h.AccBias, h.GyrBias, h.AccResidual, h.GyrResidual)
 	}
-	theAccelZ, err := mpu.i2c.ReadRegU16BE(REG_ACCEL_ZOUT_H)
-	if err != nil {
-		return 0, 0, 0, err
+	if t := profile.Thermal; t != nil {
+		log.Printf("Temperature from %.2f to %.2f oC, %d points", t.Min, t.Max, len(t.Points))
+		log.Printf("Drift %.5f g/oC (residual %.4f g), %.4f o/s/oC (residual %.3f o/s) from %.1f oC",
+			t.Acc.Slope, t.Acc.Residual, t.Gyr.Slope, t.Gyr.Residual, t.Ref)
 	}
+}
 
-	return int(theAccelX), int(theAccelY), int(theAccelZ), nil
+// termProgress draws the calibration on the terminal, the log under it:
+// the deviation of every axis from the position to reach, the instruction
+// and the time until the position is measured.
+type termProgress struct {
+	screen *termui.Screen
+	index  int
 }
 
-func (mpu *MPU9250) GetGyro() (gyroX int, gyroY int, gyroZ int, e error) {
-	// based on mrmorphic/hwio/gy520.go
+func (t *termProgress) Position(i int, p calibration.Position) {
+	t.index = i
+	t.Window(p, calibration.Measure{Position: p.Name}, WINDOW_AWAY, 0)
+}
 
-	theGyroX, err := mpu.i2c.ReadRegU16BE(REG_GYRO_XOUT_H)
-	if err != nil {
-		return 0, 0, 0, err
-	}
-	theGyroY, err := mpu.i2c.ReadRegU16BE(REG_GYRO_YOUT_H)
-	if err != nil {
-		return 0, 0, 0, err
-	}
-	theGyroZ, err := mpu.i2c.ReadRegU16BE(REG_GYRO_ZOUT_H)
-	if err != nil {
-		return 0, 0, 0, err
+func (t *termProgress) header(p calibration.Position) []string {
+	n := len(calibration.POSITIONS)
+	return []string{
+		fmt.Sprintf("Position %d of %d  %s  %s", t.index+1, n, termui.Color(termui.BOLD, p.Name), termui.Gauge(float64(t.index)/float64(n), n*2)),
+		"  " + p.Instruction,
+		"",
 	}
-
-	return int(theGyroX), int(theGyroY), int(theGyroZ), nil
 }
 
-*/
-func checkError(err error) {
-	if err != nil {
-		log.Fatal(err)
+func (t *termProgress) Window(p calibration.Position, w calibration.Measure, state windowState, collected int) {
+	body := t.header(p)
+	body = append(body, fmt.Sprintf("     deviation, g  %-*s  std, g", UI_BAR_WIDTH+2, fmt.Sprintf("(%.2f g full bar)", UI_DEVIATION_SCALE)))
+	target := p.Gravity()
+	for i := range target {
+		d := w.Mean[i] - target[i]
+		color := termui.GREEN
+		if math.Abs(d) > 1-calibration.ORIENTATION_THRESHOLD {
+			color = termui.RED
+		}
+		stdColor := termui.GREEN
+		if w.Std[i] >= calibration.STILL_THRESHOLD {
+			stdColor = termui.YELLOW
+		}
+		body = append(body, fmt.Sprintf("  %c  %+8.4f      %s  %s", 'X'+i, d,
+			termui.Color(color, termui.Bar(d, UI_DEVIATION_SCALE, UI_BAR_WIDTH)), termui.Color(stdColor, fmt.Sprintf("%.4f", w.Std[i]))))
+	}
+	body = append(body, "")
+	left := time.Duration(CALIBRATION_SAMPLES-collected) * CALIBRATION_READ_PERIOD
+	switch state {
+	case WINDOW_AWAY:
+		body = append(body, termui.Color(termui.RED, "Turn the knob: "+p.Instruction))
+	case WINDOW_MOVING:
+		body = append(body, termui.Color(termui.YELLOW, fmt.Sprintf("Keep the knob still, then %.1f s", left.Seconds())))
+	default:
+		body = append(body, termui.Color(termui.GREEN, fmt.Sprintf("Stable in %.1f s  ", left.Seconds()))+
+			termui.Gauge(float64(collected)/float64(CALIBRATION_SAMPLES), UI_BAR_WIDTH))
 	}
+	t.screen.Set(body...)
 }
 
-func calibrateAcc(mpu *MPU9250, xled *gpio.Pin, yled *gpio.Pin, zled *gpio.Pin, precision float64) {
+func (t *termProgress) Measured(m calibration.Measure) {}
 
-	var (
-		maxAcc    int
-		lapseOnAx = make(chan float64, 4) // [0..1] 0 means no blink and exit
-		lapseOnAy = make(chan float64, 4) // [0..1] 0 means no blink and exit
-		lapseOnAz = make(chan float64, 4) // [0..1] 0 means no blink and exit
-		maxOfDev  int
-	)
+func (t *termProgress) Stage(title, instruction string) {
+	t.screen.Set(termui.Color(termui.BOLD, title), "  "+instruction)
+}
 
-	switch mpu.accel_fs {
-	case 2:
-		maxAcc = int(SENSITIVITY_ACCEL_SF_FS_2G)
-		maxOfDev = int(precision * SENSITIVITY_ACCEL_SF_FS_2G)
-	case 4:
-		maxAcc = int(SENSITIVITY_ACCEL_SF_FS_4G)
-		maxOfDev = int(precision * SENSITIVITY_ACCEL_SF_FS_4G)
-	case 8:
-		maxAcc = int(SENSITIVITY_ACCEL_SF_FS_8G)
-		maxOfDev = int(precision * SENSITIVITY_ACCEL_SF_FS_8G)
-	case 16:
-		maxAcc = int(SENSITIVITY_ACCEL_SF_FS_16G)
-		maxOfDev = int(precision * SENSITIVITY_ACCEL_SF_FS_16G)
-	}
-
-	go blink(xled, lapseOnAx)
-	go blink(yled, lapseOnAy)
-	go blink(zled, lapseOnAz)
-
-	devAx := maxOfDev
-	devAy := maxOfDev
-	devAz := maxOfDev
-	for devAx >= maxOfDev || devAy >= maxOfDev || devAz >= maxOfDev {
-		ax, ay, az, _, _, _, _ := mpu.GetAccGyroData()
-		log.Printf("%d, %d, %d\n", ax, ay, az)
-		//calculate desviation
-		//devAx
-		if int(math.Abs(float64(ax))) <= maxAcc { //TODO sometimes ax is > maxAx !!!
-			if int(math.Abs(float64(ax))) < maxAcc/2 {
-				devAx = int(math.Abs(float64(ax)))
-			} else {
-				devAx = maxAcc - int(math.Abs(float64(ax)))
+func (t *termProgress) Report(profile *calibration.Profile) {
+	var body []string
+	if acc := profile.Accel; acc != nil {
+		body = append(body, termui.Color(termui.BOLD, "Accelerometer"),
+			fmt.Sprintf("  position  magnitude error, g  (%.2f g full bar)", UI_RESIDUAL_SCALE))
+		for i, r := range acc.Residuals() {
+			color := termui.GREEN
+			if math.Abs(r) > UI_RESIDUAL_SCALE/2 {
+				color = termui.YELLOW
 			}
-			lapseOnAx <- float64(devAx) / float64(maxAcc/2)
+			body = append(body, fmt.Sprintf("  %-8s  %+.4f  %s", acc.Measures[i].Position, r,
+				termui.Color(color, termui.Bar(r, UI_RESIDUAL_SCALE, UI_BAR_WIDTH))))
 		}
-		//devAy
-		if int(math.Abs(float64(ay))) <= maxAcc { //TODO sometimes ax is > maxAx !!!
-			if int(math.Abs(float64(ay))) < maxAcc/2 {
-				devAy = int(math.Abs(float64(ay)))
-			} else {
-				devAy = maxAcc - int(math.Abs(float64(ay)))
+		body = append(body, fmt.Sprintf("  residual  %.4f g RMS", acc.Residual), "")
+		for i := range acc.Bias {
+			line := fmt.Sprintf("  %c  bias %+.4f g  scale %.4f", 'X'+i, acc.Bias[i], acc.Scale[i])
+			if acc.Misalignment != nil {
+				row := acc.Misalignment[i]
+				line += fmt.Sprintf("  misalignment %.4f, %.4f, %.4f", row[0], row[1], row[2])
 			}
-			lapseOnAy <- float64(devAy) / float64(maxAcc/2)
+			body = append(body, line)
 		}
-		//devAz
-		if int(math.Abs(float64(az))) <= maxAcc { //TODO sometimes ax is > maxAx !!!
-			if int(math.Abs(float64(az))) < maxAcc/2 {
-				devAz = int(math.Abs(float64(az)))
-			} else {
-				devAz = maxAcc - int(math.Abs(float64(az)))
-			}
-			lapseOnAz <- float64(devAz) / float64(maxAcc/2)
-		}
-		log.Printf("devAx %d [of %d, max %d] \n", devAx, maxOfDev, maxAcc/2)
-		log.Printf("devAy %d [of %d, max %d] \n", devAy, maxOfDev, maxAcc/2)
-		log.Printf("devAz %d [of %d, max %d] \n", devAz, maxOfDev, maxAcc/2)
-
-		time.Sleep(time.Duration(CALIBRATION_SAMPLE_PERIOD) * time.Millisecond)
 	}
-	lapseOnAx <- 0.0 //stop blink
-	lapseOnAy <- 0.0 //stop blink
-	lapseOnAz <- 0.0 //stop blink
+	if h := profile.Hardware; h != nil {
+		body = append(body, "", termui.Color(termui.BOLD, "Offset registers"),
+			fmt.Sprintf("  accel %v, gyro %v", h.Accel, h.Gyro),
+			fmt.Sprintf("  left %.4f g, %.3f o/s", h.AccResidual, h.GyrResidual))
+	}
+	if th := profile.Thermal; th != nil {
+		body = append(body, "", termui.Color(termui.BOLD, "Temperature drift"),
+			fmt.Sprintf("  %.2f to %.2f oC, %d points, from %.1f oC", th.Min, th.Max, len(th.Points), th.Ref),
+			fmt.Sprintf("  %.5f g/oC, residual %.4f g", th.Acc.Slope, th.Acc.Residual),
+			fmt.Sprintf("  %.4f o/s/oC, residual %.3f o/s", th.Gyr.Slope, th.Gyr.Residual))
+	}
+	t.screen.Set(body...)
+}
+
+// Simulation section ===========
+// Simulation section ===========
+// Simulation section ===========
+
+// Bias and scale of the simulated accelerometer, bias of the gyroscope, at
+// SIM_TEMP, and their drift per oC. The die warms up by SIM_TEMP_RISE after
+// SIM_WARMUP, the time of the six positions.
+var (
+	SIM_ACC_BIAS        = [3]float64{0.03, -0.02, 0.05}
+	SIM_ACC_SCALE       = [3]float64{1.02, 0.98, 1.01}
+	SIM_GYRO_BIAS       = [3]float64{1.2, -0.8, 0.5}
+	SIM_ACC_TEMP_SLOPE  = [3]float64{0.0008, -0.0005, 0.001}
+	SIM_GYRO_TEMP_SLOPE = [3]float64{0.03, -0.02, 0.01}
+	SIM_TEMP            = 25.0
+	SIM_TEMP_RISE       = 10.0
+	SIM_WARMUP          = 35 * time.Second
+)
 
+// newSimBus builds a simulated i2c bus with a MPU9250 turned through the
+// six positions of the calibration, 4 s in every one of them after 1 s of
+// handling, and warming up once they are done.
+func newSimBus() *i2c.Sim {
+	sim := i2c.NewSim()
+	start := time.Now()
+	mpu := sim.Device(mpu9250.DEVICE_ADDRESS)
+	mpu9250.Simulate(mpu, sim, func() mpu9250.Sample {
+		noise := func(scale float64) float64 {
+			return scale * (rand.Float64() - 0.5)
+		}
+		elapsed := time.Since(start)
+		p := calibration.POSITIONS[int(elapsed/(5*time.Second))%len(calibration.POSITIONS)]
+		g := p.Gravity()
+		shake := 0.002
+		if elapsed%(5*time.Second) < time.Second {
+			shake = 0.5
+		}
+		temp := SIM_TEMP
+		if elapsed > SIM_WARMUP {
+			temp += SIM_TEMP_RISE * (1 - math.Exp(-(elapsed-SIM_WARMUP).Seconds()/20))
+		}
+		accSens := mpu9250.AccelFS(mpu.Get(mpu9250.REG_ACCEL_CONFIG) & mpu9250.PARAM_ACCEL_FS_MASK).Sensitivity()
+		gyrSens := mpu9250.GyroFS(mpu.Get(mpu9250.REG_GYRO_CONFIG) & mpu9250.PARAM_GYRO_FS_MASK).Sensitivity()
+		var acc, gyr [3]int16
+		for i := range acc {
+			accBias := SIM_ACC_BIAS[i] + SIM_ACC_TEMP_SLOPE[i]*(temp-SIM_TEMP)
+			gyrBias := SIM_GYRO_BIAS[i] + SIM_GYRO_TEMP_SLOPE[i]*(temp-SIM_TEMP)
+			acc[i] = int16(((g[i]+noise(shake))*SIM_ACC_SCALE[i] + accBias) * accSens)
+			gyr[i] = int16((gyrBias + noise(2.0)) * gyrSens)
+		}
+		return mpu9250.Sample{
+			Acc:  mpu9250.ThreeDData{X: acc[0], Y: acc[1], Z: acc[2]},
+			Temp: int16((temp + noise(0.05) - mpu9250.TEMP_ROOM) * mpu9250.TEMP_SENSITIVITY),
+			Gyr:  mpu9250.ThreeDData{X: gyr[0], Y: gyr[1], Z: gyr[2]},
+		}
+	})
+	return sim
 }
 
 func main() {
@@ -488,11 +557,27 @@ func main() {
 	var accFSMAX float64
 	var gyrFS int
 	var gyrFSMAX float64
+	var misalign bool
+	var profileFile string
+	var record bool
+	var simulate bool
+	var writeOffsets bool
+	var loadOffsets bool
+	var warmup time.Duration
+	var useUI bool
 
 	flag.StringVar(&nameArg, "name", "event", "Name of the acquisition")
 	flag.StringVar(&dirArg, "dir", "data", "Directory where store acquisitions")
 	flag.IntVar(&accFS, "acc", 2, "Accelerometer full scale g (2, 4, 8, 16)")
 	flag.IntVar(&gyrFS, "gyro", 250, "Gyroscope full scale dps (250, 500, 1000, 20000)")
+	flag.BoolVar(&misalign, "misalign", false, "Estimate the misalignment of the axes too")
+	flag.StringVar(&profileFile, "profile", "", "Calibration profile, the one of the device in "+calibration.PROFILE_DIR+" if empty")
+	flag.BoolVar(&writeOffsets, "offsets", false, "Cancel the accelerometer and gyroscope biases in the offset registers of the MPU9250")
+	flag.BoolVar(&loadOffsets, "load", false, "Only write the offset registers saved in the profile, after a power off")
+	flag.DurationVar(&warmup, "warmup", 0, "Fit the drift of the biases with the temperature on a warm-up run of this long, none if 0")
+	flag.BoolVar(&useUI, "ui", false, "Show the calibration on the terminal, the leds still guide it")
+	flag.BoolVar(&record, "rec", true, "Record acquisitions after the calibration")
+	flag.BoolVar(&simulate, "sim", false, "Use a simulated MPU9250 and fake GPIO pins")
 
 	flag.Parse()
 
@@ -501,37 +586,28 @@ func main() {
 	log.Printf("\t Dir: %s", dirArg)
 	log.Printf("\t Acc: %d", accFS)
 	log.Printf("\t Gyro: %d", gyrFS)
+	log.Printf("\t Misalign: %t", misalign)
+	log.Printf("\t Offsets: %t", writeOffsets)
+	log.Printf("\t Load: %t", loadOffsets)
+	log.Printf("\t Warmup: %v", warmup)
+	log.Printf("\t UI: %t", useUI)
+	log.Printf("\t Sim: %t", simulate)
 
 	//set the vars regarding the args
 	acquisitionName = nameArg
 	dataDirectory = dirArg
 
 	//set the full scale dependinf of acc and gyr configuration
-	switch accFS {
-	case 2:
-		accFSMAX = SENSITIVITY_ACCEL_SF_FS_2G
-	case 4:
-		accFSMAX = SENSITIVITY_ACCEL_SF_FS_4G
-	case 8:
-		accFSMAX = SENSITIVITY_ACCEL_SF_FS_8G
-	case 16:
-		accFSMAX = SENSITIVITY_ACCEL_SF_FS_16G
-	default:
-		accFSMAX = SENSITIVITY_ACCEL_SF_FS_2G
-	}
-
-	switch gyrFS {
-	case 250:
-		gyrFSMAX = SENSITIVITY_GYRO_SF_FS_250
-	case 500:
-		gyrFSMAX = SENSITIVITY_GYRO_SF_FS_500
-	case 1000:
-		gyrFSMAX = SENSITIVITY_GYRO_SF_FS_1000
-	case 2000:
-		gyrFSMAX = SENSITIVITY_GYRO_SF_FS_2000
-	default:
-		gyrFSMAX = SENSITIVITY_GYRO_SF_FS_250
+	accelFS, err := mpu9250.AccelFSFromG(accFS)
+	if err != nil {
+		log.Printf("%v, set to %v", err, accelFS)
 	}
+	gyroFS, err := mpu9250.GyroFSFromDPS(gyrFS)
+	if err != nil {
+		log.Printf("%v, set to %v", err, gyroFS)
+	}
+	accFS, accFSMAX = accelFS.G(), accelFS.Sensitivity()
+	gyrFS, gyrFSMAX = gyroFS.DPS(), gyroFS.Sensitivity()
 
 	//create data dir if not exists
 	dataFilePath = filepath.Join(".", dataDirectory)
@@ -546,8 +622,13 @@ func main() {
 		PIN_IR         int = 17
 	)
 
+	openPin, open := gpio.Opener(gpio.OpenPin), i2c.Opener(i2c.Open)
+	if simulate {
+		openPin, open = gpio.FakeOpener, newSimBus().Open
+	}
+
 	//Red led
-	redLed, err := gpio.OpenPin(PIN_RED_LED, gpio.OUT)
+	redLed, err := openPin(PIN_RED_LED, gpio.OUT)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -555,7 +636,7 @@ func main() {
 	defer redLed.Write(gpio.LOW)
 
 	//Yellow led
-	yellowLed, err := gpio.OpenPin(PIN_YELLOW_LED, gpio.OUT)
+	yellowLed, err := openPin(PIN_YELLOW_LED, gpio.OUT)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -563,7 +644,7 @@ func main() {
 	defer yellowLed.Write(gpio.LOW)
 
 	//Green led
-	greenLed, err := gpio.OpenPin(PIN_GREEN_LED, gpio.OUT)
+	greenLed, err := openPin(PIN_GREEN_LED, gpio.OUT)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -572,7 +653,7 @@ func main() {
 
 	//ir
 	//open the pin in the GPIO
-	ir, err := gpio.OpenPin(PIN_IR, gpio.IN)
+	ir, err := openPin(PIN_IR, gpio.IN)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -582,37 +663,91 @@ func main() {
 	//create the MPU, open the i2c comm and set the accel and gyro full scale value
 	//var mpu MPU9250
 
-	mpu, err := NewMPU9250(1, accFS, gyrFS)
+	mpu, err := mpu9250.Open(open, 1, accelFS, gyroFS)
 	checkError(err)
 
-	defer mpu.i2c.Close()
+	defer mpu.Close()
 
-	mpu.Wake()
-	mpu.Config()
-	mpu.Wake()
+	if err := mpu.Verify(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	checkError(mpu.Wake())
+	checkError(mpu.Config())
+	checkError(mpu.Wake())
 
 	//test the sensor
-	_, _, _, _, _, _, err = mpu.GetAccGyroData()
+	_, err = mpu.ReadSample()
 	checkError(err)
 	log.Println("Sensor Ready!")
 
+	device := metadata.NewDevice()
+	if profileFile == "" {
+		profileFile = calibration.DefaultProfile(calibration.PROFILE_DIR, device)
+	}
+	profile, err := calibration.Load(profileFile)
+	if os.IsNotExist(err) && !loadOffsets {
+		profile, err = calibration.NewProfile(device), nil
+	}
+	checkError(err)
+
+	//the offset registers are lost at power off, write the saved ones again
+	if loadOffsets {
+		if profile.Hardware == nil {
+			log.Fatalf("No offset registers in %s, calibrate with -offsets", profileFile)
+		}
+		offsets, err := mpu.WriteOffsets(mpu9250.NewOffsets(profile.Hardware.Accel, profile.Hardware.Gyro))
+		checkError(err)
+		log.Printf("Offset registers of %s written: accel %v, gyro %v", profileFile, offsets.Accel, offsets.Gyro)
+		return
+	}
+	offsets, err := mpu.ReadOffsets()
+	checkError(err)
+
+	//progress in the log, or on the terminal with the log under it
+	var prog progress = &logProgress{}
+	var screen *termui.Screen
+	if useUI {
+		screen = termui.New(os.Stdout, "Knob calibration, "+profileFile, UI_LOG_LINES)
+		log.SetOutput(screen)
+		prog = &termProgress{screen: screen}
+	}
+
 	//CALIBRATE
 	log.Println("Calibrating the sensor")
-	precision := 0.001
-	calibrateAcc(mpu, redLed, yellowLed, greenLed, precision)
+	acc, err := calibrateAcc(mpu, [3]gpio.Pin{redLed, yellowLed, greenLed}, misalign, prog)
+	checkError(err)
+	if writeOffsets {
+		prog.Stage("Offset registers", "keep the knob still, then put it in a position of the calibration")
+		profile.Hardware, err = calibrateOffsets(mpu, acc)
+		checkError(err)
+	} else if h := profile.Hardware; h != nil && mpu9250.NewOffsets(h.Accel, h.Gyro) != offsets {
+		//the calibration was measured without them
+		log.Printf("Offset registers of the profile dropped, not in the device")
+		profile.Hardware = nil
+	}
+	if warmup > 0 {
+		prog.Stage(fmt.Sprintf("Warm-up for %v", warmup), "leave the knob still in a position")
+		profile.Thermal, err = calibrateThermal(mpu, greenLed, warmup, acc.Temp)
+		checkError(err)
+	} else if profile.Thermal != nil {
+		//the drift holds, from the temperature of the new calibration
+		profile.Thermal.Ref = acc.Temp
+		log.Printf("Temperature drift of the profile kept, from %.1f oC", acc.Temp)
+	}
+	profile.Accel = acc
+	checkError(profile.Save(profileFile))
+	prog.Report(profile)
+	log.Printf("Calibration saved in %s", profileFile)
+	if screen != nil {
+		screen.Close()
+		log.SetOutput(os.Stderr)
+	}
+	if !record {
+		return
+	}
 
 	//RUN
 	log.Println("Ready to read the sensor")
-	//var creation out of the loop
-	var (
-		ax uint16
-		ay uint16
-		az uint16
-		gx uint16
-		gy uint16
-		gz uint16
-	)
-	buf := make([]byte, 14) //to store 14 bytes
 	time0 := time.Now()
 	//i := 0
 
@@ -629,58 +764,12 @@ func main() {
 				//i = 0              //log purposes
 			}
 			//read the acc and gyro data in one step without err consideration
-			_, _ = mpu.i2c.Write([]byte{REG_ACCEL_XOUT_H})
-			_, _ = mpu.i2c.Read(buf)
+			sample, _ := mpu.ReadSample()
 			//time of readdings
 			thisData.Tim = time.Now().Sub(time0)
 			//data of readdings
-			ax = uint16(buf[0])<<8 | uint16(buf[1])
-			if ax > 32767 {
-				ax = ^ax + 0x01
-				thisData.Acc.X = -1 * int16(ax)
-			} else {
-				thisData.Acc.X = int16(ax)
-			}
-
-			ay = uint16(buf[2])<<8 | uint16(buf[3])
-			if ay > 32767 {
-				ay = ^ay + 0x01
-				thisData.Acc.Y = -1 * int16(ay)
-			} else {
-				thisData.Acc.Y = int16(ay)
-			}
-
-			az = uint16(buf[4])<<8 | uint16(buf[5])
-			if az > 32767 {
-				az = ^az + 0x01
-				thisData.Acc.Z = -1 * int16(az)
-			} else {
-				thisData.Acc.Z = int16(az)
-			}
-
-			gx = uint16(buf[8])<<8 | uint16(buf[9])
-			if gx > 32767 {
-				gx = ^gx + 0x01
-				thisData.Gyr.X = -1 * int16(gx)
-			} else {
-				thisData.Gyr.X = int16(gx)
-			}
-
-			gy = uint16(buf[10])<<8 | uint16(buf[11])
-			if gy > 32767 {
-				gy = ^gy + 0x01
-				thisData.Gyr.Y = -1 * int16(gy)
-			} else {
-				thisData.Gyr.Y = int16(gy)
-			}
-
-			gz = uint16(buf[12])<<8 | uint16(buf[13])
-			if gz > 32767 {
-				gz = ^gz + 0x01
-				thisData.Gyr.Z = -1 * int16(gz)
-			} else {
-				thisData.Gyr.Z = int16(gz)
-			}
+			thisData.Acc = sample.Acc
+			thisData.Gyr = sample.Gyr
 
 			//log.Printf("[%d]; %d; %f; %f; %f; %f; %f; %f\n",
 			//	i,
@@ -0,0 +1,386 @@
+// Package calibration estimates and applies the calibration of the sensors
+// of a knob. The accelerometer is calibrated in six static positions, every
+// axis pointing up and down in turn: at rest it only measures the gravity,
+// 1 g, so the means of the six positions give the bias and the scale of
+// every axis and, optionally, the misalignment between them. Calibrations
+// are kept in a profile per device, a JSON file applied by knobID. The
+// biases can also be cancelled by the offset registers of the device, the
+// profile keeps them to write them again at every start. The biases drift
+// with the temperature, fitted on a warm-up run, and the one of the
+// gyroscope is estimated while the knob is still too.
+package calibration
+
+import (
+	"../metadata"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Version of the profile layout.
+const FORMAT = 1
+
+// Folder of the profiles, one per device.
+const PROFILE_DIR = "data/calibration"
+
+// Standard deviation of the magnitude of the acceleration, g, under which
+// the device is taken as still.
+const STILL_THRESHOLD = 0.01
+
+// Minimum component, g, of the axis pointing up in a position.
+const ORIENTATION_THRESHOLD = 0.8
+
+// Position of the six position calibration, an axis pointing up or down.
+type Position struct {
+	Name        string
+	Axis        int     // 0, 1, 2 for X, Y, Z
+	Sign        float64 // 1 pointing up, -1 down
+	Instruction string
+}
+
+// Gravity measured in the position by a perfect accelerometer, g.
+func (p Position) Gravity() [3]float64 {
+	var g [3]float64
+	g[p.Axis] = p.Sign
+	return g
+}
+
+// Positions of the calibration, in the order they are taken.
+var POSITIONS = []Position{
+	{"+Z", 2, 1, "lay the knob flat, Z pointing up"},
+	{"-Z", 2, -1, "turn it upside down, Z pointing down"},
+	{"+X", 0, 1, "stand it on an edge, X pointing up"},
+	{"-X", 0, -1, "stand it on the opposite edge, X pointing down"},
+	{"+Y", 1, 1, "stand it on another edge, Y pointing up"},
+	{"-Y", 1, -1, "stand it on the last edge, Y pointing down"},
+}
+
+// Orientation returns the position an acceleration is closest to, and
+// whether its axis is clearly pointing up or down.
+func Orientation(acc [3]float64) (Position, bool) {
+	axis := 0
+	for i := range acc {
+		if math.Abs(acc[i]) > math.Abs(acc[axis]) {
+			axis = i
+		}
+	}
+	sign := 1.0
+	if acc[axis] < 0 {
+		sign = -1
+	}
+	for _, p := range POSITIONS {
+		if p.Axis == axis && p.Sign == sign {
+			return p, math.Abs(acc[axis]) >= ORIENTATION_THRESHOLD
+		}
+	}
+	return Position{}, false
+}
+
+// Measure is the mean of the samples taken in a position, g.
+type Measure struct {
+	Position string     `json:"position"`
+	Mean     [3]float64 `json:"mean"`
+	Std      [3]float64 `json:"std"`
+	Samples  int        `json:"samples"`
+}
+
+// Average measures samples of the acceleration in a position.
+func Average(position string, samples [][3]float64) Measure {
+	m := Measure{Position: position, Samples: len(samples)}
+	if len(samples) == 0 {
+		return m
+	}
+	n := float64(len(samples))
+	for _, s := range samples {
+		for i := range s {
+			m.Mean[i] += s[i]
+		}
+	}
+	for i := range m.Mean {
+		m.Mean[i] /= n
+	}
+	for _, s := range samples {
+		for i := range s {
+			m.Std[i] += (s[i] - m.Mean[i]) * (s[i] - m.Mean[i])
+		}
+	}
+	for i := range m.Std {
+		m.Std[i] = math.Sqrt(m.Std[i] / n)
+	}
+	return m
+}
+
+// Still reports whether samples of the acceleration were taken at rest,
+// from the standard deviation of their magnitude.
+func Still(samples [][3]float64) bool {
+	if len(samples) < 2 {
+		return false
+	}
+	mags := make([][3]float64, len(samples))
+	for i, s := range samples {
+		mags[i][0] = norm(s)
+	}
+	return Average("", mags).Std[0] < STILL_THRESHOLD
+}
+
+func norm(v [3]float64) float64 {
+	return math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+}
+
+// Accel is the calibration of the accelerometer. A measure a, in g, is
+// corrected as T ((a - Bias) / Scale), T being the Misalignment or the
+// identity.
+type Accel struct {
+	FS           int            `json:"fs"` // g, full scale it was measured at
+	Bias         [3]float64     `json:"bias"`
+	Scale        [3]float64     `json:"scale"`
+	Misalignment *[3][3]float64 `json:"misalignment,omitempty"`
+	Residual     float64        `json:"residual"` // g, RMS of the error of the magnitude in the positions
+	Measures     []Measure      `json:"measures"`
+	Temp         float64        `json:"temp,omitempty"` // oC, of the die at the end of the calibration
+}
+
+// Solve computes the calibration of the six positions. Without
+// misalignment every axis gets the bias and scale of its two positions,
+// with it the whole linear model of the six ones is inverted.
+func Solve(measures []Measure, misalignment bool) (*Accel, error) {
+	byName := make(map[string]*Measure)
+	for i := range measures {
+		byName[measures[i].Position] = &measures[i]
+	}
+	//up and down measures of every axis
+	var up, down [3][3]float64
+	for _, p := range POSITIONS {
+		m, ok := byName[p.Name]
+		if !ok || m.Samples == 0 {
+			return nil, fmt.Errorf("calibration: position %s not measured", p.Name)
+		}
+		if p.Sign > 0 {
+			up[p.Axis] = m.Mean
+		} else {
+			down[p.Axis] = m.Mean
+		}
+	}
+	a := &Accel{Measures: measures}
+	//the measure is A g + b: the columns of A are half the difference of
+	//the up and down measures of their axis, b their mean
+	var A [3][3]float64
+	for axis := 0; axis < 3; axis++ {
+		for i := 0; i < 3; i++ {
+			A[i][axis] = (up[axis][i] - down[axis][i]) / 2
+		}
+	}
+	for i := 0; i < 3; i++ {
+		a.Scale[i] = A[i][i]
+		if a.Scale[i] <= 0 {
+			return nil, fmt.Errorf("calibration: axis %c scale %g, positions swapped", 'X'+i, a.Scale[i])
+		}
+		if misalignment {
+			for axis := 0; axis < 3; axis++ {
+				a.Bias[i] += (up[axis][i] + down[axis][i]) / 6
+			}
+		} else {
+			a.Bias[i] = (up[i][i] + down[i][i]) / 2
+		}
+	}
+	if misalignment {
+		inv, ok := invert(A)
+		if !ok {
+			return nil, fmt.Errorf("calibration: singular misalignment")
+		}
+		//T = A^-1 diag(Scale)
+		var T [3][3]float64
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				T[i][j] = inv[i][j] * a.Scale[j]
+			}
+		}
+		a.Misalignment = &T
+	}
+	sum := 0.0
+	for _, r := range a.Residuals() {
+		sum += r * r
+	}
+	a.Residual = math.Sqrt(sum / float64(len(a.Measures)))
+	return a, nil
+}
+
+// invert a 3x3 matrix.
+func invert(m [3][3]float64) ([3][3]float64, bool) {
+	var inv [3][3]float64
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(det) < 1e-12 {
+		return inv, false
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			//cofactor of m[j][i]
+			r0, r1 := (j+1)%3, (j+2)%3
+			c0, c1 := (i+1)%3, (i+2)%3
+			inv[i][j] = (m[r0][c0]*m[r1][c1] - m[r0][c1]*m[r1][c0]) / det
+		}
+	}
+	return inv, true
+}
+
+// Apply corrects an acceleration, g.
+func (a *Accel) Apply(acc [3]float64) [3]float64 {
+	var v [3]float64
+	for i := range v {
+		v[i] = (acc[i] - a.Bias[i]) / a.Scale[i]
+	}
+	if a.Misalignment == nil {
+		return v
+	}
+	var r [3]float64
+	for i := range r {
+		for j := range v {
+			r[i] += a.Misalignment[i][j] * v[j]
+		}
+	}
+	return r
+}
+
+// Residuals are the errors of the magnitude of the corrected measures, g.
+func (a *Accel) Residuals() []float64 {
+	r := make([]float64, len(a.Measures))
+	for i, m := range a.Measures {
+		r[i] = norm(a.Apply(m.Mean)) - 1
+	}
+	return r
+}
+
+// Shift moves the calibration by an offset added to the measures, g, as
+// the offset registers of the device do.
+func (a *Accel) Shift(offset [3]float64) {
+	for i := range a.Bias {
+		a.Bias[i] += offset[i]
+	}
+	for m := range a.Measures {
+		for i := range offset {
+			a.Measures[m].Mean[i] += offset[i]
+		}
+	}
+}
+
+// Hardware are the offsets written in the offset registers of the MPU9250,
+// as register counts, and the biases they cancel. The accelerometer
+// calibration of the profile is measured with them.
+type Hardware struct {
+	Accel   [3]int16   `json:"accel"`   // XA_OFFSET to ZA_OFFSET, bit 0 as read
+	Gyro    [3]int16   `json:"gyro"`    // XG_OFFSET to ZG_OFFSET
+	AccBias [3]float64 `json:"accBias"` // g, cancelled
+	GyrBias [3]float64 `json:"gyrBias"` // o/s, cancelled
+	// left after writing them, in a fresh measure
+	AccResidual [3]float64 `json:"accResidual"` // g
+	GyrResidual [3]float64 `json:"gyrResidual"` // o/s
+	Written     time.Time  `json:"written"`
+}
+
+// Profile of the calibrations of a device.
+type Profile struct {
+	Format   int             `json:"format"`
+	Device   metadata.Device `json:"device"`
+	Updated  time.Time       `json:"updated"`
+	Accel    *Accel          `json:"accel,omitempty"`
+	Hardware *Hardware       `json:"hardware,omitempty"`
+	Thermal  *Thermal        `json:"thermal,omitempty"`
+}
+
+// NewProfile returns an empty profile of the device.
+func NewProfile(device metadata.Device) *Profile {
+	return &Profile{Format: FORMAT, Device: device}
+}
+
+// DeviceName is the file name of the profile of a device, from its serial
+// number or else its host name.
+func DeviceName(device metadata.Device) string {
+	name := device.Serial
+	if name == "" {
+		name = device.Host
+	}
+	if name == "" {
+		name = "default"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, name) + ".json"
+}
+
+// DefaultProfile is the profile file of a device in dir.
+func DefaultProfile(dir string, device metadata.Device) string {
+	return filepath.Join(dir, DeviceName(device))
+}
+
+// Load reads a profile file.
+func Load(name string) (*Profile, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	p := new(Profile)
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("calibration: %s: %v", name, err)
+	}
+	if p.Format > FORMAT {
+		return nil, fmt.Errorf("calibration: %s: format %d, newer than %d", name, p.Format, FORMAT)
+	}
+	return p, nil
+}
+
+// Save writes the profile, through a temporary file so it is never left
+// half written, creating its folder if needed.
+func (p *Profile) Save(name string) error {
+	p.Format, p.Updated = FORMAT, time.Now()
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// Offsets of the profile, for the metadata of the captures.
+func (p *Profile) Offsets() map[string][]float64 {
+	o := make(map[string][]float64)
+	if a := p.Accel; a != nil {
+		o["accBias"] = a.Bias[:]
+		o["accScale"] = a.Scale[:]
+		if a.Misalignment != nil {
+			var t []float64
+			for _, row := range a.Misalignment {
+				t = append(t, row[:]...)
+			}
+			o["accMisalignment"] = t
+		}
+	}
+	if h := p.Hardware; h != nil {
+		for _, reg := range []struct {
+			name   string
+			counts [3]int16
+		}{{"accOffsetRegisters", h.Accel}, {"gyrOffsetRegisters", h.Gyro}} {
+			o[reg.name] = []float64{float64(reg.counts[0]), float64(reg.counts[1]), float64(reg.counts[2])}
+		}
+	}
+	if t := p.Thermal; t != nil {
+		o["accTempSlope"] = t.Acc.Slope[:]
+		o["gyrTempSlope"] = t.Gyr.Slope[:]
+		o["tempRef"] = []float64{t.Ref}
+	}
+	return o
+}
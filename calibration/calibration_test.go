@@ -0,0 +1,137 @@
+package calibration
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// measures of the six positions by an accelerometer measuring A g + bias.
+func measures(A [3][3]float64, bias [3]float64) []Measure {
+	var ms []Measure
+	for _, p := range POSITIONS {
+		g := p.Gravity()
+		m := Measure{Position: p.Name, Samples: 100}
+		for i := range m.Mean {
+			m.Mean[i] = bias[i]
+			for j := range g {
+				m.Mean[i] += A[i][j] * g[j]
+			}
+		}
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+func near(a, b [3]float64, tol float64) bool {
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > tol {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSolve(t *testing.T) {
+	aligned := [3][3]float64{{1.02, 0, 0}, {0, 0.97, 0}, {0, 0, 1.05}}
+	misaligned := [3][3]float64{{1.02, 0.03, -0.01}, {-0.02, 0.97, 0.04}, {0.01, -0.03, 1.05}}
+	tests := []struct {
+		name         string
+		A            [3][3]float64
+		bias         [3]float64
+		misalignment bool
+		exact        bool // Apply recovers the gravity
+	}{
+		{"perfect", [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}, [3]float64{}, false, true},
+		{"bias and scale", aligned, [3]float64{0.05, -0.03, 0.12}, false, true},
+		{"bias and scale with misalignment", aligned, [3]float64{0.05, -0.03, 0.12}, true, true},
+		{"misaligned", misaligned, [3]float64{-0.04, 0.02, 0.08}, true, true},
+		{"misaligned without misalignment", misaligned, [3]float64{-0.04, 0.02, 0.08}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := measures(tt.A, tt.bias)
+			a, err := Solve(ms, tt.misalignment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !near(a.Bias, tt.bias, 1e-12) {
+				t.Errorf("bias %v, want %v", a.Bias, tt.bias)
+			}
+			if want := [3]float64{tt.A[0][0], tt.A[1][1], tt.A[2][2]}; !near(a.Scale, want, 1e-12) {
+				t.Errorf("scale %v, want %v", a.Scale, want)
+			}
+			if (a.Misalignment != nil) != tt.misalignment {
+				t.Fatalf("misalignment %v", a.Misalignment)
+			}
+			if a.Misalignment != nil {
+				//A T is diag(Scale)
+				for i := 0; i < 3; i++ {
+					for j := 0; j < 3; j++ {
+						v := 0.0
+						for k := 0; k < 3; k++ {
+							v += tt.A[i][k] * a.Misalignment[k][j]
+						}
+						want := 0.0
+						if i == j {
+							want = a.Scale[i]
+						}
+						if math.Abs(v-want) > 1e-12 {
+							t.Errorf("A T [%d][%d] %g, want %g", i, j, v, want)
+						}
+					}
+				}
+			}
+			//the positions and a direction in between
+			gs := [][3]float64{{0.6, -0.48, 0.64}}
+			for _, p := range POSITIONS {
+				gs = append(gs, p.Gravity())
+			}
+			for _, g := range gs {
+				var m [3]float64
+				for i := range m {
+					m[i] = tt.bias[i]
+					for j := range g {
+						m[i] += tt.A[i][j] * g[j]
+					}
+				}
+				if got := a.Apply(m); near(got, g, 1e-12) != tt.exact {
+					t.Errorf("apply %v: %v, want %v (exact %t)", m, got, g, tt.exact)
+				}
+			}
+			if tt.exact && a.Residual > 1e-12 {
+				t.Errorf("residual %g, want 0", a.Residual)
+			}
+			if !tt.exact && a.Residual < 1e-4 {
+				t.Errorf("residual %g of the misalignment not corrected", a.Residual)
+			}
+		})
+	}
+}
+
+func TestSolveErrors(t *testing.T) {
+	identity := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	swapped := measures(identity, [3]float64{})
+	swapped[0].Position, swapped[1].Position = swapped[1].Position, swapped[0].Position
+	empty := measures(identity, [3]float64{})
+	empty[3].Samples = 0
+	tests := []struct {
+		name         string
+		measures     []Measure
+		misalignment bool
+		want         string
+	}{
+		{"missing position", measures(identity, [3]float64{})[:5], false, "position -Y not measured"},
+		{"no samples", empty, false, "position -X not measured"},
+		{"swapped positions", swapped, false, "axis Z scale"},
+		{"singular", measures([3][3]float64{{1, 1, 0}, {1, 1, 0}, {0, 0, 1}}, [3]float64{}), true, "singular"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := Solve(tt.measures, tt.misalignment)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("solve %v, %v, want an error with %q", a, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package calibration
+
+import (
+	"math"
+	"time"
+)
+
+// Largest bias of the gyroscope, o/s: a still window averaging more is
+// taken as a slow rotation the accelerometer does not see.
+const GYRO_MAX_BIAS = 5.0
+
+// Weight of a new still window in the refinement of the gyroscope bias.
+const GYRO_BIAS_ALPHA = 0.2
+
+// GyroBias is the bias of the gyroscope, estimated from the windows of
+// samples taken while the knob is still: the first one sets it, the next
+// ones refine it with an exponential average.
+type GyroBias struct {
+	Bias    [3]float64 `json:"bias"`    // o/s
+	Windows int        `json:"windows"` // still windows averaged
+	Updated time.Time  `json:"updated"`
+}
+
+// Update refines the bias with a window of samples, the accelerations in g
+// and the rotation rates in o/s, if the accelerometer shows the knob was
+// still. It reports whether the window was used.
+func (b *GyroBias) Update(acc, gyr [][3]float64, t time.Time) bool {
+	if len(gyr) == 0 || !Still(acc) {
+		return false
+	}
+	mean := Average("", gyr).Mean
+	if norm(mean) > GYRO_MAX_BIAS {
+		return false
+	}
+	alpha := GYRO_BIAS_ALPHA
+	if b.Windows == 0 {
+		alpha = 1
+	}
+	for i := range b.Bias {
+		b.Bias[i] += alpha * (mean[i] - b.Bias[i])
+	}
+	b.Windows++
+	b.Updated = t
+	return true
+}
+
+// Counts of the bias at a sensitivity, LSB/(o/s).
+func (b *GyroBias) Counts(sens float64) [3]int16 {
+	var c [3]int16
+	for i, v := range b.Bias {
+		c[i] = int16(math.Round(v * sens))
+	}
+	return c
+}
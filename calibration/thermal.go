@@ -0,0 +1,96 @@
+package calibration
+
+import (
+	"fmt"
+	"math"
+)
+
+// Smallest span of temperature, oC, of a warm-up run to fit the drift.
+const THERMAL_MIN_SPAN = 2.0
+
+// ThermalPoint is the mean of a still window of the warm-up run.
+type ThermalPoint struct {
+	Temp float64    `json:"temp"` // oC
+	Acc  [3]float64 `json:"acc"`  // g
+	Gyr  [3]float64 `json:"gyr"`  // o/s
+}
+
+// Drift of a bias with the temperature, a straight line per axis.
+type Drift struct {
+	Slope    [3]float64 `json:"slope"`    // per oC
+	Residual [3]float64 `json:"residual"` // RMS of the points around the line
+}
+
+// At is the drift at temp from ref.
+func (d Drift) At(temp, ref float64) [3]float64 {
+	var v [3]float64
+	for i := range v {
+		v[i] = d.Slope[i] * (temp - ref)
+	}
+	return v
+}
+
+// Thermal is the drift of the accelerometer and gyroscope biases with the
+// die temperature, fitted on a warm-up run of the knob still in a
+// position. The rest of the profile holds at Ref, the compensation removes
+// the drift away from it.
+type Thermal struct {
+	Ref    float64        `json:"ref"` // oC
+	Min    float64        `json:"min"` // oC, range of the run
+	Max    float64        `json:"max"`
+	Acc    Drift          `json:"acc"` // g
+	Gyr    Drift          `json:"gyr"` // o/s
+	Points []ThermalPoint `json:"points"`
+}
+
+// FitThermal fits the drift of the points of a warm-up run, which must go
+// through THERMAL_MIN_SPAN at least.
+func FitThermal(points []ThermalPoint, ref float64) (*Thermal, error) {
+	if len(points) < 3 {
+		return nil, fmt.Errorf("calibration: %d points of temperature, 3 at least", len(points))
+	}
+	t := &Thermal{Ref: ref, Min: points[0].Temp, Max: points[0].Temp, Points: points}
+	meanT := 0.0
+	for _, p := range points {
+		t.Min, t.Max = math.Min(t.Min, p.Temp), math.Max(t.Max, p.Temp)
+		meanT += p.Temp
+	}
+	if t.Max-t.Min < THERMAL_MIN_SPAN {
+		return nil, fmt.Errorf("calibration: temperature from %.2f to %.2f oC, %g oC needed", t.Min, t.Max, THERMAL_MIN_SPAN)
+	}
+	meanT /= float64(len(points))
+	fit := func(value func(ThermalPoint) [3]float64) Drift {
+		var d Drift
+		for i := range d.Slope {
+			meanV, varT, cov := 0.0, 0.0, 0.0
+			for _, p := range points {
+				meanV += value(p)[i]
+			}
+			meanV /= float64(len(points))
+			for _, p := range points {
+				varT += (p.Temp - meanT) * (p.Temp - meanT)
+				cov += (p.Temp - meanT) * (value(p)[i] - meanV)
+			}
+			d.Slope[i] = cov / varT
+			for _, p := range points {
+				e := value(p)[i] - meanV - d.Slope[i]*(p.Temp-meanT)
+				d.Residual[i] += e * e
+			}
+			d.Residual[i] = math.Sqrt(d.Residual[i] / float64(len(points)))
+		}
+		return d
+	}
+	t.Acc = fit(func(p ThermalPoint) [3]float64 { return p.Acc })
+	t.Gyr = fit(func(p ThermalPoint) [3]float64 { return p.Gyr })
+	return t, nil
+}
+
+// AccDrift is the drift of the accelerometer at temp, g.
+func (t *Thermal) AccDrift(temp float64) [3]float64 {
+	return t.Acc.At(temp, t.Ref)
+}
+
+// GyrDrift is the drift of the gyroscope at temp, o/s.
+func (t *Thermal) GyrDrift(temp float64) [3]float64 {
+	return t.Gyr.At(temp, t.Ref)
+}
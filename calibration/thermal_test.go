@@ -0,0 +1,61 @@
+package calibration
+
+import (
+	"math"
+	"testing"
+)
+
+// points of a warm-up run from 30 oC, the biases drifting by accSlope and
+// gyrSlope per oC.
+func warmUp(n int, step float64, accSlope, gyrSlope [3]float64) []ThermalPoint {
+	points := make([]ThermalPoint, n)
+	for k := range points {
+		temp := 30 + float64(k)*step
+		points[k].Temp = temp
+		for i := 0; i < 3; i++ {
+			points[k].Acc[i] = 0.01*float64(i) + accSlope[i]*(temp-30)
+			points[k].Gyr[i] = 0.5 - float64(i) + gyrSlope[i]*(temp-30)
+		}
+	}
+	return points
+}
+
+func TestFitThermal(t *testing.T) {
+	accSlope := [3]float64{0.0002, -0.0001, 0.0005}
+	gyrSlope := [3]float64{0.02, 0.05, -0.03}
+	th, err := FitThermal(warmUp(10, 0.5, accSlope, gyrSlope), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(th.Acc.Slope, accSlope, 1e-12) || !near(th.Gyr.Slope, gyrSlope, 1e-12) {
+		t.Errorf("slopes %v %v, want %v %v", th.Acc.Slope, th.Gyr.Slope, accSlope, gyrSlope)
+	}
+	if !near(th.Acc.Residual, [3]float64{}, 1e-12) || !near(th.Gyr.Residual, [3]float64{}, 1e-12) {
+		t.Errorf("residuals %v %v, want 0", th.Acc.Residual, th.Gyr.Residual)
+	}
+	if th.Min != 30 || th.Max != 34.5 {
+		t.Errorf("range %g to %g, want 30 to 34.5", th.Min, th.Max)
+	}
+	if d := th.GyrDrift(34); math.Abs(d[1]-2*gyrSlope[1]) > 1e-12 {
+		t.Errorf("drift at 34 oC %v, want %g from 32", d, 2*gyrSlope[1])
+	}
+}
+
+func TestFitThermalErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []ThermalPoint
+	}{
+		{"no points", nil},
+		{"two points", warmUp(2, 5, [3]float64{}, [3]float64{})},
+		{"span too small", warmUp(10, 0.2, [3]float64{}, [3]float64{})},
+		{"constant temperature", warmUp(10, 0, [3]float64{}, [3]float64{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if th, err := FitThermal(tt.points, 30); err == nil {
+				t.Errorf("fit %+v, want an error", th)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+// Package dataset indexes the acquisition files under data/: it decodes the
+// naming scheme (subject, full scales, repetition), reads the headers and
+// the samples, and flags where they disagree. The resulting Manifest is what
+// the identification experiments filter by subject, session and
+// configuration.
+package dataset
+
+import (
+	"../knobcsv"
+	"../mpu9250"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Extension of the acquisition files.
+const EXTENSION = ".csv"
+
+// Entry describes one acquisition file.
+type Entry struct {
+	Path     string    `json:"path"`    // relative to the data root, with '/'
+	Session  string    `json:"session"` // folder relative to the data root, "." at the root
+	Label    string    `json:"label,omitempty"`
+	Subject  string    `json:"subject,omitempty"`
+	Rep      int       `json:"rep"`
+	AccFS    int       `json:"accFS"`         // g, from the header, the name or a bound from the data
+	GyrFS    int       `json:"gyrFS"`         // dps, likewise
+	Conf     string    `json:"conf"`          // as in the file names of knobID, a8w250
+	Time     time.Time `json:"time,omitzero"` // of the first header, zero without header
+	Header   bool      `json:"header"`
+	Captures int       `json:"captures"` // appended acquisitions in the file
+	Samples  int       `json:"samples"`
+	Present  int       `json:"present"`           // samples with p=1
+	Rate     float64   `json:"rate"`              // Hz, from the median sample period
+	Columns  []string  `json:"columns,omitempty"` // extra columns
+	Issues   []string  `json:"issues,omitempty"`
+}
+
+func (e *Entry) issue(format string, a ...interface{}) {
+	e.Issues = append(e.Issues, fmt.Sprintf(format, a...))
+}
+
+// Tolerance of the quantisation check, in LSB, on top of the rounding of
+// the 6 decimals the files are written with.
+const QUANT_TOLERANCE = 0.01
+
+// Whether all the values are a whole number of LSB at sensitivity sens.
+func quantised(values []float64, sens float64) bool {
+	tol := QUANT_TOLERANCE + 0.5e-6*sens
+	for _, v := range values {
+		x := v * sens
+		if math.Abs(x-math.Round(x)) > tol {
+			return false
+		}
+	}
+	return true
+}
+
+// Full scales of the MPU9250, largest first.
+var (
+	accelScales = []mpu9250.AccelFS{mpu9250.ACCEL_FS_16G, mpu9250.ACCEL_FS_8G, mpu9250.ACCEL_FS_4G, mpu9250.ACCEL_FS_2G}
+	gyroScales  = []mpu9250.GyroFS{mpu9250.GYRO_FS_2000, mpu9250.GYRO_FS_1000, mpu9250.GYRO_FS_500, mpu9250.GYRO_FS_250}
+)
+
+// DataFS bounds the full scales the samples were taken with: the largest
+// one whose LSB all the values are a multiple of. The actual full scale is
+// this one or smaller, the low bits of the samples may all be 0 (the 8 g
+// files of the dataset look like 16 g ones). It is 0 when there is no
+// sample or no full scale fits (the data was rescaled).
+func DataFS(samples []knobcsv.Sample) (accFS, gyrFS int) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	acc := make([]float64, 0, 3*len(samples))
+	gyr := make([]float64, 0, 3*len(samples))
+	for _, s := range samples {
+		acc = append(acc, s.Acc[:]...)
+		gyr = append(gyr, s.Gyr[:]...)
+	}
+	for _, fs := range accelScales {
+		if quantised(acc, fs.Sensitivity()) {
+			accFS = fs.G()
+			break
+		}
+	}
+	for _, fs := range gyroScales {
+		if quantised(gyr, fs.Sensitivity()) {
+			gyrFS = fs.DPS()
+			break
+		}
+	}
+	return accFS, gyrFS
+}
+
+// Median sample rate in Hz, 0 with less than two samples.
+func rate(samples []knobcsv.Sample) float64 {
+	var periods []float64
+	for i := 1; i < len(samples); i++ {
+		if d := samples[i].Time - samples[i-1].Time; d > 0 {
+			periods = append(periods, d.Seconds())
+		}
+	}
+	if len(periods) == 0 {
+		return 0
+	}
+	sort.Float64s(periods)
+	return math.Round(1 / periods[len(periods)/2])
+}
+
+// First non zero value.
+func first(values ...int) int {
+	for _, v := range values {
+		if v != 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+// Describe reads an acquisition file and checks it against its name. path
+// is the file and rel its path relative to the data root.
+func Describe(path, rel string) (*Entry, error) {
+	rel = filepath.ToSlash(rel)
+	e := &Entry{Path: rel, Session: filepath.ToSlash(filepath.Dir(rel)), Rep: -1}
+
+	name, nerr := ParseName(rel)
+	if nerr != nil {
+		e.issue("%v", nerr)
+	}
+	e.Label, e.Subject, e.Rep = name.Label, name.Subject, name.Rep
+	//full scales the sensor does not have are flagged and ignored
+	if name.AccFS != 0 {
+		if _, err := mpu9250.AccelFSFromG(name.AccFS); err != nil {
+			e.issue("name: %v", err)
+			name.AccFS = 0
+		}
+	}
+	if name.GyrFS != 0 {
+		if _, err := mpu9250.GyroFSFromDPS(name.GyrFS); err != nil {
+			e.issue("name: %v", err)
+			name.GyrFS = 0
+		}
+	}
+
+	acqs, err := knobcsv.ReadFile(path)
+	if errs, ok := err.(knobcsv.ErrorList); ok {
+		for _, re := range errs {
+			e.issue("line %d: %v", re.Line, re.Err)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	e.Captures = len(acqs)
+	if len(acqs) == 0 {
+		e.issue("no acquisition")
+	} else if len(acqs) > 1 {
+		e.issue("%d acquisitions appended", len(acqs))
+	}
+
+	var samples []knobcsv.Sample
+	var headAcc, headGyr int
+	extra := ""
+	for i, a := range acqs {
+		h := &a.Header
+		samples = append(samples, a.Samples...)
+		for _, s := range a.Samples {
+			if s.Present {
+				e.Present++
+			}
+		}
+		if r := rate(a.Samples); r > e.Rate {
+			e.Rate = r
+		}
+		if cols := strings.Join(h.Extra, ","); i == 0 {
+			extra, e.Columns = cols, h.Extra
+		} else if cols != extra {
+			e.issue("line %d: columns differ from the first acquisition", a.Line)
+		}
+		if h.Name == "" && h.AccFS == 0 && h.Time.IsZero() {
+			continue
+		}
+		if !e.Header {
+			e.Header, e.Time = true, h.Time
+		}
+		if h.Name != "" {
+			subject, err := Subject(h.Name)
+			switch {
+			case err != nil:
+				e.issue("line %d: header name %q is not a subject", a.Line, h.Name)
+			case e.Subject == "":
+				e.Subject = subject
+			case subject != e.Subject:
+				e.issue("line %d: header subject %s, name %s", a.Line, subject, e.Subject)
+			}
+		}
+		if h.HasNum && name.Rep >= 0 && h.Num != name.Rep {
+			e.issue("line %d: header num %d, name repetition %d", a.Line, h.Num, name.Rep)
+		}
+		if h.AccFS != 0 && name.AccFS != 0 && h.AccFS != name.AccFS {
+			e.issue("line %d: header accelerometer %d g, name %d g", a.Line, h.AccFS, name.AccFS)
+		}
+		if h.GyrFS != 0 && name.GyrFS != 0 && h.GyrFS != name.GyrFS {
+			e.issue("line %d: header gyroscope %d dps, name %d dps", a.Line, h.GyrFS, name.GyrFS)
+		}
+		if headAcc == 0 {
+			headAcc, headGyr = h.AccFS, h.GyrFS
+		}
+	}
+	e.Samples = len(samples)
+
+	dataAcc, dataGyr := DataFS(samples)
+	//data finer than the LSB of the full scale given
+	if given := first(headAcc, name.AccFS); dataAcc != 0 && given > dataAcc {
+		e.issue("data quantised at %d g or less, given %d g", dataAcc, given)
+	}
+	if given := first(headGyr, name.GyrFS); dataGyr != 0 && given > dataGyr {
+		e.issue("data quantised at %d dps or less, given %d dps", dataGyr, given)
+	}
+	e.AccFS = first(headAcc, name.AccFS, dataAcc)
+	e.GyrFS = first(headGyr, name.GyrFS, dataGyr)
+	e.Conf = fmt.Sprintf("a%dw%d", e.AccFS, e.GyrFS)
+	return e, nil
+}
+
+// Index walks root and describes every acquisition file, in lexical order.
+// Files that cannot be read are recorded with the error as issue.
+func Index(root string) (Manifest, error) {
+	var m Manifest
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != EXTENSION {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		e, err := Describe(path, rel)
+		if err != nil {
+			e = &Entry{Path: filepath.ToSlash(rel), Session: filepath.ToSlash(filepath.Dir(rel)), Rep: -1}
+			e.issue("%v", err)
+		}
+		m = append(m, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.checkDuplicates()
+	return m, nil
+}
+
+// Flag the files with the same session, subject, label, configuration and
+// repetition, such as "i001_ 0.csv" and "i001_00.csv".
+func (m Manifest) checkDuplicates() {
+	seen := make(map[string]*Entry)
+	for _, e := range m {
+		if e.Rep < 0 {
+			continue
+		}
+		key := fmt.Sprintf("%s|%s|%s|%s|%d", e.Session, e.Subject, e.Label, e.Conf, e.Rep)
+		if prev, ok := seen[key]; ok {
+			prev.issue("same repetition as %s", e.Path)
+			e.issue("same repetition as %s", prev.Path)
+			continue
+		}
+		seen[key] = e
+	}
+}
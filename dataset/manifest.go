@@ -0,0 +1,194 @@
+package dataset
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Manifest is the list of the acquisition files of a dataset.
+type Manifest []*Entry
+
+// Select returns the entries for which keep is true.
+func (m Manifest) Select(keep func(*Entry) bool) Manifest {
+	var out Manifest
+	for _, e := range m {
+		if keep(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Filter keeps the entries of a subject, a session and a configuration,
+// an empty value matches everything.
+func (m Manifest) Filter(subject, session, conf string) Manifest {
+	return m.Select(func(e *Entry) bool {
+		return (subject == "" || e.Subject == subject) &&
+			(session == "" || e.Session == session) &&
+			(conf == "" || e.Conf == conf)
+	})
+}
+
+// Distinct values of a field, sorted.
+func (m Manifest) distinct(field func(*Entry) string) []string {
+	set := make(map[string]bool)
+	for _, e := range m {
+		if v := field(e); v != "" {
+			set[v] = true
+		}
+	}
+	var out []string
+	for v := range set {
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Subjects returns the subjects of the manifest, sorted.
+func (m Manifest) Subjects() []string {
+	return m.distinct(func(e *Entry) string { return e.Subject })
+}
+
+// Sessions returns the sessions of the manifest, sorted.
+func (m Manifest) Sessions() []string {
+	return m.distinct(func(e *Entry) string { return e.Session })
+}
+
+// Confs returns the configurations of the manifest, sorted.
+func (m Manifest) Confs() []string {
+	return m.distinct(func(e *Entry) string { return e.Conf })
+}
+
+// WriteJSON writes the manifest as an indented JSON array.
+func (m Manifest) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// ReadJSON reads a manifest written by WriteJSON.
+func ReadJSON(r io.Reader) (Manifest, error) {
+	var m Manifest
+	err := json.NewDecoder(r).Decode(&m)
+	return m, err
+}
+
+// Columns of the CSV manifest. Lists are separated by '|'.
+var CSV_COLUMNS = []string{"path", "session", "label", "subject", "rep", "accFS", "gyrFS", "conf", "time", "header", "captures", "samples", "present", "rate", "columns", "issues"}
+
+// CSV separator, as in the acquisition files.
+const CSV_COMMA = ';'
+
+// WriteCSV writes the manifest as a CSV file with a column line.
+func (m Manifest) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = CSV_COMMA
+	cw.Write(CSV_COLUMNS)
+	for _, e := range m {
+		t := ""
+		if !e.Time.IsZero() {
+			t = e.Time.Format(time.RFC3339Nano)
+		}
+		cw.Write([]string{
+			e.Path, e.Session, e.Label, e.Subject,
+			strconv.Itoa(e.Rep), strconv.Itoa(e.AccFS), strconv.Itoa(e.GyrFS), e.Conf,
+			t, strconv.FormatBool(e.Header),
+			strconv.Itoa(e.Captures), strconv.Itoa(e.Samples), strconv.Itoa(e.Present),
+			strconv.FormatFloat(e.Rate, 'f', -1, 64),
+			strings.Join(e.Columns, "|"), strings.Join(e.Issues, "|"),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Split a '|' separated list, empty for an empty field.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "|")
+}
+
+// ReadCSV reads a manifest written by WriteCSV.
+func ReadCSV(r io.Reader) (Manifest, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = CSV_COMMA
+	cr.FieldsPerRecord = len(CSV_COLUMNS)
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || records[0][0] != CSV_COLUMNS[0] {
+		return nil, fmt.Errorf("dataset: missing column line")
+	}
+	var m Manifest
+	for i, rec := range records[1:] {
+		e := &Entry{Path: rec[0], Session: rec[1], Label: rec[2], Subject: rec[3], Conf: rec[7]}
+		ints := []*int{&e.Rep, &e.AccFS, &e.GyrFS}
+		for j, p := range ints {
+			if *p, err = strconv.Atoi(rec[4+j]); err != nil {
+				return nil, fmt.Errorf("dataset: line %d: invalid %s %q", i+2, CSV_COLUMNS[4+j], rec[4+j])
+			}
+		}
+		if rec[8] != "" {
+			if e.Time, err = time.Parse(time.RFC3339Nano, rec[8]); err != nil {
+				return nil, fmt.Errorf("dataset: line %d: invalid time %q", i+2, rec[8])
+			}
+		}
+		if e.Header, err = strconv.ParseBool(rec[9]); err != nil {
+			return nil, fmt.Errorf("dataset: line %d: invalid header %q", i+2, rec[9])
+		}
+		ints = []*int{&e.Captures, &e.Samples, &e.Present}
+		for j, p := range ints {
+			if *p, err = strconv.Atoi(rec[10+j]); err != nil {
+				return nil, fmt.Errorf("dataset: line %d: invalid %s %q", i+2, CSV_COLUMNS[10+j], rec[10+j])
+			}
+		}
+		if e.Rate, err = strconv.ParseFloat(rec[13], 64); err != nil {
+			return nil, fmt.Errorf("dataset: line %d: invalid rate %q", i+2, rec[13])
+		}
+		e.Columns, e.Issues = splitList(rec[14]), splitList(rec[15])
+		m = append(m, e)
+	}
+	return m, nil
+}
+
+// Load reads a manifest file, CSV if its extension is .csv, JSON otherwise.
+func Load(name string) (Manifest, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		return ReadCSV(f)
+	}
+	return ReadJSON(f)
+}
+
+// Save writes a manifest file, CSV if its extension is .csv, JSON otherwise.
+func (m Manifest) Save(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		err = m.WriteCSV(f)
+	} else {
+		err = m.WriteJSON(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
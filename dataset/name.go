@@ -0,0 +1,85 @@
+package dataset
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Name is what the name of an acquisition file tells.
+type Name struct {
+	Label   string // prefix of the recording kind: sem, nobody, noopen...
+	Subject string // normalised as i001, empty if none
+	AccFS   int    // g, 0 if not in the name
+	GyrFS   int    // dps, 0 if not in the name
+	Rep     int    // repetition, -1 if not in the name
+}
+
+// Naming schemes, the repetition excluded.
+var (
+	//i001a8w250, i001a8w1k, semI1A16W1K, nobodyA16W1K, nobotouch6W1K
+	nameAW = regexp.MustCompile(`^([a-z]*?)(?:[iI](\d+))?[aA]?(\d+)[wW](\d+)([kK]?)$`)
+	//16G1K, i216G1K: a single digit subject followed by the accelerometer
+	nameG = regexp.MustCompile(`^([a-z]*?)(?:[iI](\d+?))?(2|4|8|16)[gG](\d+)([kK]?)$`)
+	//i001
+	nameI = regexp.MustCompile(`^[iI](\d+)$`)
+)
+
+// Subject normalises a subject id, "I1", "i1" and "i001" are all "i001".
+func Subject(id string) (string, error) {
+	s := strings.TrimSpace(id)
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'I') {
+		return "", fmt.Errorf("invalid subject %q", id)
+	}
+	n, err := strconv.Atoi(s[1:])
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("invalid subject %q", id)
+	}
+	return fmt.Sprintf("i%03d", n), nil
+}
+
+// Gyroscope full scale of a name, "1k" is 1000.
+func gyroFS(digits, k string) int {
+	n, _ := strconv.Atoi(digits)
+	if k != "" {
+		n *= 1000
+	}
+	return n
+}
+
+// ParseName decodes the base name of an acquisition file, the full scales
+// are returned as written even if the sensor does not have them.
+func ParseName(file string) (Name, error) {
+	name := Name{Rep: -1}
+	base := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	stem := base
+	if i := strings.LastIndex(base, "_"); i >= 0 {
+		rep, err := strconv.Atoi(strings.TrimSpace(base[i+1:]))
+		if err != nil {
+			return name, fmt.Errorf("invalid repetition in %q", base)
+		}
+		name.Rep = rep
+		stem = base[:i]
+	}
+	var m []string
+	switch {
+	case nameI.MatchString(stem):
+		name.Subject, _ = Subject(stem)
+		return name, nil
+	case nameG.MatchString(stem):
+		m = nameG.FindStringSubmatch(stem)
+	case nameAW.MatchString(stem):
+		m = nameAW.FindStringSubmatch(stem)
+	default:
+		return name, fmt.Errorf("unknown naming scheme %q", base)
+	}
+	name.Label = m[1]
+	if m[2] != "" {
+		name.Subject, _ = Subject("i" + m[2])
+	}
+	name.AccFS, _ = strconv.Atoi(m[3])
+	name.GyrFS = gyroFS(m[4], m[5])
+	return name, nil
+}
@@ -0,0 +1,296 @@
+// Package enroll keeps the registry of the subjects of the dataset and the
+// numbering of their repetitions, so collecting the captures of every
+// subject is repeatable: subjects are registered once under a pseudonymous
+// id (i001, i002...), their repetitions resume from the files already taken
+// and a capture file is never appended to.
+package enroll
+
+import (
+	"../dataset"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Handedness of a subject.
+const (
+	RIGHT_HANDED = "right"
+	LEFT_HANDED  = "left"
+	AMBIDEXTROUS = "ambidextrous"
+)
+
+// ErrExists is returned when registering an id twice.
+var ErrExists = errors.New("enroll: subject already registered")
+
+// ErrUnknown is returned for an id not registered.
+var ErrUnknown = errors.New("enroll: subject not registered")
+
+// Subject of the dataset. Only the pseudonymous id links the captures to
+// the subject, the registry holds no name.
+type Subject struct {
+	ID         string    `json:"id"` // i001
+	Handedness string    `json:"handedness"`
+	Age        int       `json:"age,omitempty"`
+	Sex        string    `json:"sex,omitempty"`
+	Notes      string    `json:"notes,omitempty"`
+	Registered time.Time `json:"registered"`
+}
+
+// Validate checks the id and the handedness, the id is normalised.
+func (s *Subject) Validate() error {
+	id, err := dataset.Subject(s.ID)
+	if err != nil {
+		return fmt.Errorf("enroll: %v", err)
+	}
+	s.ID = id
+	switch s.Handedness {
+	case RIGHT_HANDED, LEFT_HANDED, AMBIDEXTROUS:
+	default:
+		return fmt.Errorf("enroll: invalid handedness %q (%s, %s, %s)", s.Handedness, RIGHT_HANDED, LEFT_HANDED, AMBIDEXTROUS)
+	}
+	if s.Age < 0 {
+		return fmt.Errorf("enroll: invalid age %d", s.Age)
+	}
+	return nil
+}
+
+// Registry of the subjects, saved as a JSON file.
+type Registry struct {
+	file     string
+	Subjects []*Subject `json:"subjects"`
+}
+
+// Load reads the registry of a file, empty if it does not exist yet.
+func Load(file string) (*Registry, error) {
+	r := &Registry{file: file}
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("enroll: %s: %v", file, err)
+	}
+	return r, nil
+}
+
+// Save writes the registry back to its file, through a temporary file so
+// it is never left half written.
+func (r *Registry) Save() error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := r.file + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.file)
+}
+
+// Get returns a registered subject.
+func (r *Registry) Get(id string) (*Subject, error) {
+	id, err := dataset.Subject(id)
+	if err != nil {
+		return nil, fmt.Errorf("enroll: %v", err)
+	}
+	for _, s := range r.Subjects {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknown, id)
+}
+
+// NextID returns the id following the highest one registered.
+func (r *Registry) NextID() string {
+	max := 0
+	for _, s := range r.Subjects {
+		if n, err := strconv.Atoi(s.ID[1:]); err == nil && n > max {
+			max = n
+		}
+	}
+	return fmt.Sprintf("i%03d", max+1)
+}
+
+// Register adds a subject, with the next id if it has none.
+func (r *Registry) Register(s *Subject) error {
+	if s.ID == "" {
+		s.ID = r.NextID()
+	}
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if _, err := r.Get(s.ID); err == nil {
+		return fmt.Errorf("%w: %s", ErrExists, s.ID)
+	}
+	if s.Registered.IsZero() {
+		s.Registered = time.Now()
+	}
+	r.Subjects = append(r.Subjects, s)
+	sort.Slice(r.Subjects, func(i, j int) bool { return r.Subjects[i].ID < r.Subjects[j].ID })
+	return nil
+}
+
+// Repetitions returns the repetition numbers already taken in dir for a
+// file prefix (the name and configuration, i001a8w250), whatever the
+// extension of the files, sorted.
+func Repetitions(dir, prefix string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	re := regexp.MustCompile(`^` + regexp.QuoteMeta(prefix) + `_(\d+)\.`)
+	seen := make(map[int]bool)
+	var reps []int
+	for _, e := range entries {
+		m := re.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[1])
+		if !seen[n] {
+			seen[n] = true
+			reps = append(reps, n)
+		}
+	}
+	sort.Ints(reps)
+	return reps, nil
+}
+
+// NextRepetition returns the number following the highest repetition
+// taken, 0 if none.
+func NextRepetition(dir, prefix string) (int, error) {
+	reps, err := Repetitions(dir, prefix)
+	if err != nil || len(reps) == 0 {
+		return 0, err
+	}
+	return reps[len(reps)-1] + 1, nil
+}
+
+// Create creates a capture file, it fails if the file exists: captures are
+// never appended to one another.
+func Create(name string) (*os.File, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("enroll: %s exists, refusing to append", name)
+	}
+	return f, err
+}
+
+// Session counts the repetitions of a subject during a run. The captures
+// are handed to the writer on the acquisition goroutine and counted once
+// written, on the writer goroutine, so a repetition that fails to be
+// written is taken again.
+type Session struct {
+	Subject *Subject
+	Reps    int // wanted, 0 for no limit
+	First   int // number of the first repetition of the run
+
+	mu      sync.Mutex
+	written *sync.Cond
+	done    int // written
+	pending int // handed to the writer, not written yet
+}
+
+// NewSession starts a session of reps repetitions from the repetition first.
+func NewSession(subject *Subject, reps, first int) *Session {
+	s := &Session{Subject: subject, Reps: reps, First: first}
+	s.written = sync.NewCond(&s.mu)
+	return s
+}
+
+// Handed records a capture handed to the writer, its result is recorded
+// with Written.
+func (s *Session) Handed() {
+	s.mu.Lock()
+	s.pending++
+	s.mu.Unlock()
+}
+
+// Written records the result of the write of a capture handed, it is a
+// repetition if err is nil.
+func (s *Session) Written(err error) {
+	s.mu.Lock()
+	s.pending--
+	if err == nil {
+		s.done++
+	}
+	s.mu.Unlock()
+	s.written.Broadcast()
+}
+
+// Wait waits for the captures handed to be written.
+func (s *Session) Wait() {
+	s.mu.Lock()
+	for s.pending > 0 {
+		s.written.Wait()
+	}
+	s.mu.Unlock()
+}
+
+// Done returns the number of repetitions written.
+func (s *Session) Done() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done
+}
+
+// Last reports whether the captures handed take all the repetitions once
+// written.
+func (s *Session) Last() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Reps > 0 && s.done+s.pending >= s.Reps
+}
+
+// Complete reports whether all the repetitions are written.
+func (s *Session) Complete() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Reps > 0 && s.done >= s.Reps
+}
+
+// Progress describes the session for the console, next is the number of
+// the file of the next capture.
+func (s *Session) Progress(next int) string {
+	s.mu.Lock()
+	rep := s.done + s.pending + 1
+	s.mu.Unlock()
+	if s.Reps > 0 {
+		return fmt.Sprintf("%s: repetition %d of %d (file number %02d)", s.Subject.ID, rep, s.Reps, next)
+	}
+	return fmt.Sprintf("%s: repetition %d (file number %02d)", s.Subject.ID, rep, next)
+}
+
+// Summary lists the subjects with the repetitions taken in dir for the
+// configuration conf (a8w250), one line each.
+func (r *Registry) Summary(dir, conf string) (string, error) {
+	var b strings.Builder
+	for _, s := range r.Subjects {
+		reps, err := Repetitions(dir, s.ID+conf)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%d\t%s\t%d repetitions\n", s.ID, s.Handedness, s.Age, s.Sex, len(reps))
+	}
+	return b.String(), nil
+}
+
+// DefaultRegistry is the registry file of a data directory.
+func DefaultRegistry(dataDir string) string {
+	return filepath.Join(dataDir, "subjects.json")
+}
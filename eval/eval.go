@@ -0,0 +1,167 @@
+// Package eval cross-validates the identification models of ident on the
+// enrolled captures: the examples are split into folds, a model is trained
+// on every training part and ranks the subjects of the captures of its test
+// part, and the rankings are summarised as identification (accuracy,
+// confusion, rank-k rates) and verification (FAR, FRR, EER) results.
+package eval
+
+import (
+	"../ident"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// Protocols of the splits.
+const (
+	LEAVE_ONE_SESSION_OUT = "loso"
+	K_FOLD                = "kfold"
+)
+
+// Split of the examples into a training and a test part, by index.
+type Split struct {
+	Name  string
+	Train []int
+	Test  []int
+}
+
+// LeaveOneSessionOut tests every session with a model trained on the other
+// ones, in the order of the sessions.
+func LeaveOneSessionOut(examples []ident.Example) []Split {
+	bySession := make(map[string][]int)
+	var sessions []string
+	for i, e := range examples {
+		if _, ok := bySession[e.Session]; !ok {
+			sessions = append(sessions, e.Session)
+		}
+		bySession[e.Session] = append(bySession[e.Session], i)
+	}
+	sort.Strings(sessions)
+	splits := make([]Split, 0, len(sessions))
+	for _, s := range sessions {
+		split := Split{Name: s, Test: bySession[s]}
+		for i, e := range examples {
+			if e.Session != s {
+				split.Train = append(split.Train, i)
+			}
+		}
+		splits = append(splits, split)
+	}
+	return splits
+}
+
+// KFold splits the examples into k folds grouped by subject: the captures
+// of every subject are shuffled with the seed and dealt over the folds, so
+// every subject is trained and tested in every fold it has captures for.
+func KFold(examples []ident.Example, k int, seed int64) ([]Split, error) {
+	if k < 2 {
+		return nil, fmt.Errorf("eval: %d folds, at least 2", k)
+	}
+	bySubject := make(map[string][]int)
+	var subjects []string
+	for i, e := range examples {
+		if _, ok := bySubject[e.Subject]; !ok {
+			subjects = append(subjects, e.Subject)
+		}
+		bySubject[e.Subject] = append(bySubject[e.Subject], i)
+	}
+	sort.Strings(subjects)
+	rnd := rand.New(rand.NewSource(seed))
+	fold := make([]int, len(examples))
+	for _, s := range subjects {
+		idx := bySubject[s]
+		rnd.Shuffle(len(idx), func(i, j int) { idx[i], idx[j] = idx[j], idx[i] })
+		for n, i := range idx {
+			fold[i] = n % k
+		}
+	}
+	splits := make([]Split, k)
+	for f := range splits {
+		splits[f].Name = fmt.Sprintf("fold%d", f)
+		for i := range examples {
+			if fold[i] == f {
+				splits[f].Test = append(splits[f].Test, i)
+			} else {
+				splits[f].Train = append(splits[f].Train, i)
+			}
+		}
+	}
+	return splits, nil
+}
+
+// Prediction is the ranking of a test capture.
+type Prediction struct {
+	Fold      string        `json:"fold"`
+	Path      string        `json:"path"`
+	Session   string        `json:"session"`
+	Subject   string        `json:"subject"`   // true
+	Predicted string        `json:"predicted"` // best ranked
+	Rank      int           `json:"rank"`      // of the true subject from 1, 0 if not in the model
+	Matches   []ident.Match `json:"matches"`
+}
+
+// Fold is the summary of a split.
+type Fold struct {
+	Name    string `json:"name"`
+	Train   int    `json:"train"`
+	Test    int    `json:"test"`
+	Unseen  int    `json:"unseen"` // test captures of subjects not trained, not evaluated
+	Correct int    `json:"correct"`
+}
+
+// Run trains a model on every split and ranks its test captures. Captures
+// of subjects the model was not trained with are counted as unseen and
+// left out of the predictions, they cannot be identified.
+func Run(newModel func() (ident.Model, error), examples []ident.Example, splits []Split) ([]Fold, []Prediction, error) {
+	var folds []Fold
+	var predictions []Prediction
+	for _, s := range splits {
+		fold := Fold{Name: s.Name, Train: len(s.Train), Test: len(s.Test)}
+		if len(s.Train) == 0 || len(s.Test) == 0 {
+			folds = append(folds, fold)
+			continue
+		}
+		train := make([]ident.Example, len(s.Train))
+		for i, j := range s.Train {
+			train[i] = examples[j]
+		}
+		model, err := newModel()
+		if err != nil {
+			return folds, predictions, err
+		}
+		if err := model.Train(train); err != nil {
+			return folds, predictions, fmt.Errorf("eval: %s: %v", s.Name, err)
+		}
+		trained := make(map[string]bool)
+		for _, subject := range model.Subjects() {
+			trained[subject] = true
+		}
+		for _, j := range s.Test {
+			e := &examples[j]
+			if !trained[e.Subject] {
+				fold.Unseen++
+				continue
+			}
+			matches, err := model.Rank(e.Samples)
+			if err != nil {
+				return folds, predictions, fmt.Errorf("eval: %s: %s: %v", s.Name, e.Path, err)
+			}
+			p := Prediction{Fold: s.Name, Path: e.Path, Session: e.Session, Subject: e.Subject, Matches: matches}
+			if len(matches) > 0 {
+				p.Predicted = matches[0].Subject
+			}
+			for r, m := range matches {
+				if m.Subject == e.Subject {
+					p.Rank = r + 1
+					break
+				}
+			}
+			if p.Rank == 1 {
+				fold.Correct++
+			}
+			predictions = append(predictions, p)
+		}
+		folds = append(folds, fold)
+	}
+	return folds, predictions, nil
+}
@@ -0,0 +1,105 @@
+package eval
+
+import (
+	"../ident"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// examples of subjects with the given numbers of captures, in a session.
+func examples(session string, captures map[string]int) []ident.Example {
+	var out []ident.Example
+	for _, s := range []string{"i001", "i002", "i003", "i004"} {
+		for i := 0; i < captures[s]; i++ {
+			out = append(out, ident.Example{Subject: s, Session: session, Path: fmt.Sprintf("%s/%s_%d.csv", session, s, i)})
+		}
+	}
+	return out
+}
+
+func TestKFold(t *testing.T) {
+	tests := []struct {
+		name     string
+		k        int
+		captures map[string]int
+	}{
+		{"balanced", 5, map[string]int{"i001": 10, "i002": 10, "i003": 10}},
+		{"unbalanced", 3, map[string]int{"i001": 7, "i002": 4, "i003": 11, "i004": 3}},
+		{"fewer captures than folds", 5, map[string]int{"i001": 12, "i002": 2, "i003": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := examples("170131", tt.captures)
+			splits, err := KFold(ex, tt.k, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(splits) != tt.k {
+				t.Fatalf("%d folds, want %d", len(splits), tt.k)
+			}
+			tested := make([]int, len(ex))
+			for f, s := range splits {
+				if len(s.Train)+len(s.Test) != len(ex) {
+					t.Errorf("%s: %d train and %d test of %d examples", s.Name, len(s.Train), len(s.Test), len(ex))
+				}
+				inTrain := make(map[string]int)
+				inTest := make(map[string]int)
+				for _, i := range s.Train {
+					inTrain[ex[i].Subject]++
+				}
+				for _, i := range s.Test {
+					inTest[ex[i].Subject]++
+					tested[i]++
+				}
+				for subject, n := range tt.captures {
+					//dealt over the folds, one more in the first ones
+					want := n / tt.k
+					if f < n%tt.k {
+						want++
+					}
+					if inTest[subject] != want {
+						t.Errorf("%s: %d test captures of %s, want %d", s.Name, inTest[subject], subject, want)
+					}
+					if inTrain[subject] != n-want {
+						t.Errorf("%s: %d train captures of %s, want %d", s.Name, inTrain[subject], subject, n-want)
+					}
+					//a subject tested in a fold is trained in it as long as it has another capture
+					if inTest[subject] > 0 && n > 1 && inTrain[subject] == 0 {
+						t.Errorf("%s: %s tested but not trained", s.Name, subject)
+					}
+				}
+			}
+			for i, n := range tested {
+				if n != 1 {
+					t.Errorf("%s tested %d times", ex[i].Path, n)
+				}
+			}
+			//the same seed, the same folds
+			again, _ := KFold(ex, tt.k, 1)
+			if !reflect.DeepEqual(again, splits) {
+				t.Error("folds differ with the same seed")
+			}
+		})
+	}
+}
+
+func TestKFoldFolds(t *testing.T) {
+	for _, k := range []int{-1, 0, 1} {
+		if _, err := KFold(examples("170131", map[string]int{"i001": 3}), k, 1); err == nil {
+			t.Errorf("%d folds accepted", k)
+		}
+	}
+}
+
+func TestLeaveOneSessionOut(t *testing.T) {
+	ex := append(examples("170131", map[string]int{"i001": 2, "i002": 1}), examples("161212", map[string]int{"i001": 1, "i003": 2})...)
+	splits := LeaveOneSessionOut(ex)
+	want := []Split{
+		{Name: "161212", Train: []int{0, 1, 2}, Test: []int{3, 4, 5}},
+		{Name: "170131", Train: []int{3, 4, 5}, Test: []int{0, 1, 2}},
+	}
+	if !reflect.DeepEqual(splits, want) {
+		t.Errorf("splits %v, want %v", splits, want)
+	}
+}
@@ -0,0 +1,151 @@
+package eval
+
+import (
+	"sort"
+)
+
+// Thresholds of the verification curves, on the scores of ident in (0, 1].
+const CURVE_POINTS = 101
+
+// Point of the verification curves at a score threshold: a claim is
+// accepted when the score of the claimed subject reaches the threshold.
+type Point struct {
+	Threshold float64 `json:"threshold"`
+	FAR       float64 `json:"far"` // impostor claims accepted
+	FRR       float64 `json:"frr"` // genuine claims rejected
+}
+
+// Result of an evaluation.
+type Result struct {
+	Protocol    string       `json:"protocol"`
+	Model       string       `json:"model"`
+	Folds       []Fold       `json:"folds"`
+	Subjects    []string     `json:"subjects"`
+	Tested      int          `json:"tested"`
+	Unseen      int          `json:"unseen"`
+	Accuracy    float64      `json:"accuracy"`
+	RankRates   []float64    `json:"rankRates"` // identified within the first k, from k = 1
+	Confusion   [][]int      `json:"confusion"` // [true][predicted], in the order of Subjects
+	EER         float64      `json:"eer"`
+	EERScore    float64      `json:"eerThreshold"`
+	Genuine     int          `json:"genuine"`  // claims of the true subject
+	Impostor    int          `json:"impostor"` // claims of another subject
+	Curve       []Point      `json:"curve"`
+	Predictions []Prediction `json:"predictions"`
+}
+
+// Summarise computes the identification and verification results of the
+// predictions. In verification every test capture claims every subject of
+// the model, with the score of its match.
+func Summarise(protocol, model string, folds []Fold, predictions []Prediction) *Result {
+	r := &Result{Protocol: protocol, Model: model, Folds: folds, Predictions: predictions, Tested: len(predictions)}
+	index := make(map[string]int)
+	addSubject := func(s string) {
+		if _, ok := index[s]; !ok && s != "" {
+			index[s] = len(r.Subjects)
+			r.Subjects = append(r.Subjects, s)
+		}
+	}
+	var genuine, impostor []float64
+	for _, p := range predictions {
+		addSubject(p.Subject)
+		for _, m := range p.Matches {
+			addSubject(m.Subject)
+			if m.Subject == p.Subject {
+				genuine = append(genuine, m.Score)
+			} else {
+				impostor = append(impostor, m.Score)
+			}
+		}
+	}
+	for _, f := range folds {
+		r.Unseen += f.Unseen
+	}
+	sort.Strings(r.Subjects)
+	for i, s := range r.Subjects {
+		index[s] = i
+	}
+
+	n := len(r.Subjects)
+	r.Confusion = make([][]int, n)
+	for i := range r.Confusion {
+		r.Confusion[i] = make([]int, n)
+	}
+	r.RankRates = make([]float64, n)
+	for _, p := range predictions {
+		if p.Predicted != "" {
+			r.Confusion[index[p.Subject]][index[p.Predicted]]++
+		}
+		if p.Rank > 0 {
+			for k := p.Rank - 1; k < n; k++ {
+				r.RankRates[k]++
+			}
+		}
+	}
+	if r.Tested > 0 {
+		for k := range r.RankRates {
+			r.RankRates[k] /= float64(r.Tested)
+		}
+		if n > 0 {
+			r.Accuracy = r.RankRates[0]
+		}
+	}
+
+	r.Genuine, r.Impostor = len(genuine), len(impostor)
+	sort.Float64s(genuine)
+	sort.Float64s(impostor)
+	r.EER, r.EERScore = EER(genuine, impostor)
+	r.Curve = make([]Point, CURVE_POINTS)
+	for i := range r.Curve {
+		t := float64(i) / float64(CURVE_POINTS-1)
+		far, frr := Rates(genuine, impostor, t)
+		r.Curve[i] = Point{Threshold: t, FAR: far, FRR: frr}
+	}
+	return r
+}
+
+// Rates of false acceptance and false rejection at a threshold, the scores
+// being sorted.
+func Rates(genuine, impostor []float64, threshold float64) (far, frr float64) {
+	if len(impostor) > 0 {
+		far = float64(len(impostor)-sort.SearchFloat64s(impostor, threshold)) / float64(len(impostor))
+	}
+	if len(genuine) > 0 {
+		frr = float64(sort.SearchFloat64s(genuine, threshold)) / float64(len(genuine))
+	}
+	return far, frr
+}
+
+// EER is the equal error rate, where the false acceptance and rejection
+// rates cross when the threshold goes through the scores, with the
+// threshold it is reached at. Between two scores the rates are
+// interpolated. The scores must be sorted.
+func EER(genuine, impostor []float64) (eer, threshold float64) {
+	if len(genuine) == 0 || len(impostor) == 0 {
+		return 0, 0
+	}
+	scores := append(append([]float64{}, genuine...), impostor...)
+	sort.Float64s(scores)
+	//above all the scores nothing is accepted
+	scores = append(scores, scores[len(scores)-1]+1e-9)
+	//FAR decreases and FRR increases with the threshold
+	prevT := scores[0]
+	prevFAR, prevFRR := Rates(genuine, impostor, prevT)
+	if prevFAR <= prevFRR {
+		return (prevFAR + prevFRR) / 2, prevT
+	}
+	for _, t := range scores[1:] {
+		if t == prevT {
+			continue
+		}
+		far, frr := Rates(genuine, impostor, t)
+		if far <= frr {
+			//FAR - FRR goes from positive to not positive between prevT and t
+			d0, d1 := prevFAR-prevFRR, far-frr
+			f := d0 / (d0 - d1)
+			return prevFAR + f*(far-prevFAR), prevT + f*(t-prevT)
+		}
+		prevT, prevFAR, prevFRR = t, far, frr
+	}
+	return prevFAR, prevT
+}
@@ -0,0 +1,54 @@
+package eval
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestEER(t *testing.T) {
+	tests := []struct {
+		name              string
+		genuine, impostor []float64
+		eer, threshold    float64
+	}{
+		{"separated", []float64{0.6, 0.7, 0.8}, []float64{0.1, 0.2, 0.3}, 0, 0.6},
+		{"overlapping", []float64{0.2, 0.6, 0.8, 0.9}, []float64{0.1, 0.3, 0.5, 0.7}, 0.25, 0.6},
+		{"inverted", []float64{0.1, 0.2}, []float64{0.8, 0.9}, 1, 0.8},
+		{"same score", []float64{0.5}, []float64{0.5}, 0.5, 0.5},
+		{"unsorted", []float64{0.9, 0.2, 0.8, 0.6}, []float64{0.7, 0.1, 0.5, 0.3}, 0.25, 0.6},
+		{"no impostor", []float64{0.5}, nil, 0, 0},
+		{"no genuine", nil, []float64{0.5}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			genuine := append([]float64{}, tt.genuine...)
+			impostor := append([]float64{}, tt.impostor...)
+			sort.Float64s(genuine)
+			sort.Float64s(impostor)
+			eer, threshold := EER(genuine, impostor)
+			if math.Abs(eer-tt.eer) > 1e-6 || math.Abs(threshold-tt.threshold) > 1e-6 {
+				t.Errorf("EER %g at %g, want %g at %g", eer, threshold, tt.eer, tt.threshold)
+			}
+		})
+	}
+}
+
+func TestRates(t *testing.T) {
+	genuine := []float64{0.2, 0.6, 0.8, 0.9}
+	impostor := []float64{0.1, 0.3, 0.5, 0.7}
+	tests := []struct {
+		threshold, far, frr float64
+	}{
+		{0, 1, 0},
+		{0.3, 0.75, 0.25},
+		{0.6, 0.25, 0.25},
+		{0.85, 0, 0.75},
+		{1, 0, 1},
+	}
+	for _, tt := range tests {
+		if far, frr := Rates(genuine, impostor, tt.threshold); far != tt.far || frr != tt.frr {
+			t.Errorf("at %g: FAR %g FRR %g, want %g %g", tt.threshold, far, frr, tt.far, tt.frr)
+		}
+	}
+}
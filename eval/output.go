@@ -0,0 +1,132 @@
+package eval
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// CSV separator, as in the acquisition files.
+const CSV_COMMA = ';'
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// WriteJSON writes the whole result, with the predictions.
+func (r *Result) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Write the records of a table as CSV.
+func writeCSV(w io.Writer, records [][]string) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = CSV_COMMA
+	cw.WriteAll(records)
+	return cw.Error()
+}
+
+// WriteSummaryCSV writes a line per fold and a line "all" with the
+// accuracy and the equal error rate of the whole evaluation.
+func (r *Result) WriteSummaryCSV(w io.Writer) error {
+	records := [][]string{{"protocol", "model", "fold", "train", "test", "unseen", "correct", "accuracy", "eer", "eerThreshold"}}
+	correct := 0
+	for _, f := range r.Folds {
+		acc := ""
+		if tested := f.Test - f.Unseen; tested > 0 {
+			acc = ftoa(float64(f.Correct) / float64(tested))
+		}
+		records = append(records, []string{r.Protocol, r.Model, f.Name,
+			strconv.Itoa(f.Train), strconv.Itoa(f.Test), strconv.Itoa(f.Unseen), strconv.Itoa(f.Correct), acc, "", ""})
+		correct += f.Correct
+	}
+	records = append(records, []string{r.Protocol, r.Model, "all",
+		"", strconv.Itoa(r.Tested + r.Unseen), strconv.Itoa(r.Unseen), strconv.Itoa(correct),
+		ftoa(r.Accuracy), ftoa(r.EER), ftoa(r.EERScore)})
+	return writeCSV(w, records)
+}
+
+// WriteConfusionCSV writes the confusion matrix, a line per true subject
+// and a column per predicted one.
+func (r *Result) WriteConfusionCSV(w io.Writer) error {
+	records := [][]string{append([]string{"subject"}, r.Subjects...)}
+	for i, row := range r.Confusion {
+		record := []string{r.Subjects[i]}
+		for _, n := range row {
+			record = append(record, strconv.Itoa(n))
+		}
+		records = append(records, record)
+	}
+	return writeCSV(w, records)
+}
+
+// WriteRanksCSV writes the rank-k identification rates.
+func (r *Result) WriteRanksCSV(w io.Writer) error {
+	records := [][]string{{"rank", "rate"}}
+	for k, rate := range r.RankRates {
+		records = append(records, []string{strconv.Itoa(k + 1), ftoa(rate)})
+	}
+	return writeCSV(w, records)
+}
+
+// WriteCurveCSV writes the FAR and FRR curves of the verification.
+func (r *Result) WriteCurveCSV(w io.Writer) error {
+	records := [][]string{{"threshold", "far", "frr"}}
+	for _, p := range r.Curve {
+		records = append(records, []string{ftoa(p.Threshold), ftoa(p.FAR), ftoa(p.FRR)})
+	}
+	return writeCSV(w, records)
+}
+
+// WritePredictionsCSV writes a line per test capture with its true and
+// predicted subjects, the rank of the true one and the best score.
+func (r *Result) WritePredictionsCSV(w io.Writer) error {
+	records := [][]string{{"fold", "path", "session", "subject", "predicted", "rank", "score"}}
+	for _, p := range r.Predictions {
+		score := ""
+		if len(p.Matches) > 0 {
+			score = ftoa(p.Matches[0].Score)
+		}
+		records = append(records, []string{p.Fold, p.Path, p.Session, p.Subject, p.Predicted, strconv.Itoa(p.Rank), score})
+	}
+	return writeCSV(w, records)
+}
+
+// Save writes the result as prefix.json and the tables as
+// prefix_summary.csv, prefix_confusion.csv, prefix_ranks.csv,
+// prefix_curve.csv and prefix_predictions.csv, returning the files written.
+func (r *Result) Save(prefix string) ([]string, error) {
+	outputs := []struct {
+		suffix string
+		write  func(io.Writer) error
+	}{
+		{".json", r.WriteJSON},
+		{"_summary.csv", r.WriteSummaryCSV},
+		{"_confusion.csv", r.WriteConfusionCSV},
+		{"_ranks.csv", r.WriteRanksCSV},
+		{"_curve.csv", r.WriteCurveCSV},
+		{"_predictions.csv", r.WritePredictionsCSV},
+	}
+	var files []string
+	for _, o := range outputs {
+		name := prefix + o.suffix
+		f, err := os.Create(name)
+		if err != nil {
+			return files, err
+		}
+		err = o.write(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return files, fmt.Errorf("eval: %s: %v", name, err)
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
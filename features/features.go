@@ -0,0 +1,210 @@
+// Package features turns a capture of the knob, the samples knobID records
+// in g and o/s, into a vector of features describing the micro-action:
+// statistics of every axis and of the magnitudes, the duration of the
+// touch, the rotation of the knob, the jerk and the energies of frequency
+// bands. Only the segment flagged present is used when there is one.
+package features
+
+import (
+	"../knobcsv"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrEmpty is returned for a capture without samples.
+var ErrEmpty = errors.New("features: capture without samples")
+
+// Channels of the statistics: the axes of the accelerometer and of the
+// gyroscope, each followed by their magnitude.
+var CHANNELS = []string{"accX", "accY", "accZ", "acc", "gyrX", "gyrY", "gyrZ", "gyr"}
+
+// Statistics of every channel.
+var STATS = []string{"Mean", "Std", "Min", "Max", "Skew", "Kurt", "Energy", "ZC"}
+
+// Frequency bands of the energies of every channel, Hz.
+var BANDS = []Band{{0.5, 2}, {2, 5}, {5, 10}, {10, 20}, {20, 50}}
+
+// Value of a channel in a sample.
+func channel(s *knobcsv.Sample, ch int) float64 {
+	switch ch {
+	case 0, 1, 2:
+		return s.Acc[ch]
+	case 3:
+		return norm(s.Acc)
+	case 4, 5, 6:
+		return s.Gyr[ch-4]
+	}
+	return norm(s.Gyr)
+}
+
+func norm(v [3]float64) float64 {
+	return math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+}
+
+// Names of the features, in the order of Extract.
+func Names() []string {
+	var names []string
+	for _, ch := range CHANNELS {
+		for _, st := range STATS {
+			names = append(names, ch+st)
+		}
+	}
+	names = append(names, "duration", "rotPeak", "rotPeakX", "rotPeakY", "rotPeakZ", "rotAngle", "rotAngleX", "rotAngleY", "rotAngleZ", "jerkMean", "jerkMax", "jerkRMS")
+	for _, ch := range CHANNELS {
+		for _, b := range BANDS {
+			names = append(names, fmt.Sprintf("%sBand%g-%g", ch, b.Low, b.High))
+		}
+	}
+	return names
+}
+
+// Segment returns the samples of a capture while the knob was touched, all
+// of them if none is flagged present (files without p column).
+func Segment(samples []knobcsv.Sample) []knobcsv.Sample {
+	first, last := -1, -1
+	for i, s := range samples {
+		if s.Present {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return samples
+	}
+	return samples[first : last+1]
+}
+
+// Stats of a signal.
+type Stats struct {
+	Mean, Std, Min, Max float64
+	Skew, Kurt          float64 // 0 for a constant signal, excess kurtosis
+	Energy              float64 // mean square
+	ZC                  int     // crossings of the mean
+}
+
+// Describe computes the statistics of a signal.
+func Describe(x []float64) Stats {
+	var s Stats
+	n := float64(len(x))
+	if len(x) == 0 {
+		return s
+	}
+	s.Min, s.Max = math.Inf(1), math.Inf(-1)
+	for _, v := range x {
+		s.Mean += v
+		s.Energy += v * v
+		s.Min, s.Max = math.Min(s.Min, v), math.Max(s.Max, v)
+	}
+	s.Mean /= n
+	s.Energy /= n
+	var m2, m3, m4 float64
+	for _, v := range x {
+		d := v - s.Mean
+		m2 += d * d
+		m3 += d * d * d
+		m4 += d * d * d * d
+	}
+	m2, m3, m4 = m2/n, m3/n, m4/n
+	s.Std = math.Sqrt(m2)
+	if m2 > 0 {
+		s.Skew = m3 / math.Pow(m2, 1.5)
+		s.Kurt = m4/(m2*m2) - 3
+	}
+	prev := 0.0
+	for _, v := range x {
+		d := v - s.Mean
+		if d == 0 {
+			continue
+		}
+		if prev != 0 && (d > 0) != (prev > 0) {
+			s.ZC++
+		}
+		prev = d
+	}
+	return s
+}
+
+// Rate of a segment from its duration, Hz.
+func rate(seg []knobcsv.Sample) float64 {
+	if len(seg) < 2 {
+		return 0
+	}
+	d := (seg[len(seg)-1].Time - seg[0].Time).Seconds()
+	if d <= 0 {
+		return 0
+	}
+	return float64(len(seg)-1) / d
+}
+
+// Extract computes the features of a capture, named by Names:
+//   - the statistics of every channel,
+//   - the duration of the segment, s,
+//   - the peak rotation rate, of the magnitude and of every axis, o/s,
+//   - the rotation angle, from the integration of the magnitude and of
+//     every axis of the gyroscope, o,
+//   - the mean, maximum and RMS of the magnitude of the jerk, g/s,
+//   - the energies of the BANDS of every channel.
+func Extract(samples []knobcsv.Sample) ([]float64, error) {
+	seg := Segment(samples)
+	if len(seg) == 0 {
+		return nil, ErrEmpty
+	}
+	x := make([]float64, 0, len(CHANNELS)*(len(STATS)+len(BANDS))+12)
+
+	signals := make([][]float64, len(CHANNELS))
+	for ch := range signals {
+		signals[ch] = make([]float64, len(seg))
+		for i := range seg {
+			signals[ch][i] = channel(&seg[i], ch)
+		}
+		s := Describe(signals[ch])
+		x = append(x, s.Mean, s.Std, s.Min, s.Max, s.Skew, s.Kurt, s.Energy, float64(s.ZC))
+	}
+
+	x = append(x, (seg[len(seg)-1].Time - seg[0].Time).Seconds())
+
+	var peak, angle float64
+	var peakAxis, angleAxis [3]float64
+	var jerkSum, jerkMax, jerkSum2 float64
+	jerks := 0
+	for i := range seg {
+		peak = math.Max(peak, norm(seg[i].Gyr))
+		for j := range peakAxis {
+			peakAxis[j] = math.Max(peakAxis[j], math.Abs(seg[i].Gyr[j]))
+		}
+		if i == 0 {
+			continue
+		}
+		dt := (seg[i].Time - seg[i-1].Time).Seconds()
+		if dt <= 0 {
+			continue
+		}
+		angle += (norm(seg[i].Gyr) + norm(seg[i-1].Gyr)) / 2 * dt
+		var da [3]float64
+		for j := range angleAxis {
+			angleAxis[j] += (seg[i].Gyr[j] + seg[i-1].Gyr[j]) / 2 * dt
+			da[j] = (seg[i].Acc[j] - seg[i-1].Acc[j]) / dt
+		}
+		jerk := norm(da)
+		jerkSum += jerk
+		jerkSum2 += jerk * jerk
+		jerkMax = math.Max(jerkMax, jerk)
+		jerks++
+	}
+	x = append(x, peak, peakAxis[0], peakAxis[1], peakAxis[2], angle, angleAxis[0], angleAxis[1], angleAxis[2])
+	if jerks > 0 {
+		n := float64(jerks)
+		x = append(x, jerkSum/n, jerkMax, math.Sqrt(jerkSum2/n))
+	} else {
+		x = append(x, 0, 0, 0)
+	}
+
+	r := rate(seg)
+	for _, signal := range signals {
+		x = append(x, BandEnergies(signal, r, BANDS)...)
+	}
+	return x, nil
+}
@@ -0,0 +1,79 @@
+package features
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// FFT transforms x in place, its length must be a power of 2.
+func FFT(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			wk := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], wk*x[start+k+size/2]
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				wk *= w
+			}
+		}
+	}
+}
+
+// Band of frequencies, in Hz, Low included.
+type Band struct {
+	Low, High float64
+}
+
+// BandEnergies splits the mean square of a signal sampled at rate Hz among
+// bands: the mean is removed and the signal zero padded to a power of 2,
+// the energies of the bands add up to the variance when they cover up to
+// half the rate.
+func BandEnergies(x []float64, rate float64, bands []Band) []float64 {
+	e := make([]float64, len(bands))
+	n := len(x)
+	if n < 2 || rate <= 0 {
+		return e
+	}
+	mean := 0.0
+	for _, v := range x {
+		mean += v
+	}
+	mean /= float64(n)
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+	c := make([]complex128, size)
+	for i, v := range x {
+		c[i] = complex(v-mean, 0)
+	}
+	FFT(c)
+	//one sided spectrum, by Parseval sum |X|^2 / (size n) is the mean
+	//square of the n samples
+	for k := 0; k <= size/2; k++ {
+		p := real(c[k])*real(c[k]) + imag(c[k])*imag(c[k])
+		if k > 0 && k < size/2 {
+			p *= 2
+		}
+		p /= float64(size) * float64(n)
+		f := float64(k) * rate / float64(size)
+		for i, b := range bands {
+			if f >= b.Low && f < b.High {
+				e[i] += p
+			}
+		}
+	}
+	return e
+}
@@ -0,0 +1,245 @@
+// character device backend, the /dev/gpiochipN line handle interface (GPIO uAPI v1)
+// that replaces /sys/class/gpio on modern kernels
+package gpio
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// ioctls and flags from linux/gpio.h
+	GPIO_GET_LINEHANDLE_IOCTL        = 0xc16cb403
+	GPIOHANDLE_GET_LINE_VALUES_IOCTL = 0xc040b408
+	GPIOHANDLE_SET_LINE_VALUES_IOCTL = 0xc040b409
+	GPIO_GET_LINEEVENT_IOCTL         = 0xc030b404
+
+	GPIOHANDLE_REQUEST_INPUT  = 1 << 0
+	GPIOHANDLE_REQUEST_OUTPUT = 1 << 1
+
+	GPIOEVENT_REQUEST_RISING_EDGE  = 1 << 0
+	GPIOEVENT_REQUEST_FALLING_EDGE = 1 << 1
+	GPIOEVENT_EVENT_RISING_EDGE    = 0x01
+	GPIOEVENT_EVENT_FALLING_EDGE   = 0x02
+
+	GPIOHANDLES_MAX = 64
+
+	CONSUMER_LABEL = "knobID"
+)
+
+// struct gpiohandle_request
+type gpiohandleRequest struct {
+	lineOffsets   [GPIOHANDLES_MAX]uint32
+	flags         uint32
+	defaultValues [GPIOHANDLES_MAX]uint8
+	consumerLabel [32]byte
+	lines         uint32
+	fd            int32
+}
+
+// struct gpiohandle_data
+type gpiohandleData struct {
+	values [GPIOHANDLES_MAX]uint8
+}
+
+// struct gpioevent_request
+type gpioeventRequest struct {
+	lineOffset    uint32
+	handleFlags   uint32
+	eventFlags    uint32
+	consumerLabel [32]byte
+	fd            int32
+}
+
+// struct gpioevent_data
+type gpioeventData struct {
+	timestamp uint64
+	id        uint32
+	_         uint32
+}
+
+// A GPIO line requested from a /dev/gpiochipN character device.
+type ChipPin struct {
+	chip   int
+	line   int
+	handle *os.File
+	dir    Direction
+	status Value
+	edge   Edge
+	epfd   int // epoll on the event handle, -1 until SetEdge
+}
+
+// ChipOpener returns an Opener for the lines of /dev/gpiochip<chip>.
+// On the Raspberry Pi the BCM pin numbers are the line offsets of gpiochip0.
+func ChipOpener(chip int) Opener {
+	return func(number int, direction Direction) (Pin, error) {
+		p, err := OpenChipPin(chip, number, direction)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+}
+
+// Request a line of a gpiochip for input or output.
+func OpenChipPin(chip int, line int, direction Direction) (*ChipPin, error) {
+	f, err := os.OpenFile(fmt.Sprintf("/dev/gpiochip%d", chip), os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	req := gpiohandleRequest{lines: 1}
+	req.lineOffsets[0] = uint32(line)
+	switch direction {
+	case IN:
+		req.flags = GPIOHANDLE_REQUEST_INPUT
+	case OUT:
+		req.flags = GPIOHANDLE_REQUEST_OUTPUT
+	}
+	copy(req.consumerLabel[:], CONSUMER_LABEL)
+	if err := ioctl(f.Fd(), GPIO_GET_LINEHANDLE_IOCTL, uintptr(unsafe.Pointer(&req))); err != nil {
+		return nil, fmt.Errorf("gpiochip%d line %d: %v", chip, line, err)
+	}
+
+	return &ChipPin{
+		chip:   chip,
+		line:   line,
+		handle: os.NewFile(uintptr(req.fd), fmt.Sprintf("gpiochip%d-%d", chip, line)),
+		dir:    direction,
+		status: LOW,
+		epfd:   -1,
+	}, nil
+}
+
+// GetStatus
+func (p *ChipPin) GetStatus() Value {
+	return p.status
+}
+
+// Read the current value of the line.
+func (p *ChipPin) Read() (Value, error) {
+	var data gpiohandleData
+	if err := ioctl(p.handle.Fd(), GPIOHANDLE_GET_LINE_VALUES_IOCTL, uintptr(unsafe.Pointer(&data))); err != nil {
+		return LOW, err
+	}
+	if data.values[0] != 0 {
+		p.status = HIGH
+	} else {
+		p.status = LOW
+	}
+	return p.status, nil
+}
+
+// Set the current value of the line.
+func (p *ChipPin) Write(value Value) error {
+	var data gpiohandleData
+	if value == HIGH {
+		data.values[0] = 1
+	}
+	p.status = value
+	return ioctl(p.handle.Fd(), GPIOHANDLE_SET_LINE_VALUES_IOCTL, uintptr(unsafe.Pointer(&data)))
+}
+
+// Toogle value of the line, ONLY in OUT pins
+func (p *ChipPin) Toggle() (Value, error) {
+	if p.dir != OUT {
+		return 0, fmt.Errorf("Unable to toggle IN pin")
+	}
+	var err error
+	switch p.status {
+	case LOW:
+		err = p.Write(HIGH)
+	case HIGH:
+		err = p.Write(LOW)
+	}
+	return p.status, err
+}
+
+// Enable edge detection. The line is requested again as an event line,
+// which still answers to Read. ONLY in IN pins
+func (p *ChipPin) SetEdge(edge Edge) error {
+	if p.dir != IN {
+		return fmt.Errorf("Unable to detect edges on OUT pin")
+	}
+	f, err := os.OpenFile(fmt.Sprintf("/dev/gpiochip%d", p.chip), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	req := gpioeventRequest{
+		lineOffset:  uint32(p.line),
+		handleFlags: GPIOHANDLE_REQUEST_INPUT,
+	}
+	switch edge {
+	case RISING:
+		req.eventFlags = GPIOEVENT_REQUEST_RISING_EDGE
+	case FALLING:
+		req.eventFlags = GPIOEVENT_REQUEST_FALLING_EDGE
+	case BOTH:
+		req.eventFlags = GPIOEVENT_REQUEST_RISING_EDGE | GPIOEVENT_REQUEST_FALLING_EDGE
+	}
+	copy(req.consumerLabel[:], CONSUMER_LABEL)
+
+	// the line is busy while the handle is open
+	p.closeHandle()
+	if err := ioctl(f.Fd(), GPIO_GET_LINEEVENT_IOCTL, uintptr(unsafe.Pointer(&req))); err != nil {
+		// request the line handle again, so the pin still reads
+		if again, rerr := OpenChipPin(p.chip, p.line, p.dir); rerr == nil {
+			p.handle = again.handle
+		}
+		return fmt.Errorf("gpiochip%d line %d events: %v", p.chip, p.line, err)
+	}
+	p.handle = os.NewFile(uintptr(req.fd), fmt.Sprintf("gpiochip%d-%d-event", p.chip, p.line))
+	p.edge = edge
+	if p.epfd, err = newEpoll(p.handle.Fd(), syscall.EPOLLIN); err != nil {
+		return err
+	}
+	_, err = p.Read()
+	return err
+}
+
+// Block until the edge set with SetEdge happens or timeout expires.
+func (p *ChipPin) WaitForEdge(timeout time.Duration) (bool, error) {
+	ok, err := waitEpoll(p.epfd, timeout)
+	if err != nil || !ok {
+		return false, err
+	}
+	var ev gpioeventData
+	buf := (*[unsafe.Sizeof(ev)]byte)(unsafe.Pointer(&ev))
+	if _, err := p.handle.Read(buf[:]); err != nil {
+		return false, err
+	}
+	switch ev.id {
+	case GPIOEVENT_EVENT_RISING_EDGE:
+		p.status = HIGH
+	case GPIOEVENT_EVENT_FALLING_EDGE:
+		p.status = LOW
+	}
+	return true, nil
+}
+
+func (p *ChipPin) closeHandle() error {
+	if p.epfd >= 0 {
+		syscall.Close(p.epfd)
+		p.epfd = -1
+	}
+	return p.handle.Close()
+}
+
+// Release the line.
+func (p *ChipPin) Close() error {
+	return p.closeHandle()
+}
+
+func ioctl(fd, cmd, arg uintptr) error {
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, fd, cmd, arg)
+	if err != 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// edge detection, so input pins can be waited on instead of polled
+package gpio
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// Indicate which transitions of an input pin are reported.
+type Edge int
+
+const (
+	NONE    Edge = iota // no edge detection
+	RISING              // LOW to HIGH
+	FALLING             // HIGH to LOW
+	BOTH                // any change
+)
+
+func (e Edge) String() string {
+	switch e {
+	case RISING:
+		return "rising"
+	case FALLING:
+		return "falling"
+	case BOTH:
+		return "both"
+	}
+	return "none"
+}
+
+// Whether going from old to new is a transition reported by e.
+func (e Edge) matches(old, new Value) bool {
+	switch {
+	case old == new:
+		return false
+	case e == BOTH:
+		return true
+	case e == RISING:
+		return new == HIGH
+	case e == FALLING:
+		return new == LOW
+	}
+	return false
+}
+
+// A pin that can block until its input changes. All the backends implement
+// it for IN pins once SetEdge has been called.
+type EdgePin interface {
+	Pin
+	// Enable the detection of the given transitions.
+	SetEdge(edge Edge) error
+	// Block until an enabled transition happens or timeout expires
+	// (a negative timeout waits forever). Reports whether a transition
+	// happened; the new value is then available with GetStatus.
+	WaitForEdge(timeout time.Duration) (bool, error)
+}
+
+// syscall.EPOLLET is a negative int constant, unusable as an event mask
+const EPOLLET = 1 << 31
+
+// Create an epoll instance watching fd for events.
+func newEpoll(fd uintptr, events uint32) (int, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return -1, err
+	}
+	ev := syscall.EpollEvent{Events: events, Fd: int32(fd)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, int(fd), &ev); err != nil {
+		syscall.Close(epfd)
+		return -1, err
+	}
+	return epfd, nil
+}
+
+// Wait for the epoll instance to report an event.
+func waitEpoll(epfd int, timeout time.Duration) (bool, error) {
+	if epfd < 0 {
+		return false, fmt.Errorf("edge detection not enabled")
+	}
+	msec := -1
+	if timeout >= 0 {
+		msec = int(timeout / time.Millisecond)
+	}
+	events := make([]syscall.EpollEvent, 1)
+	for {
+		n, err := syscall.EpollWait(epfd, events, msec)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return n > 0, nil
+	}
+}
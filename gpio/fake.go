@@ -0,0 +1,199 @@
+// in-memory backend to run and verify the pin users without hardware
+package gpio
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A fake GPIO pin. Inputs follow a script of edges, outputs record every
+// value written to them.
+type FakePin struct {
+	mu     sync.Mutex
+	number int
+	dir    Direction
+	status Value
+	edges  []FakeEdge
+	start  time.Time
+	writes []Value
+	closed bool
+	edge   Edge
+	seen   Value         // value last reported by WaitForEdge
+	notify chan struct{} // wakes WaitForEdge up on Set
+}
+
+// An input change scripted on a FakePin: the pin reads Value from After
+// (measured from the first Read) on.
+type FakeEdge struct {
+	After time.Duration
+	Value Value
+}
+
+// FakeOpener is an Opener that creates fake pins.
+func FakeOpener(number int, direction Direction) (Pin, error) {
+	return NewFakePin(number, direction), nil
+}
+
+// Create a fake pin, LOW until told otherwise.
+func NewFakePin(number int, direction Direction) *FakePin {
+	return &FakePin{
+		number: number,
+		dir:    direction,
+		status: LOW,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Script appends input edges. They must be in increasing After order.
+func (p *FakePin) Script(edges ...FakeEdge) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.edges = append(p.edges, edges...)
+}
+
+// Set forces the input value right now, as an edge happening on the wire.
+func (p *FakePin) Set(value Value) {
+	p.mu.Lock()
+	p.status = value
+	p.mu.Unlock()
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Writes returns the values written to the pin so far, in order.
+func (p *FakePin) Writes() []Value {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Value(nil), p.writes...)
+}
+
+// GetStatus
+func (p *FakePin) GetStatus() Value {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// Read the current value of the pin, applying the edges that are due.
+func (p *FakePin) Read() (Value, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return LOW, fmt.Errorf("fake pin %d is closed", p.number)
+	}
+	p.applyEdges()
+	return p.status, nil
+}
+
+// Apply the scripted edges that are due and return how long until the
+// next one, or -1 if there are no more. Called with the lock held.
+func (p *FakePin) applyEdges() time.Duration {
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+	elapsed := time.Since(p.start)
+	for len(p.edges) > 0 && p.edges[0].After <= elapsed {
+		p.status = p.edges[0].Value
+		p.edges = p.edges[1:]
+		if p.edge.matches(p.seen, p.status) {
+			// report edges one by one to WaitForEdge
+			break
+		}
+	}
+	if len(p.edges) == 0 {
+		return -1
+	}
+	return p.edges[0].After - elapsed
+}
+
+// Enable edge detection. ONLY in IN pins
+func (p *FakePin) SetEdge(edge Edge) error {
+	if p.dir != IN {
+		return fmt.Errorf("Unable to detect edges on OUT pin")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.edge = edge
+	p.applyEdges()
+	p.seen = p.status
+	return nil
+}
+
+// Block until a scripted edge or a Set matching the SetEdge selection
+// happens, or timeout expires.
+func (p *FakePin) WaitForEdge(timeout time.Duration) (bool, error) {
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return false, fmt.Errorf("fake pin %d is closed", p.number)
+		}
+		next := p.applyEdges()
+		changed := p.edge.matches(p.seen, p.status)
+		p.seen = p.status
+		p.mu.Unlock()
+		if changed {
+			return true, nil
+		}
+
+		var due <-chan time.Time
+		var t *time.Timer
+		if next >= 0 {
+			t = time.NewTimer(next)
+			due = t.C
+		}
+		select {
+		case <-expired:
+			return false, nil
+		case <-due:
+		case <-p.notify:
+		}
+		if t != nil {
+			t.Stop()
+		}
+	}
+}
+
+// Set the current value of the pin.
+func (p *FakePin) Write(value Value) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return fmt.Errorf("fake pin %d is closed", p.number)
+	}
+	p.status = value
+	p.writes = append(p.writes, value)
+	return nil
+}
+
+// Toogle value of the pin, ONLY in OUT pins
+func (p *FakePin) Toggle() (Value, error) {
+	if p.dir != OUT {
+		return 0, fmt.Errorf("Unable to toggle IN pin")
+	}
+	var err error
+	switch p.GetStatus() {
+	case LOW:
+		err = p.Write(HIGH)
+	case HIGH:
+		err = p.Write(LOW)
+	}
+	return p.GetStatus(), err
+}
+
+// Close the pin.
+func (p *FakePin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
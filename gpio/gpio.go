@@ -1,6 +1,7 @@
 //based in the pin package of github.com/nathan-osman/go-rpigpio
 //added the value and direction to the pin structur
 //toogle utility
+//Pin interface with sysfs, character device (chardev.go) and fake (fake.go) backends
 package gpio
 
 import (
@@ -8,6 +9,8 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"syscall"
+	"time"
 )
 
 // Indicate whether the pin is used for input or output.
@@ -77,21 +80,51 @@ func setPinDirection(number int, direction Direction) error {
 	return err
 }
 
+// Set the edge that triggers poll(2) on the value file of a pin.
+func setPinEdge(number int, edge Edge) error {
+	filename := fmt.Sprintf("/sys/class/gpio/gpio%d/edge", number)
+	f, err := os.OpenFile(filename, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write([]byte(edge.String() + "\n"))
+	return err
+}
+
 // An individual GPIO pin.
-type Pin struct {
+type Pin interface {
+	// Read the current value of the pin.
+	Read() (Value, error)
+	// Set the current value of the pin.
+	Write(value Value) error
+	// Toggle the value of an OUT pin.
+	Toggle() (Value, error)
+	// Last value read or written.
+	GetStatus() Value
+	// Release the pin.
+	Close() error
+}
+
+// Opener prepares a pin for input or output. OpenPin is the sysfs one.
+type Opener func(number int, direction Direction) (Pin, error)
+
+// A GPIO pin driven through /sys/class/gpio.
+type SysfsPin struct {
 	number int
 	value  *os.File
 	dir    Direction
 	status Value
+	epfd   int // epoll on value, -1 until SetEdge
 }
 
 //GetStatus
-func (p *Pin) GetStatus() Value {
+func (p *SysfsPin) GetStatus() Value {
 	return p.status
 }
 
-// Prepare a pin for input or output.
-func OpenPin(number int, direction Direction) (*Pin, error) {
+// Prepare a pin for input or output through sysfs.
+func OpenPin(number int, direction Direction) (Pin, error) {
 	e, err := isPinExported(number)
 	if err != nil {
 		return nil, err
@@ -115,16 +148,17 @@ func OpenPin(number int, direction Direction) (*Pin, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Pin{
+	return &SysfsPin{
 		number: number,
 		value:  f,
 		dir:    direction, //added
 		status: 0,         //added
+		epfd:   -1,
 	}, nil
 }
 
 // Read the current value of the pin.
-func (p *Pin) Read() (Value, error) {
+func (p *SysfsPin) Read() (Value, error) {
 	// seek to beginning of file in case we've read it before
 	if _, err := p.value.Seek(0, 0); err != nil {
 		return LOW, err
@@ -148,7 +182,7 @@ func (p *Pin) Read() (Value, error) {
 }
 
 // Set the current value of the pin.
-func (p *Pin) Write(value Value) error {
+func (p *SysfsPin) Write(value Value) error {
 	var data []byte
 	p.status = value //added
 	switch value {
@@ -163,7 +197,7 @@ func (p *Pin) Write(value Value) error {
 
 //Toogle value of the pin, ONLY in OUT pins
 //added
-func (p *Pin) Toggle() (Value, error) {
+func (p *SysfsPin) Toggle() (Value, error) {
 	if p.dir != OUT {
 		return 0, fmt.Errorf("Unable to toggle IN pin")
 	}
@@ -179,8 +213,46 @@ func (p *Pin) Toggle() (Value, error) {
 	return p.status, err
 }
 
+// Enable edge detection, the kernel then flags the value file with
+// POLLPRI on every selected transition. ONLY in IN pins
+func (p *SysfsPin) SetEdge(edge Edge) error {
+	if p.dir != IN {
+		return fmt.Errorf("Unable to detect edges on OUT pin")
+	}
+	if err := setPinEdge(p.number, edge); err != nil {
+		return err
+	}
+	if p.epfd < 0 {
+		epfd, err := newEpoll(p.value.Fd(), syscall.EPOLLPRI|syscall.EPOLLERR|EPOLLET)
+		if err != nil {
+			return err
+		}
+		p.epfd = epfd
+		// the value file is always ready right after opening, consume it
+		_, _ = waitEpoll(p.epfd, 0)
+	}
+	_, err := p.Read()
+	return err
+}
+
+// Block until the edge set with SetEdge happens or timeout expires.
+func (p *SysfsPin) WaitForEdge(timeout time.Duration) (bool, error) {
+	ok, err := waitEpoll(p.epfd, timeout)
+	if err != nil || !ok {
+		return false, err
+	}
+	if _, err := p.Read(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // Close the pin.
-func (p *Pin) Close() error {
+func (p *SysfsPin) Close() error {
+	if p.epfd >= 0 {
+		syscall.Close(p.epfd)
+		p.epfd = -1
+	}
 	if err := p.value.Close(); err != nil {
 		return err
 	}
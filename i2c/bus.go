@@ -0,0 +1,66 @@
+package i2c
+
+import "sort"
+
+// Bus is the set of operations a driver needs from a connection to a
+// single i2c device. *I2C implements it on top of /dev/i2c-N and *SimDevice
+// implements it in memory, so drivers written against Bus run the same on
+// the Raspberry Pi and on a laptop.
+type Bus interface {
+	Write(buf []byte) (int, error)
+	Read(p []byte) (int, error)
+	Close() error
+
+	ReadRegU8(reg byte) (byte, error)
+	WriteRegU8(reg byte, value byte) error
+	ReadRegU16BE(reg byte) (uint16, error)
+	ReadRegU16LE(reg byte) (uint16, error)
+	ReadRegS16BE(reg byte) (int16, error)
+	ReadRegS16LE(reg byte) (int16, error)
+	WriteRegU16BE(reg byte, value uint16) error
+	WriteRegU16LE(reg byte, value uint16) error
+	WriteRegS16BE(reg byte, value int16) error
+	WriteRegS16LE(reg byte, value int16) error
+}
+
+// Opener opens a connection to the device at addr on the given bus.
+// NewI2C is the Opener for real hardware; (*Sim).Open is the simulated one.
+type Opener func(addr uint8, bus int) (Bus, error)
+
+// Open is the Opener for the linux i2c-dev interface.
+func Open(addr uint8, bus int) (Bus, error) {
+	d, err := NewI2C(addr, bus)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+var _ Bus = (*I2C)(nil)
+
+// Register is the value of a register, as recorded in the metadata of the
+// acquisitions.
+type Register struct {
+	Name  string `json:"name"`
+	Addr  byte   `json:"addr"`
+	Value byte   `json:"value"`
+}
+
+// ReadRegisters reads the registers of regs, named by their address, in
+// address order.
+func ReadRegisters(dev Bus, regs map[byte]string) ([]Register, error) {
+	addrs := make([]int, 0, len(regs))
+	for addr := range regs {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+	out := make([]Register, 0, len(addrs))
+	for _, addr := range addrs {
+		v, err := dev.ReadRegU8(byte(addr))
+		if err != nil {
+			return out, err
+		}
+		out = append(out, Register{Name: regs[byte(addr)], Addr: byte(addr), Value: v})
+	}
+	return out, nil
+}
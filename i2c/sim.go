@@ -0,0 +1,258 @@
+package i2c
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Sim is an in-memory i2c bus used to run the drivers without hardware.
+// Every address holds a SimDevice: a 256 byte register file with a register
+// pointer that is set by the first byte of a write and auto-incremented by
+// every byte read or written, like the MPU9250 and MPR121 do.
+type Sim struct {
+	mu      sync.Mutex
+	devices map[uint8]*SimDevice
+}
+
+// NewSim creates an empty simulated bus.
+func NewSim() *Sim {
+	return &Sim{devices: make(map[uint8]*SimDevice)}
+}
+
+// Device returns the simulated device at addr, creating it if needed.
+func (s *Sim) Device(addr uint8) *SimDevice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[addr]
+	if !ok {
+		d = &SimDevice{
+			addr:    addr,
+			feeds:   make(map[byte][][]byte),
+			gens:    make(map[byte]func() []byte),
+			streams: make(map[byte]func(n int) []byte),
+		}
+		s.devices[addr] = d
+	}
+	return d
+}
+
+// Open connects to the simulated device at addr. The bus number is ignored.
+// As on a real bus, opening an address nobody answers to fails.
+func (s *Sim) Open(addr uint8, bus int) (Bus, error) {
+	s.mu.Lock()
+	d, ok := s.devices[addr]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("i2c sim: no device at %#02x on bus %d", addr, bus)
+	}
+	return d, nil
+}
+
+// SimWrite is a register write seen by a SimDevice.
+type SimWrite struct {
+	Reg   byte
+	Value byte
+}
+
+// SimDevice is the register file of one simulated device.
+type SimDevice struct {
+	mu      sync.Mutex
+	addr    uint8
+	regs    [256]byte
+	ptr     byte
+	feeds   map[byte][][]byte
+	gens    map[byte]func() []byte
+	streams map[byte]func(n int) []byte
+	onWrite func(reg byte, value byte)
+	writes  []SimWrite
+}
+
+// Addr returns the address of the device.
+func (d *SimDevice) Addr() uint8 {
+	return d.addr
+}
+
+// Set loads values into the register file starting at reg, without
+// triggering the write hook. Used to model power-on and read-only registers.
+func (d *SimDevice) Set(reg byte, values ...byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, v := range values {
+		d.regs[reg+byte(i)] = v
+	}
+}
+
+// Get returns the current content of a register.
+func (d *SimDevice) Get(reg byte) byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.regs[reg]
+}
+
+// Feed queues frames to be loaded at reg, one per read transaction that
+// starts at reg. Once the queue is empty the last frame stays in place.
+// Feeding the 14 bytes at ACCEL_XOUT_H scripts the MPU9250 samples.
+func (d *SimDevice) Feed(reg byte, frames ...[]byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.feeds[reg] = append(d.feeds[reg], frames...)
+}
+
+// Generate makes every read transaction that starts at reg load the bytes
+// returned by fn first. Queued Feed frames take precedence.
+func (d *SimDevice) Generate(reg byte, fn func() []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.gens[reg] = fn
+}
+
+// Stream turns reg into a port, like the MPU9250 FIFO_R_W: a read that
+// starts at reg returns the bytes given by fn for the requested length and
+// the register pointer does not move.
+func (d *SimDevice) Stream(reg byte, fn func(n int) []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.streams[reg] = fn
+}
+
+// OnWrite sets a hook called after every register written through the bus,
+// to model side effects such as a soft reset. The hook may use Set and Get.
+func (d *SimDevice) OnWrite(fn func(reg byte, value byte)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onWrite = fn
+}
+
+// Writes returns the register writes seen so far, in order.
+func (d *SimDevice) Writes() []SimWrite {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]SimWrite(nil), d.writes...)
+}
+
+// Write sets the register pointer to buf[0] and stores the rest of buf
+// from there on.
+func (d *SimDevice) Write(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	d.mu.Lock()
+	d.ptr = buf[0]
+	written := make([]SimWrite, 0, len(buf)-1)
+	for _, v := range buf[1:] {
+		d.regs[d.ptr] = v
+		written = append(written, SimWrite{Reg: d.ptr, Value: v})
+		d.ptr++
+	}
+	d.writes = append(d.writes, written...)
+	hook := d.onWrite
+	d.mu.Unlock()
+
+	if hook != nil {
+		for _, w := range written {
+			hook(w.Reg, w.Value)
+		}
+	}
+	return len(buf), nil
+}
+
+// Read reads len(p) registers starting at the register pointer.
+func (d *SimDevice) Read(p []byte) (int, error) {
+	d.mu.Lock()
+	start := d.ptr
+	var frame []byte
+	if q := d.feeds[start]; len(q) > 0 {
+		frame = q[0]
+		d.feeds[start] = q[1:]
+	}
+	gen := d.gens[start]
+	stream := d.streams[start]
+	d.mu.Unlock()
+
+	if stream != nil {
+		return copy(p, stream(len(p))), nil
+	}
+	if frame == nil && gen != nil {
+		frame = gen()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, v := range frame {
+		d.regs[start+byte(i)] = v
+	}
+	for i := range p {
+		p[i] = d.regs[d.ptr]
+		d.ptr++
+	}
+	return len(p), nil
+}
+
+// Close is a no-op, the device stays on the bus.
+func (d *SimDevice) Close() error {
+	return nil
+}
+
+func (d *SimDevice) ReadRegU8(reg byte) (byte, error) {
+	if _, err := d.Write([]byte{reg}); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1)
+	if _, err := d.Read(buf); err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+func (d *SimDevice) WriteRegU8(reg byte, value byte) error {
+	_, err := d.Write([]byte{reg, value})
+	return err
+}
+
+func (d *SimDevice) ReadRegU16BE(reg byte) (uint16, error) {
+	if _, err := d.Write([]byte{reg}); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 2)
+	if _, err := d.Read(buf); err != nil {
+		return 0, err
+	}
+	return uint16(buf[0])<<8 | uint16(buf[1]), nil
+}
+
+func (d *SimDevice) ReadRegU16LE(reg byte) (uint16, error) {
+	w, err := d.ReadRegU16BE(reg)
+	if err != nil {
+		return 0, err
+	}
+	return w<<8 | w>>8, nil
+}
+
+func (d *SimDevice) ReadRegS16BE(reg byte) (int16, error) {
+	w, err := d.ReadRegU16BE(reg)
+	return int16(w), err
+}
+
+func (d *SimDevice) ReadRegS16LE(reg byte) (int16, error) {
+	w, err := d.ReadRegU16LE(reg)
+	return int16(w), err
+}
+
+func (d *SimDevice) WriteRegU16BE(reg byte, value uint16) error {
+	_, err := d.Write([]byte{reg, byte(value >> 8), byte(value)})
+	return err
+}
+
+func (d *SimDevice) WriteRegU16LE(reg byte, value uint16) error {
+	return d.WriteRegU16BE(reg, value<<8|value>>8)
+}
+
+func (d *SimDevice) WriteRegS16BE(reg byte, value int16) error {
+	return d.WriteRegU16BE(reg, uint16(value))
+}
+
+func (d *SimDevice) WriteRegS16LE(reg byte, value int16) error {
+	return d.WriteRegU16LE(reg, uint16(value))
+}
+
+var _ Bus = (*SimDevice)(nil)
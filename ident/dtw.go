@@ -0,0 +1,187 @@
+package ident
+
+import (
+	"../features"
+	"../knobcsv"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Default parameters of the DTW model.
+const (
+	DTW_K      = 1
+	DTW_LENGTH = 64  // points of the resampled signals
+	DTW_BAND   = 0.1 // of the length, Sakoe-Chiba band of the warping
+)
+
+// Channels of the DTW signals, the axes of the accelerometer and gyroscope.
+const DTW_CHANNELS = 6
+
+// Template is the resampled signal of an example.
+type Template struct {
+	Subject string                  `json:"subject"`
+	Series  [][DTW_CHANNELS]float64 `json:"series"` // scaled
+}
+
+// DTW is the template matcher: the segments of the captures are resampled
+// to Length points, every channel divided by its standard deviation over
+// the training examples, and compared with dynamic time warping within a
+// band of Band times the length. A subject is scored by its K templates
+// closest to the capture to identify.
+type DTW struct {
+	K         int                   `json:"k"`
+	Length    int                   `json:"length"`
+	Band      float64               `json:"band"`
+	Scale     [DTW_CHANNELS]float64 `json:"scale"`
+	Templates []Template            `json:"templates"`
+}
+
+// NewDTW returns an untrained DTW model with the default parameters.
+func NewDTW() *DTW {
+	return &DTW{K: DTW_K, Length: DTW_LENGTH, Band: DTW_BAND}
+}
+
+// Kind is KIND_DTW.
+func (m *DTW) Kind() string {
+	return KIND_DTW
+}
+
+// Subjects of the templates, sorted.
+func (m *DTW) Subjects() []string {
+	return subjects(func(i int) string { return m.Templates[i].Subject }, len(m.Templates))
+}
+
+// resample interpolates the segment of a capture linearly to n points,
+// evenly spaced in time.
+func resample(samples []knobcsv.Sample, n int) ([][DTW_CHANNELS]float64, error) {
+	seg := features.Segment(samples)
+	if len(seg) == 0 {
+		return nil, features.ErrEmpty
+	}
+	series := make([][DTW_CHANNELS]float64, n)
+	t0, t1 := seg[0].Time, seg[len(seg)-1].Time
+	j := 0
+	for i := range series {
+		t := t0
+		if n > 1 {
+			t += (t1 - t0) * time.Duration(i) / time.Duration(n-1)
+		}
+		for j < len(seg)-1 && seg[j+1].Time < t {
+			j++
+		}
+		a, b := &seg[j], &seg[min(j+1, len(seg)-1)]
+		f := 0.0
+		if b.Time > a.Time {
+			f = float64(t-a.Time) / float64(b.Time-a.Time)
+		}
+		f = math.Max(0, math.Min(1, f))
+		for k := 0; k < 3; k++ {
+			series[i][k] = a.Acc[k] + f*(b.Acc[k]-a.Acc[k])
+			series[i][3+k] = a.Gyr[k] + f*(b.Gyr[k]-a.Gyr[k])
+		}
+	}
+	return series, nil
+}
+
+// Train resamples the examples into templates and computes the scales of
+// the channels.
+func (m *DTW) Train(examples []Example) error {
+	if len(examples) == 0 {
+		return fmt.Errorf("ident: no example to train")
+	}
+	if m.Length < 2 {
+		return fmt.Errorf("ident: DTW length %d too short", m.Length)
+	}
+	m.Templates = make([]Template, 0, len(examples))
+	var sum, sum2 [DTW_CHANNELS]float64
+	n := 0
+	for _, e := range examples {
+		series, err := resample(e.Samples, m.Length)
+		if err != nil {
+			return fmt.Errorf("ident: %s: %v", e.Path, err)
+		}
+		for _, p := range series {
+			for k, v := range p {
+				sum[k] += v
+				sum2[k] += v * v
+			}
+		}
+		n += len(series)
+		m.Templates = append(m.Templates, Template{Subject: e.Subject, Series: series})
+	}
+	for k := range m.Scale {
+		mean := sum[k] / float64(n)
+		m.Scale[k] = math.Sqrt(math.Max(sum2[k]/float64(n)-mean*mean, 0))
+		if m.Scale[k] == 0 {
+			m.Scale[k] = 1
+		}
+	}
+	for _, t := range m.Templates {
+		m.scale(t.Series)
+	}
+	return nil
+}
+
+// scale divides the channels of a series in place by their scale.
+func (m *DTW) scale(series [][DTW_CHANNELS]float64) {
+	for i := range series {
+		for k := range series[i] {
+			series[i][k] /= m.Scale[k]
+		}
+	}
+}
+
+// Distance is the dynamic time warping distance of two series within a
+// band of w points, the Euclidean distances of the points summed along the
+// best path and divided by the lengths of both series.
+func Distance(a, b [][DTW_CHANNELS]float64, w int) float64 {
+	n, m := len(a), len(b)
+	w = max(w, abs(n-m))
+	inf := math.Inf(1)
+	prev, cur := make([]float64, m+1), make([]float64, m+1)
+	for j := range prev {
+		prev[j] = inf
+	}
+	prev[0] = 0
+	for i := 1; i <= n; i++ {
+		for j := range cur {
+			cur[j] = inf
+		}
+		for j := max(1, i-w); j <= min(m, i+w); j++ {
+			d := 0.0
+			for k := range a[i-1] {
+				d += (a[i-1][k] - b[j-1][k]) * (a[i-1][k] - b[j-1][k])
+			}
+			cur[j] = math.Sqrt(d) + math.Min(prev[j-1], math.Min(prev[j], cur[j-1]))
+		}
+		prev, cur = cur, prev
+	}
+	return prev[m] / float64(n+m)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Rank scores the subjects by the mean distance of their K closest
+// templates.
+func (m *DTW) Rank(samples []knobcsv.Sample) ([]Match, error) {
+	if len(m.Templates) == 0 {
+		return nil, ErrUntrained
+	}
+	series, err := resample(samples, m.Length)
+	if err != nil {
+		return nil, err
+	}
+	m.scale(series)
+	w := int(math.Ceil(m.Band * float64(m.Length)))
+	distances := make(map[string][]float64)
+	for _, t := range m.Templates {
+		distances[t.Subject] = append(distances[t.Subject], Distance(series, t.Series, w))
+	}
+	return rank(distances, max(m.K, 1)), nil
+}
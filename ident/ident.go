@@ -0,0 +1,261 @@
+// Package ident identifies the subject touching the knob from a capture.
+// A Model is trained on the captures of the enrolled subjects and ranks the
+// subjects for a new capture, best first, with a score in (0, 1]: the
+// higher, the closer the capture is to the ones of the subject. Two models
+// are provided, a nearest-neighbour one on the features of the features
+// package (KNN) and a template matcher with dynamic time warping on the
+// signals (DTW).
+//
+// Captures are the samples of knobcsv, in g and o/s, whatever full scale
+// they were taken with; only the segment flagged present is used when
+// there is one, see features.Segment.
+package ident
+
+import (
+	"../dataset"
+	"../knobcsv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Kinds of models.
+const (
+	KIND_KNN = "knn"
+	KIND_DTW = "dtw"
+)
+
+// Subject of a capture no subject scores enough for.
+const UNKNOWN = "unknown"
+
+// ErrUntrained is returned when ranking with a model not trained.
+var ErrUntrained = errors.New("ident: model not trained")
+
+// Example is an enrolled capture of a subject.
+type Example struct {
+	Subject string
+	Session string
+	Path    string // file of the capture, for the reports
+	Samples []knobcsv.Sample
+}
+
+// Match is the score of a subject for a capture.
+type Match struct {
+	Subject  string  `json:"subject"`
+	Score    float64 `json:"score"`    // in (0, 1], 1 for an identical capture
+	Distance float64 `json:"distance"` // to the closest captures of the subject
+}
+
+// Model of the subjects.
+type Model interface {
+	Kind() string
+	// Train replaces the model with the one of the examples.
+	Train(examples []Example) error
+	// Rank scores every subject of the model for a capture, best first.
+	Rank(samples []knobcsv.Sample) ([]Match, error)
+	Subjects() []string
+}
+
+// New returns an untrained model with the default parameters.
+func New(kind string) (Model, error) {
+	switch kind {
+	case KIND_KNN:
+		return NewKNN(), nil
+	case KIND_DTW:
+		return NewDTW(), nil
+	}
+	return nil, fmt.Errorf("ident: unknown model %q (%s, %s)", kind, KIND_KNN, KIND_DTW)
+}
+
+// NewK returns an untrained model scoring the subjects by their k closest
+// captures, the default of the model if k is 0.
+func NewK(kind string, k int) (Model, error) {
+	m, err := New(kind)
+	if err != nil || k <= 0 {
+		return m, err
+	}
+	switch m := m.(type) {
+	case *KNN:
+		m.K = k
+	case *DTW:
+		m.K = k
+	}
+	return m, nil
+}
+
+// Identify ranks the subjects for a capture and decides on the best ranked
+// one, or UNKNOWN if its score is below the threshold.
+func Identify(m Model, samples []knobcsv.Sample, threshold float64) (string, []Match, error) {
+	matches, err := m.Rank(samples)
+	if err != nil {
+		return UNKNOWN, nil, err
+	}
+	if len(matches) == 0 || matches[0].Score < threshold {
+		return UNKNOWN, matches, nil
+	}
+	return matches[0].Subject, matches, nil
+}
+
+// Score of a distance.
+func score(d float64) float64 {
+	return 1 / (1 + d)
+}
+
+// rank scores the subjects from their distances to the capture: the mean
+// of the k smallest distances of each subject.
+func rank(distances map[string][]float64, k int) []Match {
+	matches := make([]Match, 0, len(distances))
+	for subject, ds := range distances {
+		sort.Float64s(ds)
+		n := min(k, len(ds))
+		sum := 0.0
+		for _, d := range ds[:n] {
+			sum += d
+		}
+		d := sum / float64(n)
+		matches = append(matches, Match{Subject: subject, Score: score(d), Distance: d})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Subject < matches[j].Subject
+	})
+	return matches
+}
+
+// Subjects of examples, sorted.
+func subjects(subjectOf func(i int) string, n int) []string {
+	seen := make(map[string]bool)
+	var ss []string
+	for i := 0; i < n; i++ {
+		if s := subjectOf(i); !seen[s] {
+			seen[s] = true
+			ss = append(ss, s)
+		}
+	}
+	sort.Strings(ss)
+	return ss
+}
+
+// FileError is a file of a manifest that could not be read.
+type FileError struct {
+	Path string // as in the manifest
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+// SkipList is the list of the files skipped by Examples.
+type SkipList []*FileError
+
+func (l SkipList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no files skipped"
+	case 1:
+		return "ident: file skipped, " + l[0].Error()
+	}
+	return fmt.Sprintf("ident: %d files skipped, %s (and %d more)", len(l), l[0], len(l)-1)
+}
+
+// Examples reads the captures of the entries of a manifest, root being its
+// data folder. Every acquisition of a file is an example, the files of no
+// subject are skipped. The files that cannot be read, or have malformed
+// lines, are skipped and reported in a SkipList along with the examples
+// of the others.
+func Examples(root string, m dataset.Manifest) ([]Example, error) {
+	var (
+		examples []Example
+		skipped  SkipList
+	)
+	for _, e := range m {
+		if e.Subject == "" {
+			continue
+		}
+		acqs, err := knobcsv.ReadFile(filepath.Join(root, filepath.FromSlash(e.Path)))
+		if err != nil {
+			skipped = append(skipped, &FileError{Path: e.Path, Err: err})
+			continue
+		}
+		for _, a := range acqs {
+			if len(a.Samples) == 0 {
+				continue
+			}
+			examples = append(examples, Example{Subject: e.Subject, Session: e.Session, Path: e.Path, Samples: a.Samples})
+		}
+	}
+	if len(skipped) > 0 {
+		return examples, skipped
+	}
+	return examples, nil
+}
+
+// Layout of a model file.
+type modelFile struct {
+	Kind      string          `json:"kind"`
+	Saved     time.Time       `json:"saved"`
+	Subjects  []string        `json:"subjects"`
+	Threshold float64         `json:"threshold,omitempty"`
+	Model     json.RawMessage `json:"model"`
+}
+
+// Save writes a trained model as JSON with the score under which its
+// subject is unknown, 0 if it was not calibrated. The scores depend on
+// the kind of model and on the data, the threshold is the EER one of a
+// cross-validation on the training captures, see knobIdent.
+func Save(w io.Writer, m Model, threshold float64) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(modelFile{Kind: m.Kind(), Saved: time.Now(), Subjects: m.Subjects(), Threshold: threshold, Model: b})
+}
+
+// Load reads a model written by Save, with its threshold.
+func Load(r io.Reader) (Model, float64, error) {
+	var f modelFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, 0, fmt.Errorf("ident: %v", err)
+	}
+	m, err := New(f.Kind)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := json.Unmarshal(f.Model, m); err != nil {
+		return nil, 0, fmt.Errorf("ident: %s model: %v", f.Kind, err)
+	}
+	return m, f.Threshold, nil
+}
+
+// SaveFile writes a trained model to a file, see Save.
+func SaveFile(name string, m Model, threshold float64) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	err = Save(f, m, threshold)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// LoadFile reads a model file, with its threshold.
+func LoadFile(name string) (Model, float64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	return Load(f)
+}
@@ -0,0 +1,118 @@
+package ident
+
+import (
+	"../features"
+	"../knobcsv"
+	"fmt"
+	"math"
+)
+
+// Default neighbours of every subject averaged by the KNN model.
+const KNN_K = 3
+
+// Vector of features of an example.
+type Vector struct {
+	Subject string    `json:"subject"`
+	X       []float64 `json:"x"` // standardised
+}
+
+// KNN is the nearest-neighbour model: the captures are compared by the
+// Euclidean distance of their standardised features (features.Extract),
+// and a subject is scored by its K captures closest to the one to identify
+// (K = 1 is the plain nearest neighbour).
+type KNN struct {
+	K       int       `json:"k"`
+	Names   []string  `json:"names"` // of the features
+	Mean    []float64 `json:"mean"`
+	Std     []float64 `json:"std"`
+	Vectors []Vector  `json:"vectors"`
+}
+
+// NewKNN returns an untrained KNN model with K = KNN_K.
+func NewKNN() *KNN {
+	return &KNN{K: KNN_K}
+}
+
+// Kind is KIND_KNN.
+func (m *KNN) Kind() string {
+	return KIND_KNN
+}
+
+// Subjects of the training examples, sorted.
+func (m *KNN) Subjects() []string {
+	return subjects(func(i int) string { return m.Vectors[i].Subject }, len(m.Vectors))
+}
+
+// Train computes the features of the examples and their standardisation.
+func (m *KNN) Train(examples []Example) error {
+	if len(examples) == 0 {
+		return fmt.Errorf("ident: no example to train")
+	}
+	m.Names = features.Names()
+	m.Vectors = make([]Vector, 0, len(examples))
+	for _, e := range examples {
+		x, err := features.Extract(e.Samples)
+		if err != nil {
+			return fmt.Errorf("ident: %s: %v", e.Path, err)
+		}
+		m.Vectors = append(m.Vectors, Vector{Subject: e.Subject, X: x})
+	}
+	n := len(m.Names)
+	m.Mean, m.Std = make([]float64, n), make([]float64, n)
+	for _, v := range m.Vectors {
+		for i, x := range v.X {
+			m.Mean[i] += x
+		}
+	}
+	for i := range m.Mean {
+		m.Mean[i] /= float64(len(m.Vectors))
+	}
+	for _, v := range m.Vectors {
+		for i, x := range v.X {
+			m.Std[i] += (x - m.Mean[i]) * (x - m.Mean[i])
+		}
+	}
+	for i := range m.Std {
+		m.Std[i] = math.Sqrt(m.Std[i] / float64(len(m.Vectors)))
+	}
+	for _, v := range m.Vectors {
+		m.standardise(v.X)
+	}
+	return nil
+}
+
+// standardise scales features in place, constant features are only
+// centred.
+func (m *KNN) standardise(x []float64) {
+	for i := range x {
+		x[i] -= m.Mean[i]
+		if m.Std[i] > 0 {
+			x[i] /= m.Std[i]
+		}
+	}
+}
+
+// Rank scores the subjects by the mean distance of their K closest
+// captures.
+func (m *KNN) Rank(samples []knobcsv.Sample) ([]Match, error) {
+	if len(m.Vectors) == 0 {
+		return nil, ErrUntrained
+	}
+	x, err := features.Extract(samples)
+	if err != nil {
+		return nil, err
+	}
+	if len(x) != len(m.Mean) {
+		return nil, fmt.Errorf("ident: %d features, model trained with %d", len(x), len(m.Mean))
+	}
+	m.standardise(x)
+	distances := make(map[string][]float64)
+	for _, v := range m.Vectors {
+		d := 0.0
+		for i := range x {
+			d += (x[i] - v.X[i]) * (x[i] - v.X[i])
+		}
+		distances[v.Subject] = append(distances[v.Subject], math.Sqrt(d))
+	}
+	return rank(distances, max(m.K, 1)), nil
+}
@@ -0,0 +1,154 @@
+// knobConv converts the acquisition files between the CSV format and the
+// binary format of knobbin (.knb): CSV files are converted to binary and
+// binary files to CSV, next to them or into -o.
+//
+//	go run knobConv.go data/elec03/*.csv
+//	go run knobConv.go -o /tmp data/elec03/i001a8w250_00.knb
+//
+// The full scales of a CSV file come from its header or, when it has none,
+// from -acc and -gyro or else from its name (16G1K_0.csv).
+
+package main
+
+import (
+	"./dataset"
+	"./knobbin"
+	"./knobcsv"
+	"./mpu9250"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Full scales of a CSV acquisition: the header, the flags, the file name.
+func fullScales(file string, h *knobcsv.Header, accFlag, gyrFlag int) (mpu9250.AccelFS, mpu9250.GyroFS, error) {
+	name, _ := dataset.ParseName(file)
+	acc := h.AccFS
+	if acc == 0 {
+		acc = accFlag
+	}
+	if acc == 0 {
+		acc = name.AccFS
+	}
+	gyr := h.GyrFS
+	if gyr == 0 {
+		gyr = gyrFlag
+	}
+	if gyr == 0 {
+		gyr = name.GyrFS
+	}
+	accelFS, err := mpu9250.AccelFSFromG(acc)
+	if err != nil {
+		return accelFS, 0, fmt.Errorf("%v, use -acc", err)
+	}
+	gyroFS, err := mpu9250.GyroFSFromDPS(gyr)
+	if err != nil {
+		return accelFS, gyroFS, fmt.Errorf("%v, use -gyro", err)
+	}
+	return accelFS, gyroFS, nil
+}
+
+// Output file of an input one, with the other extension.
+func outputName(file, ext, dir string) string {
+	out := strings.TrimSuffix(file, filepath.Ext(file)) + ext
+	if dir != "" {
+		out = filepath.Join(dir, filepath.Base(out))
+	}
+	return out
+}
+
+// csvToBin converts all the acquisitions of a CSV file.
+func csvToBin(file, out string, accFlag, gyrFlag int) (int, error) {
+	acqs, err := knobcsv.ReadFile(file)
+	if err != nil {
+		return 0, err //malformed rows would be lost
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	for _, c := range acqs {
+		accelFS, gyroFS, err := fullScales(file, &c.Header, accFlag, gyrFlag)
+		if err != nil {
+			return 0, err
+		}
+		a, err := knobbin.FromCSV(&c, accelFS.G(), accelFS.Sensitivity(), gyroFS.DPS(), gyroFS.Sensitivity())
+		if err != nil {
+			return 0, fmt.Errorf("%s:%d: %v", file, c.Line, err)
+		}
+		if err := knobbin.Write(f, a); err != nil {
+			return 0, err
+		}
+	}
+	return len(acqs), f.Close()
+}
+
+// binToCSV converts all the acquisitions of a binary file.
+func binToCSV(file, out string) (int, error) {
+	acqs, err := knobbin.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	for _, a := range acqs {
+		c, err := a.CSV()
+		if err != nil {
+			return 0, err
+		}
+		if err := knobcsv.Write(f, c); err != nil {
+			return 0, err
+		}
+	}
+	return len(acqs), f.Close()
+}
+
+func main() {
+	var (
+		outDir string
+		accFS  int
+		gyrFS  int
+	)
+	flag.StringVar(&outDir, "o", "", "Output directory, next to the input files if empty")
+	flag.IntVar(&accFS, "acc", 0, "Accelerometer full scale g of the CSV files without it (2, 4, 8, 16)")
+	flag.IntVar(&gyrFS, "gyro", 0, "Gyroscope full scale dps of the CSV files without it (250, 500, 1000, 2000)")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatal("No file to convert")
+	}
+	failed := 0
+	for _, file := range flag.Args() {
+		var out string
+		var n int
+		var err error
+		switch strings.ToLower(filepath.Ext(file)) {
+		case dataset.EXTENSION:
+			out = outputName(file, knobbin.EXTENSION, outDir)
+			n, err = csvToBin(file, out, accFS, gyrFS)
+		case knobbin.EXTENSION:
+			out = outputName(file, dataset.EXTENSION, outDir)
+			n, err = binToCSV(file, out)
+		default:
+			err = fmt.Errorf("unknown extension")
+		}
+		if err != nil {
+			log.Printf("Error converting %s: %v", file, err)
+			failed++
+			continue
+		}
+		in, _ := os.Stat(file)
+		res, _ := os.Stat(out)
+		log.Printf("%s -> %s: %d acquisitions, %d -> %d bytes", file, out, n, in.Size(), res.Size())
+	}
+	if failed > 0 {
+		log.Fatalf("%d files not converted", failed)
+	}
+}
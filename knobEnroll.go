@@ -0,0 +1,60 @@
+// knobEnroll registers the subjects of the dataset under a pseudonymous id
+// and lists them with their repetitions. The captures of a registered
+// subject are then taken by knobID in enrollment mode, which guides the
+// repetitions and resumes their numbering:
+//
+//	go run knobEnroll.go -hand right -age 34 -sex f
+//	go run knobID.go -subject i048 -reps 10 -dir 170301 -acc 8 -gyro 250
+//	go run knobEnroll.go -list -dir 170301 -conf a8w250
+
+package main
+
+import (
+	"./enroll"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+func checkError(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	var (
+		registryFile string
+		list         bool
+		dirArg       string
+		conf         string
+		subject      enroll.Subject
+	)
+	flag.StringVar(&registryFile, "registry", enroll.DefaultRegistry("data"), "Registry of the subjects")
+	flag.BoolVar(&list, "list", false, "List the subjects instead of registering one")
+	flag.StringVar(&dirArg, "dir", "data", "Directory of the acquisitions, under data, for -list")
+	flag.StringVar(&conf, "conf", "", "Configuration of the acquisitions (a8w250), for -list")
+	flag.StringVar(&subject.ID, "id", "", "Id of the subject, the next free one if empty")
+	flag.StringVar(&subject.Handedness, "hand", "", "Handedness (right, left, ambidextrous)")
+	flag.IntVar(&subject.Age, "age", 0, "Age, optional")
+	flag.StringVar(&subject.Sex, "sex", "", "Sex, optional")
+	flag.StringVar(&subject.Notes, "notes", "", "Notes, optional, no names")
+	flag.Parse()
+
+	registry, err := enroll.Load(registryFile)
+	checkError(err)
+
+	if list {
+		summary, err := registry.Summary(filepath.Join("./data", dirArg), conf)
+		checkError(err)
+		fmt.Print(summary)
+		log.Printf("%d subjects registered", len(registry.Subjects))
+		return
+	}
+
+	checkError(registry.Register(&subject))
+	checkError(registry.Save())
+	log.Printf("Subject %s registered (%s handed) in %s", subject.ID, subject.Handedness, registryFile)
+	log.Printf("Take the captures with: knobID -subject %s -reps 10 -dir <session>", subject.ID)
+}
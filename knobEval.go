@@ -0,0 +1,129 @@
+// knobEval cross-validates an identification model on the data folder, by
+// leaving every session out in turn or with k folds grouped by subject, and
+// writes the results for the papers: the accuracy per fold, the confusion
+// matrix, the rank-k rates, the FAR/FRR curves and the EER of verification
+// and every prediction, as -o.json and -o_*.csv.
+//
+//	go run knobEval.go -protocol loso -kind knn -conf a8w250 -o results/loso_knn
+//	go run knobEval.go -protocol kfold -folds 5 -kind dtw -session 170131 -o results/kfold_dtw
+
+package main
+
+import (
+	"./dataset"
+	"./eval"
+	"./ident"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+func checkError(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	var (
+		dataDir  string
+		manifest string
+		subject  string
+		session  string
+		conf     string
+		protocol string
+		folds    int
+		seed     int64
+		kind     string
+		k        int
+		output   string
+	)
+	flag.StringVar(&dataDir, "data", "data", "Root folder of the acquisition files")
+	flag.StringVar(&manifest, "manifest", "", "Manifest of the files (knobIndex), the data folder is indexed if empty")
+	flag.StringVar(&subject, "subject", "", "Only the files of a subject (i001)")
+	flag.StringVar(&session, "session", "", "Only the files of a session folder (170131)")
+	flag.StringVar(&conf, "conf", "", "Only the files of a configuration (a8w250)")
+	flag.StringVar(&protocol, "protocol", eval.LEAVE_ONE_SESSION_OUT, "Cross-validation (loso, kfold)")
+	flag.IntVar(&folds, "folds", 5, "Folds of kfold")
+	flag.Int64Var(&seed, "seed", 1, "Seed of the kfold shuffle")
+	flag.StringVar(&kind, "kind", ident.KIND_KNN, "Model (knn, dtw)")
+	flag.IntVar(&k, "k", 0, "Closest captures scoring a subject, the default of the model if 0")
+	flag.StringVar(&output, "o", "eval", "Prefix of the result files")
+	flag.Parse()
+
+	var m dataset.Manifest
+	var err error
+	if manifest != "" {
+		m, err = dataset.Load(manifest)
+	} else {
+		m, err = dataset.Index(dataDir)
+	}
+	checkError(err)
+	m = m.Filter(subject, session, conf)
+	examples, err := ident.Examples(dataDir, m)
+	if skipped, ok := err.(ident.SkipList); ok {
+		for _, fe := range skipped {
+			log.Printf("Error reading %s: %v", fe.Path, fe.Err)
+		}
+		log.Printf("%d files skipped", len(skipped))
+	} else {
+		checkError(err)
+	}
+	log.Printf("%d captures of %d subjects", len(examples), len(m.Subjects()))
+	//no result at all rather than empty ones
+	if len(examples) == 0 {
+		log.Fatalf("No capture to evaluate in %s (a symlinked folder is not followed, give its target)", dataDir)
+	}
+
+	var splits []eval.Split
+	switch protocol {
+	case eval.LEAVE_ONE_SESSION_OUT:
+		splits = eval.LeaveOneSessionOut(examples)
+	case eval.K_FOLD:
+		splits, err = eval.KFold(examples, folds, seed)
+		checkError(err)
+	default:
+		log.Fatalf("Unknown protocol %q (%s, %s)", protocol, eval.LEAVE_ONE_SESSION_OUT, eval.K_FOLD)
+	}
+	usable := 0
+	for _, s := range splits {
+		if len(s.Train) > 0 && len(s.Test) > 0 {
+			usable++
+		}
+	}
+	if usable == 0 {
+		log.Fatalf("No split of %d with both training and test captures (%s), more sessions or captures needed", len(splits), protocol)
+	}
+
+	newModel := func() (ident.Model, error) {
+		return ident.NewK(kind, k)
+	}
+	_, err = newModel()
+	checkError(err)
+
+	foldResults, predictions, err := eval.Run(newModel, examples, splits)
+	checkError(err)
+	result := eval.Summarise(protocol, kind, foldResults, predictions)
+	for _, f := range result.Folds {
+		if tested := f.Test - f.Unseen; tested > 0 {
+			log.Printf("%s: %d/%d identified (%d unseen subjects)", f.Name, f.Correct, tested, f.Unseen)
+		} else {
+			log.Printf("%s: no capture of a trained subject", f.Name)
+		}
+	}
+	log.Printf("Accuracy %.3f over %d captures (%d unseen), EER %.3f at %.3f", result.Accuracy, result.Tested, result.Unseen, result.EER, result.EERScore)
+	for _, rk := range []int{1, 3, 5} {
+		if rk <= len(result.RankRates) {
+			fmt.Printf("rank-%d\t%.3f\n", rk, result.RankRates[rk-1])
+		}
+	}
+
+	if dir := filepath.Dir(output); dir != "." {
+		checkError(os.MkdirAll(dir, 0777))
+	}
+	files, err := result.Save(output)
+	checkError(err)
+	log.Printf("Results written to %v", files)
+}
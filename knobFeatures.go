@@ -0,0 +1,97 @@
+// knobFeatures exports the feature matrix of the dataset: a row for every
+// acquisition with its file, session, subject, repetition and configuration
+// followed by its features (see the features package), semicolon separated
+// like the acquisition files.
+//
+//	go run knobFeatures.go -o features.csv
+//	go run knobFeatures.go -manifest dataset/manifest.json -session 170131 -o 170131.csv
+
+package main
+
+import (
+	"./dataset"
+	"./features"
+	"./knobcsv"
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func checkError(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	var (
+		dataDir  string
+		manifest string
+		output   string
+		subject  string
+		session  string
+		conf     string
+	)
+	flag.StringVar(&dataDir, "data", "data", "Root folder of the acquisition files")
+	flag.StringVar(&manifest, "manifest", "", "Manifest of the files (knobIndex), the data folder is indexed if empty")
+	flag.StringVar(&output, "o", "", "Feature matrix file, standard output if empty")
+	flag.StringVar(&subject, "subject", "", "Only the files of a subject (i001)")
+	flag.StringVar(&session, "session", "", "Only the files of a session folder (170131)")
+	flag.StringVar(&conf, "conf", "", "Only the files of a configuration (a8w250)")
+	flag.Parse()
+
+	var m dataset.Manifest
+	var err error
+	if manifest != "" {
+		m, err = dataset.Load(manifest)
+	} else {
+		m, err = dataset.Index(dataDir)
+	}
+	checkError(err)
+	m = m.Filter(subject, session, conf)
+
+	out := os.Stdout
+	if output != "" {
+		out, err = os.Create(output)
+		checkError(err)
+	}
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "path;session;subject;rep;conf;capture;%s\n", strings.Join(features.Names(), ";"))
+
+	rows, skipped := 0, 0
+	for _, e := range m {
+		acqs, err := knobcsv.ReadFile(filepath.Join(dataDir, filepath.FromSlash(e.Path)))
+		if err != nil {
+			log.Printf("Error reading %s: %v", e.Path, err)
+			skipped++
+			continue
+		}
+		for i, a := range acqs {
+			x, err := features.Extract(a.Samples)
+			if err != nil {
+				log.Printf("Error in %s (%d): %v", e.Path, i, err)
+				skipped++
+				continue
+			}
+			fmt.Fprintf(w, "%s;%s;%s;%d;%s;%d", e.Path, e.Session, e.Subject, e.Rep, e.Conf, i)
+			for _, v := range x {
+				w.WriteString(";" + strconv.FormatFloat(v, 'g', 8, 64))
+			}
+			w.WriteString("\n")
+			rows++
+		}
+	}
+	checkError(w.Flush())
+	if output != "" {
+		checkError(out.Close())
+		log.Printf("%d rows of %d features written to %s", rows, len(features.Names()), output)
+	}
+	if skipped > 0 {
+		log.Printf("%d files or acquisitions skipped", skipped)
+	}
+}
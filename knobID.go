@@ -5,476 +5,659 @@
 package main
 
 import (
+	"./acquisition"
+	"./ak8963"
+	"./calibration"
+	"./enroll"
 	"./gpio"
 	"./i2c"
+	"./ident"
+	"./knobbin"
+	"./knobcsv"
+	"./metadata"
+	"./mpr121"
+	"./mpu9250"
+	"./presence"
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"math"
+	"math/rand"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
-// GPIO section =================
-// GPIO section =================
-// GPIO section =================
-
-func readIR(ir *gpio.Pin, led *gpio.Pin, presence chan<- bool) {
-
-	var valueOld = ir.GetStatus() //at beginning
-	var valueNow gpio.Value
-	//var err error
-	//make the led match the ir
-	led.Write(ir.GetStatus())
-	for {
-		valueNow, _ = ir.Read()
-		presence <- (valueNow == gpio.HIGH)
-		if valueNow != valueOld {
-			valueOld = valueNow
-			_, _ = led.Toggle()
-		}
-	}
-}
-
 // MPR section ==================
 // MPR section ==================
 // MPR section ==================
-// https://github.com/adafruit/Adafruit_MPR121/blob/master/Adafruit_MPR121.cpp
 
-const (
-	// The default I2C address
-	MPR121_I2CADDR_DEFAULT = 0x5A
-
-	MPR121_TOUCHSTATUS_L = 0x00
-	MPR121_TOUCHSTATUS_H = 0x01
-	MPR121_FILTDATA_0L   = 0x04
-	MPR121_FILTDATA_0H   = 0x05
-	MPR121_BASELINE_0    = 0x1E
-	MPR121_MHDR          = 0x2B
-	MPR121_NHDR          = 0x2C
-	MPR121_NCLR          = 0x2D
-	MPR121_FDLR          = 0x2E
-	MPR121_MHDF          = 0x2F
-	MPR121_NHDF          = 0x30
-	MPR121_NCLF          = 0x31
-	MPR121_FDLF          = 0x32
-	MPR121_NHDT          = 0x33
-	MPR121_NCLT          = 0x34
-	MPR121_FDLT          = 0x35
-
-	MPR121_TOUCHTH_0    = 0x41
-	MPR121_RELEASETH_0  = 0x42
-	MPR121_DEBOUNCE     = 0x5B
-	MPR121_CONFIG1      = 0x5C
-	MPR121_CONFIG2      = 0x5D
-	MPR121_CHARGECURR_0 = 0x5F
-	MPR121_CHARGETIME_1 = 0x6C
-	MPR121_ECR          = 0x5E
-	MPR121_AUTOCONFIG0  = 0x7B
-	MPR121_AUTOCONFIG1  = 0x7C
-	MPR121_UPLIMIT      = 0x7D
-	MPR121_LOWLIMIT     = 0x7E
-	MPR121_TARGETLIMIT  = 0x7F
-
-	MPR121_GPIODIR    = 0x76
-	MPR121_GPIOEN     = 0x77
-	MPR121_GPIOSET    = 0x78
-	MPR121_GPIOCLR    = 0x79
-	MPR121_GPIOTOGGLE = 0x7A
-
-	MPR121_SOFTRESET = 0x80
-)
+// Supply voltage of the MPR121, for the auto-configuration limits
+const MPR121_VDD = 3.3
 
-type MPR121 struct {
-	i2c *i2c.I2C
+// parseElectrodes parses a comma separated list of electrodes, or "all".
+func parseElectrodes(s string) ([]int, error) {
+	if s == "all" {
+		s = "0,1,2,3,4,5,6,7,8,9,10,11"
+	}
+	var electrodes []int
+	for _, f := range strings.Split(s, ",") {
+		ele, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || ele < 0 || ele >= mpr121.NUM_ELECTRODES {
+			return nil, fmt.Errorf("invalid electrode %q", f)
+		}
+		electrodes = append(electrodes, ele)
+	}
+	return electrodes, nil
 }
 
-func NewMPR121(bus int) (*MPR121, error) {
-	thei2c, err := i2c.NewI2C(MPR121_I2CADDR_DEFAULT, bus)
-	if err != nil {
-		return nil, err
+// parseThresholds parses touch/release thresholds, either one pair for all
+// the electrodes or a comma separated pair per electrode.
+func parseThresholds(s string, n int) ([][2]byte, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 1 && len(fields) != n {
+		return nil, fmt.Errorf("%d thresholds for %d electrodes", len(fields), n)
+	}
+	th := make([][2]byte, n)
+	for i := range th {
+		f := fields[0]
+		if len(fields) == n {
+			f = fields[i]
+		}
+		var touch, release byte
+		if _, err := fmt.Sscanf(strings.TrimSpace(f), "%d/%d", &touch, &release); err != nil {
+			return nil, fmt.Errorf("invalid thresholds %q, touch/release expected", f)
+		}
+		th[i] = [2]byte{touch, release}
 	}
-	theMPR := MPR121{i2c: thei2c}
-	return &theMPR, nil
+	return th, nil
 }
 
-func (mpr *MPR121) Config() error {
+// configMPR configures the thresholds of the electrodes, and their charge
+// with the auto-configuration if autoCfg, and starts them.
+func configMPR(mpr *mpr121.MPR121, electrodes []int, th [][2]byte, autoCfg bool) error {
 	log.Printf("Configurating MPR121\n")
 
-	// soft reset
-	e := mpr.i2c.WriteRegU8(MPR121_SOFTRESET, 0x63)
-	if e != nil {
-		return e
+	if err := mpr.Reset(); err != nil {
+		return err
 	}
-	time.Sleep(10 * time.Millisecond)
-
-	// set default electrode configuration
-	e = mpr.i2c.WriteRegU8(MPR121_ECR, 0x0)
-	if e != nil {
-		return e
+	if err := mpr.SetDefaultFilter(); err != nil {
+		return err
 	}
-
-	// setThresholds for Electrode 0 to 12 (touch) and 6 (release)
-	e = mpr.i2c.WriteRegU8(MPR121_TOUCHTH_0, 12)
-	if e != nil {
-		return e
+	var mask uint16
+	for i, ele := range electrodes {
+		if err := mpr.SetThresholds(ele, th[i][0], th[i][1]); err != nil {
+			return err
+		}
+		mask |= 1 << uint(ele)
 	}
-	e = mpr.i2c.WriteRegU8(MPR121_RELEASETH_0, 6)
-	if e != nil {
-		return e
+	if autoCfg {
+		if err := mpr.EnableAutoConfig(MPR121_VDD); err != nil {
+			return err
+		}
 	}
-	_ = mpr.i2c.WriteRegU8(MPR121_MHDR, 0x01)
-	_ = mpr.i2c.WriteRegU8(MPR121_NHDR, 0x01)
-	_ = mpr.i2c.WriteRegU8(MPR121_NCLR, 0x0E)
-	_ = mpr.i2c.WriteRegU8(MPR121_FDLR, 0x00)
-
-	_ = mpr.i2c.WriteRegU8(MPR121_MHDF, 0x01)
-	_ = mpr.i2c.WriteRegU8(MPR121_NHDF, 0x05)
-	_ = mpr.i2c.WriteRegU8(MPR121_NCLF, 0x01)
-	_ = mpr.i2c.WriteRegU8(MPR121_FDLF, 0x00)
-
-	_ = mpr.i2c.WriteRegU8(MPR121_NHDT, 0x00)
-	_ = mpr.i2c.WriteRegU8(MPR121_NCLT, 0x00)
-	_ = mpr.i2c.WriteRegU8(MPR121_FDLT, 0x00)
-
-	_ = mpr.i2c.WriteRegU8(MPR121_DEBOUNCE, 0)
-	_ = mpr.i2c.WriteRegU8(MPR121_CONFIG1, 0x10) // default, 16uA charge current
-	_ = mpr.i2c.WriteRegU8(MPR121_CONFIG2, 0x20) // 0.5uS encoding, 1ms period
-
-	//  _ = mpr.i2c.WriteRegU8(MPR121_AUTOCONFIG0, 0x8F);
-
-	//  _ = mpr.i2c.WriteRegU8(MPR121_UPLIMIT, 150);
-	//  _ = mpr.i2c.WriteRegU8(MPR121_TARGETLIMIT, 100); // should be ~400 (100 shifted)
-	//  _ = mpr.i2c.WriteRegU8(MPR121_LOWLIMIT, 50);
-	// enable only electrode 0 (all electrodes 0x8F)
-	e = mpr.i2c.WriteRegU8(MPR121_ECR, 0x81) // start with first 5 bits of baseline tracking
-	if e != nil {
-		return e
+	if err := mpr.Start(mask); err != nil {
+		return err
+	}
+	if autoCfg {
+		time.Sleep(100 * time.Millisecond) //let the search end
+		if err := mpr.AutoConfigStatus(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		for _, ele := range electrodes {
+			ua, t, err := mpr.ReadCharge(ele)
+			if err != nil {
+				return err
+			}
+			log.Printf("Electrode %d charge: %d uA, %v", ele, ua, t)
+		}
 	}
-
 	return nil
 }
 
-func (mpr *MPR121) Touched() int {
-	touchStatus, _ := mpr.i2c.ReadRegU8(MPR121_TOUCHSTATUS_L)
+// MPU section ==================
+// MPU section ==================
+// MPU section ==================
 
-	//Only ELE0
-	return int(touchStatus & 0x01)
+type TimAccGyr struct {
+	Tim time.Time
+	Acc mpu9250.ThreeDData //X, Y, Z  int16
+	Gyr mpu9250.ThreeDData //X, Y, Z  int16
+	Tmp int16              //die temperature counts
+	Mag ak8963.Sample      //X, Y, Z  counts, in the AK8963 axes
+	Cap CapData            //MPR121 electrodes
 }
 
-const (
-	RELEASED = iota // channel released
-	TOUCHED         //channel touched
-)
-
-func readCap(mpr *MPR121, led *gpio.Pin, presence chan<- bool) {
+// Raw capacitive data of the MPR121 electrodes, 10 bits counts. A touch
+// makes the filtered data drop below the baseline.
+type CapData struct {
+	Filt [mpr121.NUM_ELECTRODES]uint16
+	Base [mpr121.NUM_ELECTRODES]uint16
+}
 
-	var valueOld = mpr.Touched() //at beginning
-	var valueNow int
-	//var err error
-	//make the led match the ir
-	led.Write(gpio.Value(mpr.Touched()))
-	for {
-		valueNow = mpr.Touched()
-		presence <- (valueNow == TOUCHED)
-		if valueNow != valueOld {
-			valueOld = valueNow
-			_, _ = led.Toggle()
-		}
+// readCap reads the filtered data and baseline of the electrodes
+func readCap(mpr *mpr121.MPR121) (CapData, error) {
+	filt, err := mpr.ReadFilteredData()
+	if err != nil {
+		return CapData{}, err
 	}
+	base, err := mpr.ReadBaseline()
+	if err != nil {
+		return CapData{}, err
+	}
+	return CapData{Filt: filt, Base: base}, nil
 }
 
-// ConfigAcc Config the accelerometer Full Scale
+const (
+	PRE_DATA_CAP       int    = 1000 //PRE_DATA_CAP maximum margin of prefechted Data
+	DATAFILE_EXTENSION string = ".csv"
+	WRITER_QUEUE       int    = 8 //captures waiting to be written
+)
 
-// MPU section ==================
-// MPU section ==================
-// MPU section ==================
+// VERSION of knobID, recorded in the metadata. Set it when building with
+// -ldflags "-X main.VERSION=..."
+var VERSION = "dev"
 
+// Windows of samples the gyroscope bias is estimated on, at startup and
+// while idle, and the windows tried at startup before giving up.
 const (
-	// based on mrmorphic/hwio/gy520.go
-	// This is the default address. Some devices may also respond to 0x69
-	MPU9250_DEVICE_ADDRESS = 0x68
-
-	MPU9250_REG_CONFIG         = 0x1a
-	MPU9250_REG_GYRO_CONFIG    = 0x1b
-	MPU9250_REG_ACCEL_CONFIG   = 0x1c
-	MPU9250_REG_ACCEL_CONFIG_2 = 0x1d
-
-	// accelerometer sensor registers, read-only
-	MPU9250_REG_ACCEL_XOUT_H = 0x3b
-	MPU9250_REG_ACCEL_XOUT_L = 0x3c
-	MPU9250_REG_ACCEL_YOUT_H = 0x3d
-	MPU9250_REG_ACCEL_YOUT_L = 0x3e
-	MPU9250_REG_ACCEL_ZOUT_H = 0x3f
-	MPU9250_REG_ACCEL_ZOUT_L = 0x40
-
-	// temperature sensor registers, read-only
-	MPU9250_REG_TEMP_OUT_H = 0x41
-	MPU9250_REG_TEMP_OUT_L = 0x42
-
-	// gyroscope sensor registers, read-only
-	MPU9250_REG_GYRO_XOUT_H = 0x43
-	MPU9250_REG_GYRO_XOUT_L = 0x44
-	MPU9250_REG_GYRO_YOUT_H = 0x45
-	MPU9250_REG_GYRO_YOUT_L = 0x46
-	MPU9250_REG_GYRO_ZOUT_H = 0x47
-	MPU9250_REG_GYRO_ZOUT_L = 0x48
-
-	MPU9250_REG_PWR_MGMT_1 = 0x6b
-
-	MPU9250_PARAM_SLEEP = 0x40
-
-	MPU9250_PARAM_ACCEL_FS_2G     = 0x00
-	MPU9250_PARAM_ACCEL_FS_4G     = 0x08
-	MPU9250_PARAM_ACCEL_FS_8G     = 0x10
-	MPU9250_PARAM_ACCEL_FS_16G    = 0x18
-	MPU9250_PARAM_ACCEL_FCHOICE_b = 0x08
-
-	MPU9250_SENSITIVITY_ACCEL_SF_FS_2G  = 16384.0
-	MPU9250_SENSITIVITY_ACCEL_SF_FS_4G  = 8192.0
-	MPU9250_SENSITIVITY_ACCEL_SF_FS_8G  = 4096.0
-	MPU9250_SENSITIVITY_ACCEL_SF_FS_16G = 2048.0
-
-	MPU9250_PARAM_GYRO_FS_250  = 0x00
-	MPU9250_PARAM_GYRO_FS_500  = 0x08
-	MPU9250_PARAM_GYRO_FS_1000 = 0x10
-	MPU9250_PARAM_GYRO_FS_2000 = 0x18
-
-	MPU9250_SENSITIVITY_GYRO_SF_FS_250  = 131.0
-	MPU9250_SENSITIVITY_GYRO_SF_FS_500  = 65.5
-	MPU9250_SENSITIVITY_GYRO_SF_FS_1000 = 32.8
-	MPU9250_SENSITIVITY_GYRO_SF_FS_2000 = 16.4
-
-	MPU9250_REG_CLOCK_PLL_XGYRO   = 0x01
-	MPU9250_REG_SMPLRT_DIV_CONFIG = 0x19
-	MPU9250_REG_SMPLRT_DIV        = 0x07
+	GYRO_BIAS_WINDOW   = 1 * time.Second
+	GYRO_STARTUP_TRIES = 5
 )
 
-type ThreeDData struct {
-	X int16
-	Y int16
-	Z int16
+// subtractBias removes a bias from counts, saturating at the int16 range.
+func subtractBias(v mpu9250.ThreeDData, bias [3]int16) mpu9250.ThreeDData {
+	sub := func(c, b int16) int16 {
+		return int16(max(math.MinInt16, min(math.MaxInt16, int32(c)-int32(b))))
+	}
+	return mpu9250.ThreeDData{X: sub(v.X, bias[0]), Y: sub(v.Y, bias[1]), Z: sub(v.Z, bias[2])}
 }
 
-type TimAccGyr struct {
-	Tim time.Time
-	Acc ThreeDData //X, Y, Z  int16
-	Gyr ThreeDData //X, Y, Z  int16
+// readSample reads the acc and gyro data in one step without err consideration
+func readSample(mpu *mpu9250.MPU9250) TimAccGyr {
+	s, _ := mpu.ReadSample()
+	return TimAccGyr{Tim: time.Now(), Acc: s.Acc, Gyr: s.Gyr, Tmp: s.Temp}
 }
 
-const (
-	DATA_CAP           int    = 1000 //DATA_CAP initial capacity of slice Data
-	PRE_DATA_CAP       int    = 1000 //PRE_DATA_CAP initial capacity of circular array of prefechted Data
-	DATAFILE_EXTENSION string = ".csv"
-)
+// Period to drain the MPU FIFO, it must be emptied before it fills up
+// (FIFO_SIZE/FIFO_FRAME_SIZE = 42 samples, 84 ms at 500 Hz).
+const FIFO_POLL = 10 * time.Millisecond
+
+// readFIFO drains the MPU FIFO, the samples are evenly spaced in time.
+func readFIFO(stream *mpu9250.FIFOStream) []TimAccGyr {
+	samples, err := stream.Read()
+	if err == mpu9250.ErrFIFOOverflow {
+		log.Printf("Warning: %v, %d overflows so far", err, stream.Overflows)
+	} else if err != nil {
+		log.Printf("Error reading the FIFO: %v", err)
+	}
+	data := make([]TimAccGyr, len(samples))
+	for i, s := range samples {
+		data[i] = TimAccGyr{Tim: s.Tim, Acc: s.Acc, Gyr: s.Gyr}
+	}
+	return data
+}
 
-type MPU9250 struct {
-	i2c      *i2c.I2C
-	accel_fs int
-	gyro_fs  int
+// knobSource is the acquisition source of the knob: the MPU samples, read
+// directly or through its FIFO, along with the last magnetometer and
+// electrode readings. A failed electrode read keeps the previous values,
+// the MPU samples are never dropped for it.
+type knobSource struct {
+	mpu       *mpu9250.MPU9250
+	stream    *mpu9250.FIFOStream //nil to read the MPU directly
+	mag       *Magnetometer       //nil without magnetometer
+	mpr       *mpr121.MPR121      //nil without electrode recording
+	cap       CapData             //last electrode values read
+	CapErrors int                 //failed electrode reads
 }
 
-func NewMPU9250(bus int, a_fs int, g_fs int) (*MPU9250, error) {
-	thei2c, err := i2c.NewI2C(MPU9250_DEVICE_ADDRESS, bus)
-	if err != nil {
-		return nil, err
+func (src *knobSource) Read() ([]TimAccGyr, error) {
+	var newData []TimAccGyr
+	if src.stream != nil {
+		newData = readFIFO(src.stream)
+		//the FIFO frames have no temperature, it changes slowly
+		if temp, err := src.mpu.GetTemp(); err == nil {
+			for i := range newData {
+				newData[i].Tmp = temp
+			}
+		}
+	} else {
+		newData = []TimAccGyr{readSample(src.mpu)}
 	}
-	theMPU := MPU9250{i2c: thei2c, accel_fs: a_fs, gyro_fs: g_fs}
-	return &theMPU, nil
-}
-
-// ConfigAcc Config the accelerometer Full Scale
-func (mpu *MPU9250) Config() error {
-	log.Printf("Configuration parameters:")
-	// config accel
-	v, e := mpu.i2c.ReadRegU8(MPU9250_REG_ACCEL_CONFIG)
-	if e != nil {
-		return e
-	}
-
-	v |= byte(MPU9250_PARAM_ACCEL_FS_16G) //has bits 4:3 set to 1
-	switch mpu.accel_fs {
-	case 2:
-		v &= byte(MPU9250_PARAM_ACCEL_FS_2G)
-	case 4:
-		v &= byte(MPU9250_PARAM_ACCEL_FS_4G)
-	case 8:
-		v &= byte(MPU9250_PARAM_ACCEL_FS_8G)
-	case 16:
-		v &= byte(MPU9250_PARAM_ACCEL_FS_16G)
-	default:
-		v &= byte(MPU9250_PARAM_ACCEL_FS_2G)
+	if src.mag != nil {
+		counts := src.mag.Read()
+		for i := range newData {
+			newData[i].Mag = counts
+		}
 	}
-	log.Printf("\tACCEL_FS: %#x", byte(v))
-
-	e = mpu.i2c.WriteRegU8(MPU9250_REG_ACCEL_CONFIG, v)
-	if e != nil {
-		return e
+	if src.mpr != nil {
+		capData, err := readCap(src.mpr)
+		if err == nil {
+			src.cap = capData
+		} else {
+			if src.CapErrors == 0 {
+				log.Printf("Error reading the electrodes: %v", err)
+			}
+			src.CapErrors++
+		}
+		for i := range newData {
+			newData[i].Cap = src.cap
+		}
 	}
+	return newData, nil
+}
 
-	time.Sleep(10 * time.Millisecond)
+// Magnetometer section =========
+// Magnetometer section =========
+// Magnetometer section =========
+
+// Magnetometer reads the AK8963 inside the MPU9250, either directly on the
+// i2c bus with the MPU in bypass mode or through the MPU i2c master, which
+// reads it on every MPU sample into EXT_SENS_DATA.
+type Magnetometer struct {
+	ak        *ak8963.AK8963
+	mpu       *mpu9250.MPU9250 //nil in bypass mode
+	buf       []byte
+	last      ak8963.Sample
+	Overflows int
+}
 
-	//Setup acc data rates to maximun avoiding DLPF
-	v, e = mpu.i2c.ReadRegU8(MPU9250_REG_ACCEL_CONFIG_2)
-	if e != nil {
-		return e
+// NewMagnetometer configures the AK8963 in continuous mode, access is
+// "bypass" or "master".
+func NewMagnetometer(mpu *mpu9250.MPU9250, open i2c.Opener, bus int, access string, mode ak8963.Mode) (*Magnetometer, error) {
+	mag := &Magnetometer{buf: make([]byte, ak8963.DATA_SIZE)}
+	var err error
+	switch access {
+	case "bypass":
+		if err = mpu.SetBypass(true); err != nil {
+			return nil, err
+		}
+		mag.ak, err = ak8963.Open(open, bus)
+	case "master":
+		if err = mpu.EnableMaster(); err != nil {
+			return nil, err
+		}
+		mag.ak, err = ak8963.Open(mpu.AuxOpener(), bus)
+		mag.mpu = mpu
+	default:
+		return nil, fmt.Errorf("invalid magnetometer access %q", access)
 	}
-
-	//log.Printf("v before: %b", v)
-	v |= byte(MPU9250_PARAM_ACCEL_FCHOICE_b) //has bits 4 set to 1
-	log.Printf("\tACCEL_FCHOICE_B: %#x", byte(v))
-	//log.Printf("FCHOICE_B %b", byte(0x00))
-	//v = byte(0x00) //has bits 4 set to 1
-	//log.Printf("v after: %b", v)
-
-	e = mpu.i2c.WriteRegU8(MPU9250_REG_ACCEL_CONFIG_2, v)
-	if e != nil {
-		return e
+	if err != nil {
+		return nil, err
 	}
-
-	time.Sleep(10 * time.Millisecond)
-
-	// config gyro
-
-	v, e = mpu.i2c.ReadRegU8(MPU9250_REG_GYRO_CONFIG)
-	if e != nil {
-		return e
+	if err := mag.ak.Verify(); err != nil {
+		log.Printf("Warning: %v", err)
 	}
-
-	v |= byte(MPU9250_PARAM_GYRO_FS_2000) //has bits 4:3 set to 1
-	switch mpu.gyro_fs {
-	case 250:
-		v &= byte(MPU9250_PARAM_GYRO_FS_250)
-	case 500:
-		v &= byte(MPU9250_PARAM_GYRO_FS_500)
-	case 1000:
-		v &= byte(MPU9250_PARAM_GYRO_FS_1000)
-	case 2000:
-		v &= byte(MPU9250_PARAM_GYRO_FS_2000)
-	default:
-		v &= byte(MPU9250_PARAM_GYRO_FS_250)
+	if err := mag.ak.Reset(); err != nil {
+		return nil, err
 	}
+	asa, err := mag.ak.ReadAdjustment()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Magnetometer sensitivity adjustment: %.3f %.3f %.3f", asa[0], asa[1], asa[2])
+	if err := mag.ak.SetMode(mode); err != nil {
+		return nil, err
+	}
+	if mag.mpu != nil {
+		if err := mpu.StartAuxRead(ak8963.DEVICE_ADDRESS, ak8963.REG_ST1, ak8963.DATA_SIZE); err != nil {
+			return nil, err
+		}
+	}
+	return mag, nil
+}
 
-	log.Printf("\tGYRO_FS: %#x", byte(v))
-	e = mpu.i2c.WriteRegU8(MPU9250_REG_GYRO_CONFIG, v)
-	if e != nil {
-		return e
+// Read returns the counts of the last field measured, the AK8963 runs
+// slower than the MPU.
+func (mag *Magnetometer) Read() ak8963.Sample {
+	var s ak8963.Sample
+	var err error
+	if mag.mpu != nil {
+		if err = mag.mpu.ReadExtSensData(mag.buf); err == nil {
+			s, err = ak8963.Decode(mag.buf)
+		}
+	} else {
+		s, err = mag.ak.ReadSample()
 	}
+	switch {
+	case err == ak8963.ErrOverflow:
+		mag.Overflows++
+	case err != nil:
+		log.Printf("Error reading the magnetometer: %v", err)
+	case s.Ready:
+		mag.last = s
+	}
+	return mag.last
+}
 
-	time.Sleep(10 * time.Millisecond)
+// Field converts counts to uT in the acc and gyro axes.
+func (mag *Magnetometer) Field(s ak8963.Sample) ak8963.Field {
+	return mag.ak.MicroTesla(s).Aligned()
+}
 
-	return nil
+// Channels of the counts in the binary files, in the acc and gyro axes:
+// the AK8963 Y, X and -Z axes, the sensitivity adjustment in the scales.
+func (mag *Magnetometer) Channels() []knobbin.Channel {
+	asa := mag.ak.Adjustment()
+	return []knobbin.Channel{
+		{Name: "magX(uT)", Scale: asa[1] * ak8963.SENSITIVITY},
+		{Name: "magY(uT)", Scale: asa[0] * ak8963.SENSITIVITY},
+		{Name: "magZ(uT)", Scale: -asa[2] * ak8963.SENSITIVITY},
+	}
 }
 
-// Wake the device. By default on power on, the device is asleep.
-// based on mrmorphic/hwio/gy520.go
-func (mpu *MPU9250) Wake() error {
-	v, e := mpu.i2c.ReadRegU8(MPU9250_REG_PWR_MGMT_1)
-	if e != nil {
-		return e
+// Stop powers the AK8963 down.
+func (mag *Magnetometer) Stop() error {
+	if mag.mpu != nil {
+		if err := mag.mpu.StopAuxRead(); err != nil {
+			return err
+		}
 	}
+	return mag.ak.SetMode(ak8963.MODE_POWER_DOWN)
+}
 
-	v &= ^byte(MPU9250_PARAM_SLEEP)
+// LED section ==================
+// LED section ==================
+// LED section ==================
 
-	e = mpu.i2c.WriteRegU8(MPU9250_REG_PWR_MGMT_1, v)
-	if e != nil {
-		return e
-	}
+// ledStep holds the LED at a value for a while.
+type ledStep struct {
+	Value gpio.Value
+	For   time.Duration
+}
 
-	return nil
+// lit is the feedback of the LED on for d.
+func lit(d time.Duration) []ledStep {
+	return []ledStep{{gpio.HIGH, d}, {gpio.LOW, 0}}
 }
 
-// Put the device back to sleep.
-// based on mrmorphic/hwio/gy520.go
-func (mpu *MPU9250) Sleep() error {
-	v, e := mpu.i2c.ReadRegU8(MPU9250_REG_PWR_MGMT_1)
-	if e != nil {
-		return e
+// blinks is the feedback of the LED flashing n times, it is left off.
+func blinks(n int) []ledStep {
+	var steps []ledStep
+	for i := 0; i < n; i++ {
+		steps = append(steps, ledStep{gpio.HIGH, BLINK_PERIOD}, ledStep{gpio.LOW, BLINK_PERIOD})
 	}
+	return steps
+}
 
-	v |= ^byte(MPU9250_PARAM_SLEEP)
-
-	e = mpu.i2c.WriteRegU8(MPU9250_REG_PWR_MGMT_1, v)
-	if e != nil {
-		return e
-	}
+// ledCmd switches the capture indicator, or shows a feedback when steps is
+// not nil.
+type ledCmd struct {
+	capturing bool
+	steps     []ledStep
+}
 
-	return nil
+// ledOwner drives the LED from one goroutine, in the order of the commands
+// of the acquisition and the writer goroutines: the capture indicator, on
+// while the knob is touched, and the feedbacks of the enrollment and the
+// identification. The indicator interrupts a feedback, and a feedback
+// waits for the end of the previous one, none is shown while capturing.
+type ledOwner struct {
+	led  gpio.Pin
+	cmds chan ledCmd
+	done chan struct{}
 }
 
-func (mpu *MPU9250) GetData() (accelX int, accelY int, accelZ int, gyroX int, gyroY int, gyroZ int) {
-	// based on mrmorphic/hwio/gy520.go
+// Feedbacks waiting for the LED, beyond them they are dropped.
+const LED_QUEUE = 8
 
-	theAccelX, _ := mpu.i2c.ReadRegU16BE(MPU9250_REG_ACCEL_XOUT_H)
-	theAccelY, _ := mpu.i2c.ReadRegU16BE(MPU9250_REG_ACCEL_YOUT_H)
-	theAccelZ, _ := mpu.i2c.ReadRegU16BE(MPU9250_REG_ACCEL_ZOUT_H)
-	theGyroX, _ := mpu.i2c.ReadRegU16BE(MPU9250_REG_GYRO_XOUT_H)
-	theGyroY, _ := mpu.i2c.ReadRegU16BE(MPU9250_REG_GYRO_YOUT_H)
-	theGyroZ, _ := mpu.i2c.ReadRegU16BE(MPU9250_REG_GYRO_ZOUT_H)
+func newLEDOwner(led gpio.Pin) *ledOwner {
+	o := &ledOwner{led: led, cmds: make(chan ledCmd, LED_QUEUE), done: make(chan struct{})}
+	go o.run()
+	return o
+}
 
-	return int(theAccelX), int(theAccelY), int(theAccelZ), int(theGyroX), int(theGyroY), int(theGyroZ)
+// Capture switches the capture indicator.
+func (o *ledOwner) Capture(on bool) {
+	o.cmds <- ledCmd{capturing: on}
 }
 
-func (mpu *MPU9250) GetAllData() (accelX int, accelY int, accelZ int, gyroX int, gyroY int, gyroZ int) {
-	// based on mrmorphic/hwio/gy520.go
-	//read the acc and gyro data in one step
+// Show queues a feedback, dropped if LED_QUEUE are already waiting.
+func (o *ledOwner) Show(steps []ledStep) {
+	select {
+	case o.cmds <- ledCmd{steps: steps}:
+	default:
+	}
+}
 
-	_, err := mpu.i2c.Write([]byte{MPU9250_REG_ACCEL_XOUT_H})
-	if err != nil {
-		return 0, 0, 0, 0, 0, 0 //error!
+// Close waits for the feedbacks queued and leaves the LED off.
+func (o *ledOwner) Close() {
+	close(o.cmds)
+	<-o.done
+}
+
+func (o *ledOwner) run() {
+	defer close(o.done)
+	defer o.led.Write(gpio.LOW)
+	capturing := false
+	var steps []ledStep       //of the feedback shown, the current one first
+	var queued [][]ledStep    //feedbacks waiting for the current one
+	var next <-chan time.Time //end of the current step
+	cmds := o.cmds
+	show := func() {
+		for len(steps) == 0 && len(queued) > 0 {
+			steps, queued = queued[0], queued[1:]
+		}
+		if len(steps) == 0 {
+			next = nil
+			return
+		}
+		o.led.Write(steps[0].Value)
+		next = time.After(steps[0].For)
 	}
-	buf := make([]byte, 14) //to store 14 bytes
-	_, err = mpu.i2c.Read(buf)
-	if err != nil {
-		return 0, 0, 0, 0, 0, 0 //error!
+	for cmds != nil || next != nil {
+		select {
+		case cmd, ok := <-cmds:
+			switch {
+			case !ok:
+				cmds = nil
+			case cmd.steps == nil:
+				capturing, steps, queued, next = cmd.capturing, nil, nil, nil
+				if capturing {
+					o.led.Write(gpio.HIGH)
+				} else {
+					o.led.Write(gpio.LOW)
+				}
+			case capturing:
+				//the feedback of a previous capture, the indicator wins
+			case next != nil:
+				if len(queued) < LED_QUEUE {
+					queued = append(queued, cmd.steps)
+				}
+			default:
+				steps = cmd.steps
+				show()
+			}
+		case <-next:
+			steps = steps[1:]
+			show()
+		}
 	}
-	ax := int(uint16(buf[0]<<8) + uint16(buf[1]))
-	ay := int(uint16(buf[2]<<8) + uint16(buf[3]))
-	az := int(uint16(buf[4]<<8) + uint16(buf[5]))
-	//temp  := int(uint16(buf[6]<<8) + uint16(buf[7]))
-	gx := int(uint16(buf[8]<<8) + uint16(buf[9]))
-	gy := int(uint16(buf[10]<<8) + uint16(buf[11]))
-	gz := int(uint16(buf[12]<<8) + uint16(buf[13]))
+}
+
+// Enrollment section ===========
+// Enrollment section ===========
+// Enrollment section ===========
 
-	return int(ax), int(ay), int(az), int(gx), int(gy), int(gz)
+// Blinks of the LED after a repetition of an enrollment, and once all are
+// taken
+const (
+	ENROLL_NEXT_BLINKS = 2
+	ENROLL_DONE_BLINKS = 5
+	BLINK_PERIOD       = 150 * time.Millisecond
+)
+
+// Identification section =======
+// Identification section =======
+// Identification section =======
+
+// LED feedback of the identification: on for IDENT_LED_ON when the subject
+// is identified, IDENT_UNKNOWN_BLINKS blinks when unknown
+const (
+	IDENT_LED_ON         = 1 * time.Second
+	IDENT_UNKNOWN_BLINKS = 3
+	IDENT_TOP            = 3 //matches reported in the events
+)
+
+// IdentEvent is emitted, as a JSON line, for every capture identified.
+type IdentEvent struct {
+	Time    time.Time     `json:"time"`
+	Name    string        `json:"name"`
+	Num     int           `json:"num"`
+	Subject string        `json:"subject"` // or unknown
+	Score   float64       `json:"score"`   // of the best ranked subject
+	Matches []ident.Match `json:"matches"`
+	Error   string        `json:"error,omitempty"` // the capture could not be written
 }
 
-func (mpu *MPU9250) GetAccel() (accelX int, accelY int, accelZ int, e error) {
-	// based on mrmorphic/hwio/gy520.go
+// captureSamples converts a capture to g and o/s, as in the data files, the
+// times from its first sample.
+func captureSamples(c *acquisition.Capture[TimAccGyr], accG, gyrDPS func(TimAccGyr) [3]float64) []knobcsv.Sample {
+	samples := make([]knobcsv.Sample, 0, c.Len())
+	var first time.Time
+	add := func(data []TimAccGyr, present bool) {
+		for _, value := range data {
+			if len(samples) == 0 {
+				first = value.Tim
+			}
+			samples = append(samples, knobcsv.Sample{
+				Num:     len(samples) + 1,
+				Time:    value.Tim.Sub(first),
+				Acc:     accG(value),
+				Gyr:     gyrDPS(value),
+				Present: present,
+			})
+		}
+	}
+	add(c.Pre, false)
+	add(c.Data, true)
+	add(c.Post, false)
+	return samples
+}
 
-	theAccelX, err := mpu.i2c.ReadRegU16BE(MPU9250_REG_ACCEL_XOUT_H)
-	if err != nil {
-		return 0, 0, 0, err
+// captureTemp is the temperature of the die over a capture.
+func captureTemp(c *acquisition.Capture[TimAccGyr]) metadata.Temperature {
+	var t metadata.Temperature
+	n := 0
+	for _, part := range [][]TimAccGyr{c.Pre, c.Data, c.Post} {
+		for _, value := range part {
+			temp := mpu9250.TempCelsius(value.Tmp)
+			if n == 0 || temp < t.Min {
+				t.Min = temp
+			}
+			if n == 0 || temp > t.Max {
+				t.Max = temp
+			}
+			t.Mean += temp
+			n++
+		}
 	}
-	theAccelY, err := mpu.i2c.ReadRegU16BE(MPU9250_REG_ACCEL_YOUT_H)
-	if err != nil {
-		return 0, 0, 0, err
+	if n > 0 {
+		t.Mean /= float64(n)
 	}
-	theAccelZ, err := mpu.i2c.ReadRegU16BE(MPU9250_REG_ACCEL_ZOUT_H)
-	if err != nil {
-		return 0, 0, 0, err
+	return t
+}
+
+// Simulation section ===========
+// Simulation section ===========
+// Simulation section ===========
+
+// newSimBus builds a simulated i2c bus with a MPR121 and a MPU9250 attached.
+// The MPR121 reports a touch on electrode 0 for 2 s every 5 s and the MPU9250
+// reports the knob at rest (1 g on Z) with some noise, a gyroscope bias and
+// a rotation while it is touched, warming up slowly, so the whole
+// acquisition loop can run without the knob.
+// The AK8963 is on the same bus, reached in bypass or through the MPU
+// i2c master, and measures the earth field turning with the knob.
+func newSimBus() *i2c.Sim {
+	sim := i2c.NewSim()
+	start := time.Now()
+	touched := func() bool {
+		return time.Since(start)%(5*time.Second) >= 3*time.Second
 	}
 
-	return int(theAccelX), int(theAccelY), int(theAccelZ), nil
+	mpr121.Simulate(sim.Device(mpr121.DEVICE_ADDRESS), func() [mpr121.NUM_ELECTRODES]float64 {
+		var data [mpr121.NUM_ELECTRODES]float64
+		for ele := range data {
+			data[ele] = 700 + 2*(rand.Float64()-0.5)
+		}
+		if touched() { //the hand covers electrode 0 and part of electrode 1
+			data[0] -= 40
+			data[1] -= 15
+		}
+		return data
+	})
+
+	mpu := sim.Device(mpu9250.DEVICE_ADDRESS)
+	mpu9250.Simulate(mpu, sim, func() mpu9250.Sample {
+		noise := func(scale float64) float64 {
+			return scale * (rand.Float64() - 0.5)
+		}
+		var gz float64
+		if touched() {
+			gz = 90.0 * math.Sin(2*math.Pi*time.Since(start).Seconds())
+		}
+		//scaled to the full scales configured in the device
+		accSens := mpu9250.AccelFS(mpu.Get(mpu9250.REG_ACCEL_CONFIG) & mpu9250.PARAM_ACCEL_FS_MASK).Sensitivity()
+		gyrSens := mpu9250.GyroFS(mpu.Get(mpu9250.REG_GYRO_CONFIG) & mpu9250.PARAM_GYRO_FS_MASK).Sensitivity()
+		return mpu9250.Sample{
+			Acc: mpu9250.ThreeDData{
+				X: int16(noise(0.02) * accSens),
+				Y: int16(noise(0.02) * accSens),
+				Z: int16((1.0 + noise(0.02)) * accSens),
+			},
+			Temp: int16((SIM_TEMP + SIM_TEMP_RISE*time.Since(start).Minutes() + noise(0.05) - mpu9250.TEMP_ROOM) * mpu9250.TEMP_SENSITIVITY),
+			Gyr: mpu9250.ThreeDData{
+				X: int16((SIM_GYRO_BIAS[0] + noise(2.0)) * gyrSens),
+				Y: int16((SIM_GYRO_BIAS[1] + noise(2.0)) * gyrSens),
+				Z: int16((SIM_GYRO_BIAS[2] + gz + noise(2.0)) * gyrSens),
+			},
+		}
+	})
+
+	ak8963.Simulate(sim.Device(ak8963.DEVICE_ADDRESS), [3]byte{176, 177, 165}, func() ak8963.Field {
+		//knob angle, integral of the simulated rotation
+		var angle float64
+		if touched() {
+			angle = -90.0 / (2 * math.Pi) * (math.Cos(2*math.Pi*time.Since(start).Seconds()) - 1)
+		}
+		rad := angle * math.Pi / 180
+		//the horizontal component turns with the knob
+		return ak8963.Field{
+			X: 20*math.Cos(rad) + rand.Float64() - 0.5,
+			Y: 20*math.Sin(rad) + rand.Float64() - 0.5,
+			Z: 40 + rand.Float64() - 0.5,
+		}
+	})
+
+	return sim
 }
 
-func (mpu *MPU9250) GetGyro() (gyroX int, gyroY int, gyroZ int, e error) {
-	// based on mrmorphic/hwio/gy520.go
+// Bias of the simulated gyroscope, o/s, and temperature of its die at
+// start, oC, rising SIM_TEMP_RISE per minute.
+var (
+	SIM_GYRO_BIAS = [3]float64{1.2, -0.8, 0.5}
+	SIM_TEMP      = 28.0
+	SIM_TEMP_RISE = 0.5
+)
 
-	theGyroX, err := mpu.i2c.ReadRegU16BE(MPU9250_REG_GYRO_XOUT_H)
-	if err != nil {
-		return 0, 0, 0, err
-	}
-	theGyroY, err := mpu.i2c.ReadRegU16BE(MPU9250_REG_GYRO_YOUT_H)
-	if err != nil {
-		return 0, 0, 0, err
-	}
-	theGyroZ, err := mpu.i2c.ReadRegU16BE(MPU9250_REG_GYRO_ZOUT_H)
-	if err != nil {
-		return 0, 0, 0, err
+// simScriptIR scripts a fake IR sensor like the simulated MPR121, present
+// for 2 s every 5 s.
+func simScriptIR(ir *gpio.FakePin) {
+	for i := 0; i < 1000; i++ {
+		t := time.Duration(i) * 5 * time.Second
+		ir.Script(
+			gpio.FakeEdge{After: t + 3*time.Second, Value: gpio.HIGH},
+			gpio.FakeEdge{After: t + 5*time.Second, Value: gpio.LOW},
+		)
 	}
-
-	return int(theGyroX), int(theGyroY), int(theGyroZ), nil
 }
 
 func checkError(err error) {
@@ -487,28 +670,12 @@ func main() {
 
 	//environment data
 	var (
-		//Data slices with raw data readed from mpu sensor
-		dataStore       = make([]TimAccGyr, 0, DATA_CAP)
-		dataFile        *os.File
 		dataFilePath    string
-		dataFileName    string
 		acquisitionName string
 		acquisitionConf string
-		acquisitionNum  int
 		dataDirectory   string
-		preThisData     TimAccGyr
-		thisData        TimAccGyr
-		presenceBefore  bool
-		presence        = make(chan bool)
-		firstValue      int
-		lastValue       int
-		preValue        TimAccGyr
-		time0           time.Time
-		shiftTime       time.Time
 	)
 
-	acquisitionNum = 0 //increased each infrared sensor (ir) activation
-
 	//args processing
 
 	var nameArg string
@@ -518,14 +685,62 @@ func main() {
 	var gyrFS int
 	var gyrFSMAX float64
 	var margin int
+	var marginMs int
 	var noHead bool
+	var binFormat bool
+	var writeMeta bool
+	var simulate bool
+	var gpioBackend string
+	var rate int
+	var presenceSensor string
+	var debounceMs int
+	var useFIFO bool
+	var magAccess string
+	var magRate int
+	var electrodeList string
+	var thresholdList string
+	var autoCfg bool
+	var recordCap bool
+	var subjectArg string
+	var reps int
+	var registryFile string
+	var modelFile string
+	var threshold float64
+	var eventsFile string
+	var calibFile string
+	var subtractGyro bool
+	var recordTemp bool
 
 	flag.StringVar(&nameArg, "name", "event", "Name of the acquisition")
 	flag.StringVar(&dirArg, "dir", "data", "Directory where store acquisitions")
 	flag.IntVar(&accFS, "acc", 2, "Accelerometer full scale g (2, 4, 8, 16)")
 	flag.IntVar(&gyrFS, "gyro", 250, "Gyroscope full scale dps (250, 500, 1000, 20000)")
 	flag.IntVar(&margin, "marg", 250, fmt.Sprintf("Margin of data to acquire (< %d)", PRE_DATA_CAP))
+	flag.IntVar(&marginMs, "margt", 0, "Margin of data to acquire ms, instead of -marg if not 0")
 	flag.BoolVar(&noHead, "nohd", false, "No head in the data file")
+	flag.BoolVar(&binFormat, "bin", false, "Write the raw counts in the binary format (.knb) instead of CSV")
+	flag.BoolVar(&writeMeta, "meta", true, "Write the metadata of the captures in a JSON lines sidecar (.jsonl)")
+	flag.BoolVar(&simulate, "sim", false, "Use simulated i2c sensors and fake GPIO pins")
+	flag.StringVar(&gpioBackend, "gpio", "sysfs", "GPIO backend (sysfs, cdev)")
+	flag.IntVar(&rate, "rate", 500, "Sampling rate of the MPU Hz")
+	flag.StringVar(&presenceSensor, "pres", "cap", "Presence sensor (cap, ir)")
+	flag.IntVar(&debounceMs, "deb", 20, "Presence debounce time ms")
+	flag.BoolVar(&useFIFO, "fifo", false, "Read the MPU through its FIFO, evenly spaced samples")
+	flag.StringVar(&magAccess, "mag", "", "Read the magnetometer (bypass, master), none if empty")
+	flag.IntVar(&magRate, "magrate", 100, "Magnetometer rate Hz (8, 100)")
+	flag.StringVar(&electrodeList, "ele", "0", "MPR121 electrodes, comma separated or all")
+	flag.StringVar(&thresholdList, "th", "12/6", "MPR121 touch/release thresholds, for all or per electrode comma separated")
+	flag.BoolVar(&autoCfg, "autocfg", false, "MPR121 auto-configuration of the electrode charge")
+	flag.BoolVar(&recordCap, "cap", false, "Record the MPR121 filtered data and baseline of the electrodes")
+	flag.StringVar(&subjectArg, "subject", "", "Enrollment of a subject registered with knobEnroll, instead of -name")
+	flag.IntVar(&reps, "reps", 10, "Repetitions to take in enrollment, no limit if 0")
+	flag.StringVar(&registryFile, "registry", enroll.DefaultRegistry("data"), "Registry of the subjects for the enrollment")
+	flag.StringVar(&calibFile, "calib", "auto", "Calibration profile of calib.go applied to the accelerometer, auto for the one of the device if any, none if empty")
+	flag.BoolVar(&subtractGyro, "gyrobias", false, "Subtract from the gyroscope data its bias, estimated while the knob is still")
+	flag.BoolVar(&recordTemp, "temp", false, "Record the temperature of every sample")
+	flag.StringVar(&modelFile, "model", "", "Identify the subject of every capture with a model of knobIdent, none if empty")
+	flag.Float64Var(&threshold, "threshold", 0, "Score under which the subject is unknown, the one of the model file if 0")
+	flag.StringVar(&eventsFile, "events", "-", "File the identification events are appended to as JSON lines, - for the standard output")
 
 	flag.Parse()
 
@@ -535,6 +750,28 @@ func main() {
 	log.Printf("\t Acc: %d", accFS)
 	log.Printf("\t Gyro: %d", gyrFS)
 	log.Printf("\t Marg: %d", margin)
+	log.Printf("\t MargT: %d", marginMs)
+	log.Printf("\t Bin: %t", binFormat)
+	log.Printf("\t Meta: %t", writeMeta)
+	log.Printf("\t Sim: %t", simulate)
+	log.Printf("\t GPIO: %s", gpioBackend)
+	log.Printf("\t Rate: %d", rate)
+	log.Printf("\t Pres: %s", presenceSensor)
+	log.Printf("\t Deb: %d", debounceMs)
+	log.Printf("\t FIFO: %t", useFIFO)
+	log.Printf("\t Mag: %s", magAccess)
+	log.Printf("\t MagRate: %d", magRate)
+	log.Printf("\t Ele: %s", electrodeList)
+	log.Printf("\t Th: %s", thresholdList)
+	log.Printf("\t AutoCfg: %t", autoCfg)
+	log.Printf("\t Cap: %t", recordCap)
+	log.Printf("\t Subject: %s", subjectArg)
+	log.Printf("\t Reps: %d", reps)
+	log.Printf("\t Calib: %s", calibFile)
+	log.Printf("\t GyroBias: %t", subtractGyro)
+	log.Printf("\t Temp: %t", recordTemp)
+	log.Printf("\t Model: %s", modelFile)
+	log.Printf("\t Threshold: %g", threshold)
 
 	if margin < 0 {
 		margin = 0
@@ -546,48 +783,100 @@ func main() {
 		log.Printf("Margin too big, set to the maximun available: %d", margin)
 	}
 
-	preDataStore := make([]TimAccGyr, margin)
+	if rate <= 0 {
+		rate = 500
+		log.Printf("Rate not positive!, set to %d", rate)
+	}
+	debounce := time.Duration(debounceMs) * time.Millisecond
+
+	electrodes, err := parseElectrodes(electrodeList)
+	checkError(err)
+	thresholds, err := parseThresholds(thresholdList, len(electrodes))
+	checkError(err)
+
 	//set the vars regarding the args
 	acquisitionName = nameArg
-	acquisitionConf = "a"
 	dataDirectory = dirArg
 
+	//in enrollment the captures are named after the subject
+	var subject *enroll.Subject
+	if subjectArg != "" {
+		registry, err := enroll.Load(registryFile)
+		checkError(err)
+		subject, err = registry.Get(subjectArg)
+		if err != nil {
+			log.Fatalf("%v, register it with knobEnroll", err)
+		}
+		acquisitionName = subject.ID
+	}
+
 	//set the full scale dependinf of acc and gyr configuration
-	switch accFS {
-	case 2:
-		accFSMAX = MPU9250_SENSITIVITY_ACCEL_SF_FS_2G
-		acquisitionConf += "2"
-	case 4:
-		accFSMAX = MPU9250_SENSITIVITY_ACCEL_SF_FS_4G
-		acquisitionConf += "4"
-	case 8:
-		accFSMAX = MPU9250_SENSITIVITY_ACCEL_SF_FS_8G
-		acquisitionConf += "8"
-	case 16:
-		accFSMAX = MPU9250_SENSITIVITY_ACCEL_SF_FS_16G
-		acquisitionConf += "16"
-	default:
-		accFSMAX = MPU9250_SENSITIVITY_ACCEL_SF_FS_2G
-		acquisitionConf += "2"
-	}
-
-	acquisitionConf += "w"
-	switch gyrFS {
-	case 250:
-		gyrFSMAX = MPU9250_SENSITIVITY_GYRO_SF_FS_250
-		acquisitionConf += "250"
-	case 500:
-		gyrFSMAX = MPU9250_SENSITIVITY_GYRO_SF_FS_500
-		acquisitionConf += "500"
-	case 1000:
-		gyrFSMAX = MPU9250_SENSITIVITY_GYRO_SF_FS_1000
-		acquisitionConf += "1000"
-	case 2000:
-		gyrFSMAX = MPU9250_SENSITIVITY_GYRO_SF_FS_2000
-		acquisitionConf += "2000"
-	default:
-		gyrFSMAX = MPU9250_SENSITIVITY_GYRO_SF_FS_250
-		acquisitionConf += "250"
+	accelFS, err := mpu9250.AccelFSFromG(accFS)
+	if err != nil {
+		log.Printf("%v, set to %v", err, accelFS)
+	}
+	gyroFS, err := mpu9250.GyroFSFromDPS(gyrFS)
+	if err != nil {
+		log.Printf("%v, set to %v", err, gyroFS)
+	}
+	accFS, accFSMAX = accelFS.G(), accelFS.Sensitivity()
+	gyrFS, gyrFSMAX = gyroFS.DPS(), gyroFS.Sensitivity()
+	acquisitionConf = fmt.Sprintf("a%dw%d", accFS, gyrFS)
+
+	//calibration of the accelerometer, the profile of the device by default
+	var profile *calibration.Profile
+	if calibFile == "auto" {
+		calibFile = calibration.DefaultProfile(calibration.PROFILE_DIR, metadata.NewDevice())
+		if _, err := os.Stat(calibFile); os.IsNotExist(err) {
+			log.Printf("No calibration profile %s, the accelerometer is not calibrated", calibFile)
+			calibFile = ""
+		}
+	}
+	if calibFile != "" {
+		profile, err = calibration.Load(calibFile)
+		checkError(err)
+		if profile.Accel == nil {
+			log.Printf("No accelerometer calibration in %s", calibFile)
+		} else {
+			log.Printf("Accelerometer calibration of %s: bias %.4f g, scale %.4f, residual %.4f g",
+				calibFile, profile.Accel.Bias, profile.Accel.Scale, profile.Accel.Residual)
+			if profile.Accel.FS != accFS {
+				log.Printf("Warning: accelerometer calibrated at %d g, used at %d g", profile.Accel.FS, accFS)
+			}
+		}
+	}
+	var thermal *calibration.Thermal
+	if profile != nil && profile.Thermal != nil {
+		thermal = profile.Thermal
+		log.Printf("Temperature compensation of %s: %.5f g/oC, %.4f o/s/oC from %.1f oC",
+			calibFile, thermal.Acc.Slope, thermal.Gyr.Slope, thermal.Ref)
+	}
+	//accelerations in g, calibrated, and rotation rates in o/s, both with
+	//the drift of the temperature removed
+	accG := func(value TimAccGyr) [3]float64 {
+		v := value.Acc
+		acc := [3]float64{float64(v.X) / accFSMAX, float64(v.Y) / accFSMAX, float64(v.Z) / accFSMAX}
+		if thermal != nil {
+			drift := thermal.AccDrift(mpu9250.TempCelsius(value.Tmp))
+			for i := range acc {
+				acc[i] -= drift[i]
+			}
+		}
+		if profile != nil && profile.Accel != nil {
+			acc = profile.Accel.Apply(acc)
+		}
+		return acc
+	}
+	gyrDPS := func(value TimAccGyr) [3]float64 {
+		v := value.Gyr
+		gyr := [3]float64{float64(v.X) / gyrFSMAX, float64(v.Y) / gyrFSMAX, float64(v.Z) / gyrFSMAX}
+		if thermal != nil {
+			drift := thermal.GyrDrift(mpu9250.TempCelsius(value.Tmp))
+			for i := range gyr {
+				gyr[i] -= drift[i]
+			}
+		}
+		return gyr
 	}
 
 	//create data dir if not exists
@@ -597,358 +886,784 @@ func main() {
 		log.Printf("Data dir created.")
 	}
 
+	//the repetitions of an enrollment follow the ones already taken
+	var session *enroll.Session
+	if subject != nil {
+		first, err := enroll.NextRepetition(dataFilePath, acquisitionName+acquisitionConf)
+		checkError(err)
+		session = enroll.NewSession(subject, reps, first)
+		log.Printf("Enrollment of %s (%s handed) from repetition %02d", subject.ID, subject.Handedness, first)
+	}
+
 	const (
 		PIN_LED int = 4
 		PIN_IR  int = 22 //17
+		PIN_IRQ int = 17 //MPR121 IRQ, active low
 	)
 
+	//gpio backend, sysfs, the /dev/gpiochip0 character device or fake pins
+	var openPin gpio.Opener
+	switch {
+	case simulate:
+		openPin = gpio.FakeOpener
+	case gpioBackend == "cdev":
+		openPin = gpio.ChipOpener(0)
+	case gpioBackend == "sysfs":
+		openPin = gpio.OpenPin
+	default:
+		log.Fatalf("Unknown gpio backend %q (sysfs, cdev)", gpioBackend)
+	}
+
 	//led
-	led, err := gpio.OpenPin(PIN_LED, gpio.OUT)
+	led, err := openPin(PIN_LED, gpio.OUT)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer led.Close()
 	defer led.Write(gpio.LOW)
-
-	//ir
-	//open the pin in the GPIO
-	//ir, err := gpio.OpenPin(PIN_IR, gpio.IN)
-	//if err != nil {
-	//log.Fatal(err)
-	//}
-	//defer ir.Close()
-	presenceBefore = false
+	leds := newLEDOwner(led)
+	defer leds.Close()
+
+	//i2c bus, the real one or a simulated one with the sensors attached
+	openI2C := i2c.Opener(i2c.Open)
+	if simulate {
+		openI2C = newSimBus().Open
+		log.Println("Using simulated i2c sensors")
+	}
 
 	//create the MPR, open the i2c comm
-	mpr, err := NewMPR121(1)
+	mpr, err := mpr121.Open(openI2C, 1)
 	checkError(err)
 
-	mpr.Config()
-	defer mpr.i2c.WriteRegU8(MPR121_SOFTRESET, 0x63)
+	checkError(configMPR(mpr, electrodes, thresholds, autoCfg))
+	defer mpr.Reset()
 
 	//create the MPU, open the i2c comm and set the accel and gyro full scale value
 	//var mpu MPU9250
 
-	mpu, err := NewMPU9250(1, accFS, gyrFS)
+	mpu, err := mpu9250.Open(openI2C, 1, accelFS, gyroFS)
 	checkError(err)
 
-	defer mpu.i2c.Close()
+	defer mpu.Close()
 
-	mpu.Wake()
-	mpu.Config()
-	mpu.Wake()
+	if err := mpu.Verify(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	checkError(mpu.Wake())
+	checkError(mpu.Config())
+	checkError(mpu.Wake())
+
+	//the offset registers of the calibration, lost at every power off
+	if profile != nil && profile.Hardware != nil {
+		offsets, err := mpu.WriteOffsets(mpu9250.NewOffsets(profile.Hardware.Accel, profile.Hardware.Gyro))
+		checkError(err)
+		log.Printf("Offset registers of %s written: accel %v, gyro %v", calibFile, offsets.Accel, offsets.Gyro)
+	}
 
 	//test the sensor
-	_, _, _, err = mpu.GetAccel()
-	checkError(err)
-	_, _, _, err = mpu.GetGyro()
+	_, err = mpu.ReadSample()
 	checkError(err)
-	log.Println("Sensor Ready!")
-
-	//var creation out of the loop
-	var (
-		ax uint16
-		ay uint16
-		az uint16
-		gx uint16
-		gy uint16
-		gz uint16
-	)
-	buf := make([]byte, 14) //to store 14 bytes
-	//i := 0
+	//the magnetometer, optional
+	var mag *Magnetometer
+	if magAccess != "" {
+		magMode, err := ak8963.ModeFromRate(magRate)
+		if err != nil {
+			log.Printf("%v, set to %v", err, magMode)
+		}
+		mag, err = NewMagnetometer(mpu, openI2C, 1, magAccess, magMode)
+		checkError(err)
+		defer mag.Stop()
+		log.Printf("Magnetometer Ready! (%s, %v)", magAccess, magMode)
+	}
 
-	//gorutine detec presence at IR and set led
-	//go readIR(ir, led, presence)
-	go readCap(mpr, led, presence)
+	//gyroscope bias, from a still window at startup, refined while idle
+	var gyroBias calibration.GyroBias
+	biasWindow := int(GYRO_BIAS_WINDOW.Seconds() * float64(rate))
+	physical := func(samples []TimAccGyr) (acc, gyr [][3]float64) {
+		for _, value := range samples {
+			acc = append(acc, accG(value))
+			gyr = append(gyr, gyrDPS(value))
+		}
+		return acc, gyr
+	}
+	log.Println("Estimating the gyroscope bias, keep the knob still")
+	for try := 1; try <= GYRO_STARTUP_TRIES && gyroBias.Windows == 0; try++ {
+		window := make([]TimAccGyr, biasWindow)
+		for i := range window {
+			window[i] = readSample(mpu)
+			time.Sleep(time.Second / time.Duration(rate))
+		}
+		acc, gyr := physical(window)
+		if !gyroBias.Update(acc, gyr, time.Now()) {
+			log.Printf("Knob moving, gyroscope bias not estimated (%d/%d)", try, GYRO_STARTUP_TRIES)
+		}
+	}
+	if gyroBias.Windows > 0 {
+		log.Printf("Gyroscope bias %.3f o/s", gyroBias.Bias)
+	} else {
+		log.Printf("Warning: no still window at startup, the gyroscope bias is estimated while idle")
+	}
 
-	log.Println("Entering pre-acquisition mode...")
-	for presenceNow := range presence {
+	log.Println("Sensor Ready!")
 
-		if presenceNow {
-			if !presenceBefore { //begin a capture
-				log.Println("Presence detected, begin acquisition")
-				time0 = time.Now() //reset time of measures
-				shiftTime = time0  //reset time of measures
-				//acquisitionNum++   //increase the num of acquisitions
-				//i = 0              //log purposes
-			}
-			//read the acc and gyro data in one step without err consideration
-			_, _ = mpu.i2c.Write([]byte{MPU9250_REG_ACCEL_XOUT_H})
-			_, _ = mpu.i2c.Read(buf)
-			//time of readdings
-			thisData.Tim = time.Now() //.Sub(time0)
-			//data of readdings
-			ax = uint16(buf[0])<<8 | uint16(buf[1])
-			if ax > 32767 {
-				ax = ^ax + 0x01
-				thisData.Acc.X = -1 * int16(ax)
-			} else {
-				thisData.Acc.X = int16(ax)
+	//the mpu is sampled at its own rate whatever the presence sensor does,
+	//either by the ticker or by the device itself into its FIFO
+	var stream *mpu9250.FIFOStream
+	period := time.Second / time.Duration(rate)
+	if useFIFO {
+		stream, err = mpu.StartFIFO(float64(rate))
+		checkError(err)
+		defer stream.Stop()
+		log.Printf("FIFO sampling at %.1f Hz", stream.Rate())
+		period = FIFO_POLL
+	}
+	//the rate the samples are taken at, the divider of the FIFO rounds it
+	sampleRate := float64(rate)
+	if stream != nil {
+		sampleRate = stream.Rate()
+	}
+	samplePeriod := time.Duration(float64(time.Second) / sampleRate)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	//configuration of the sensors, as written, for the metadata, read before
+	//the detector polls the MPR121
+	var sensors []metadata.Sensor
+	if writeMeta {
+		mpuSensor := metadata.Sensor{
+			Name:    "MPU9250",
+			Bus:     1,
+			Address: mpu9250.DEVICE_ADDRESS,
+			Config: map[string]string{
+				"accel": accelFS.String(),
+				"gyro":  gyroFS.String(),
+				"dlpf":  fmt.Sprint(mpu.DLPF()),
+			},
+		}
+		if stream != nil {
+			mpuSensor.Config["fifoRate"] = fmt.Sprintf("%.1f", stream.Rate())
+		}
+		if mpuSensor.Registers, err = mpu.ReadConfig(); err != nil {
+			log.Printf("Warning: reading the MPU configuration: %v", err)
+		}
+		mprSensor := metadata.Sensor{Name: "MPR121", Bus: 1, Address: mpr121.DEVICE_ADDRESS}
+		if mprSensor.Registers, err = mpr.ReadConfig(); err != nil {
+			log.Printf("Warning: reading the MPR121 configuration: %v", err)
+		}
+		sensors = append(sensors, mpuSensor, mprSensor)
+		if mag != nil {
+			asa := mag.ak.Adjustment()
+			magSensor := metadata.Sensor{
+				Name:    "AK8963",
+				Bus:     1,
+				Address: ak8963.DEVICE_ADDRESS,
+				Access:  magAccess,
+				Config: map[string]string{
+					"mode": mag.ak.Mode().String(),
+					"asa":  fmt.Sprintf("%.4f,%.4f,%.4f", asa[0], asa[1], asa[2]),
+				},
 			}
-
-			ay = uint16(buf[2])<<8 | uint16(buf[3])
-			if ay > 32767 {
-				ay = ^ay + 0x01
-				thisData.Acc.Y = -1 * int16(ay)
-			} else {
-				thisData.Acc.Y = int16(ay)
+			if magSensor.Registers, err = mag.ak.ReadConfig(); err != nil {
+				log.Printf("Warning: reading the AK8963 configuration: %v", err)
 			}
+			sensors = append(sensors, magSensor)
+		}
+	}
+	//presence events, from the MPR121 touch status or the IR sensor
+	var detector *presence.Detector
+	switch presenceSensor {
+	case "ir":
+		ir, err := openPin(PIN_IR, gpio.IN)
+		checkError(err)
+		defer ir.Close()
+		if fake, ok := ir.(*gpio.FakePin); ok {
+			simScriptIR(fake)
+		}
+		detector = presence.FromPin(ir, gpio.HIGH, debounce)
+	default:
+		var irq gpio.Pin
+		if !simulate { //the simulated MPR121 has no IRQ line, it is polled
+			irq, err = openPin(PIN_IRQ, gpio.IN)
+			checkError(err)
+			defer irq.Close()
+		}
+		detector = presence.FromTouch(mpr.Touched, irq, debounce)
+	}
+	events := make(chan presence.Event, 8)
+	stopDetector := make(chan struct{})
+	detectorDone := make(chan struct{})
+	//the detector is done with the sensors before they are reset
+	defer func() {
+		close(stopDetector)
+		<-detectorDone
+	}()
+	go func() {
+		defer close(detectorDone)
+		if err := detector.Run(events, stopDetector); err != nil {
+			log.Fatal(err)
+		}
+	}()
 
-			az = uint16(buf[4])<<8 | uint16(buf[5])
-			if az > 32767 {
-				az = ^az + 0x01
-				thisData.Acc.Z = -1 * int16(az)
-			} else {
-				thisData.Acc.Z = int16(az)
+	//optional columns of the magnetometer, the electrodes and the
+	//temperature, header and data
+	extraHeader := ""
+	if mag != nil {
+		extraHeader += "; magX(uT); magY(uT); magZ(uT)"
+	}
+	if recordCap {
+		for _, ele := range electrodes {
+			extraHeader += fmt.Sprintf("; filt%d; base%d", ele, ele)
+		}
+	}
+	if recordTemp {
+		extraHeader += "; temp(oC)"
+	}
+	extraColumns := func(value TimAccGyr) string {
+		columns := ""
+		if mag != nil {
+			field := mag.Field(value.Mag)
+			columns += fmt.Sprintf(";%f;%f;%f", field.X, field.Y, field.Z)
+		}
+		if recordCap {
+			for _, ele := range electrodes {
+				columns += fmt.Sprintf(";%d;%d", value.Cap.Filt[ele], value.Cap.Base[ele])
 			}
+		}
+		if recordTemp {
+			columns += fmt.Sprintf(";%.2f", mpu9250.TempCelsius(value.Tmp))
+		}
+		return columns
+	}
 
-			gx = uint16(buf[8])<<8 | uint16(buf[9])
-			if gx > 32767 {
-				gx = ^gx + 0x01
-				thisData.Gyr.X = -1 * int16(gx)
-			} else {
-				thisData.Gyr.X = int16(gx)
-			}
+	//data file of a capture
+	captureFile := func(num int, ext string) string {
+		return fmt.Sprintf("%s%s_%02d%s", filepath.Join(dataFilePath, acquisitionName), acquisitionConf, num, ext)
+	}
 
-			gy = uint16(buf[10])<<8 | uint16(buf[11])
-			if gy > 32767 {
-				gy = ^gy + 0x01
-				thisData.Gyr.Y = -1 * int16(gy)
-			} else {
-				thisData.Gyr.Y = int16(gy)
-			}
+	//captures are appended to their file, but in enrollment every
+	//repetition has its own file and an existing one is never appended to
+	openCapture := func(name string) (*os.File, error) {
+		if session != nil {
+			return enroll.Create(name)
+		}
+		return os.OpenFile(name, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+	}
 
-			gz = uint16(buf[12])<<8 | uint16(buf[13])
-			if gz > 32767 {
-				gz = ^gz + 0x01
-				thisData.Gyr.Z = -1 * int16(gz)
-			} else {
-				thisData.Gyr.Z = int16(gz)
-			}
+	//gyroscope bias of a capture, taken with it on the acquisition
+	//goroutine and used once by the dump on the writer goroutine
+	var biasMu sync.Mutex
+	biases := make(map[int]metadata.GyroBias)
+	captureBias := func(num int) metadata.GyroBias {
+		biasMu.Lock()
+		defer biasMu.Unlock()
+		bias := biases[num]
+		delete(biases, num)
+		return bias
+	}
+	biasLine := func(bias metadata.GyroBias) string {
+		applied := "not subtracted"
+		if bias.Subtracted {
+			applied = "subtracted"
+		}
+		return fmt.Sprintf("%.3f, %.3f, %.3f o/s (%d still windows, %s)", bias.Bias[0], bias.Bias[1], bias.Bias[2], bias.Windows, applied)
+	}
 
-			dataStore = append(dataStore, thisData)
+	tempLine := func(t metadata.Temperature) string {
+		line := fmt.Sprintf("%.2f oC (%.2f to %.2f)", t.Mean, t.Min, t.Max)
+		if thermal != nil {
+			line += fmt.Sprintf(", drift compensated from %.1f oC", thermal.Ref)
+		}
+		return line
+	}
 
-		} else {
-			if presenceBefore { //end of a capture, dump data
-				//post margin acquisition
-				log.Println("Absence detected, stop acquisition")
-				log.Println("Doing post-acquisition")
-				for i := 0; i < margin; i++ {
-					//here the acquistion margin post
-					//read the acc and gyro data in one step without err consideration
-					_, _ = mpu.i2c.Write([]byte{MPU9250_REG_ACCEL_XOUT_H})
-					_, _ = mpu.i2c.Read(buf)
-					//time of readdings
-					thisData.Tim = time.Now() //.Sub(time0)
-					//log.Printf("post margin %d ", thisData.Tim/time.Microsecond)
-					//data of readdings
-					ax = uint16(buf[0])<<8 | uint16(buf[1])
-					if ax > 32767 {
-						ax = ^ax + 0x01
-						thisData.Acc.X = -1 * int16(ax)
-					} else {
-						thisData.Acc.X = int16(ax)
-					}
+	//dump the pre-margin, the data and the post-margin of a capture into a
+	//file, on the writer goroutine
+	dumpData := func(c *acquisition.Capture[TimAccGyr]) error {
+		acquisitionNum := c.Num
+		bias := captureBias(c.Num)
+		log.Printf("Dump data %d to file", acquisitionNum)
+		log.Printf("Data store size: %d (pre %d, post %d)", c.Len(), len(c.Pre), len(c.Post))
+		if len(c.Data) > 0 {
+			log.Printf("Data acquisition rate: %d Hz", int(1000000.0*float32(len(c.Data))/float32(c.Data[len(c.Data)-1].Tim.Sub(c.Start)/time.Microsecond)))
+		}
+		//Create and open file
+		dataFileName := captureFile(acquisitionNum, DATAFILE_EXTENSION)
+		log.Printf("Opennign %s\n", dataFileName)
+		dataFile, err := openCapture(dataFileName)
+		if err != nil {
+			return err
+		}
+		defer dataFile.Close()
+		w := bufio.NewWriter(dataFile)
+
+		headLine := ""
+		if !noHead {
+			//headding line
+			headLine = headLine + "##########\n"
+			headLine = headLine + fmt.Sprintf("# %v Data Acquisition\n", time.Now())
+			headLine = headLine + fmt.Sprintf("# Acquisition name: %s\n", acquisitionName)
+			headLine = headLine + fmt.Sprintf("# Acquisition num: %d\n", acquisitionNum)
+			headLine = headLine + fmt.Sprintf("# Accelerometer full scale: %d (%d)\n", accFS, int(accFSMAX))
+			headLine = headLine + fmt.Sprintf("# Gyroscope full scale: %d (%d)\n", gyrFS, int(gyrFSMAX))
+			if profile != nil && profile.Accel != nil {
+				headLine = headLine + fmt.Sprintf("# Calibration: %s\n", calibFile)
+			}
+			if profile != nil && profile.Hardware != nil {
+				headLine = headLine + fmt.Sprintf("# Offset registers: %s, applied by the device\n", calibFile)
+			}
+			headLine = headLine + fmt.Sprintf("# Gyroscope bias: %s\n", biasLine(bias))
+			headLine = headLine + fmt.Sprintf("# Temperature: %s\n", tempLine(captureTemp(c)))
+			if mag != nil {
+				headLine = headLine + fmt.Sprintf("# Magnetometer: AK8963 %v (%s), uT in the acc and gyro axes\n", mag.ak.Mode(), magAccess)
+			}
+			if recordCap {
+				headLine = headLine + fmt.Sprintf("# Electrodes: %s, thresholds %s\n", electrodeList, thresholdList)
+			}
+			headLine = headLine + "##########\n"
+		}
+		headLine = headLine + fmt.Sprintf("num; time(us); accX(g); accY(g); accZ(g); gyrX(o/s); gyrY(o/s); gyrZ(o/s)%s;p\n", extraHeader)
+		w.WriteString(headLine) //write headding line in the file
+		//times from the first sample of the pre-margin, or the presence without margin
+		shiftTime := c.Start
+		if len(c.Pre) > 0 {
+			shiftTime = c.Pre[0].Tim
+		}
+		num := 0
+		writeData := func(data []TimAccGyr, p int) {
+			for _, value := range data {
+				num++
+				acc, gyr := accG(value), gyrDPS(value)
+				fmt.Fprintf(w, "%d;%d;%f;%f;%f;%f;%f;%f%s;%d\n",
+					num,
+					int64(value.Tim.Sub(shiftTime)/time.Microsecond),
+					acc[0],
+					acc[1],
+					acc[2],
+					gyr[0],
+					gyr[1],
+					gyr[2],
+					extraColumns(value),
+					p)
+			}
+		}
+		writeData(c.Pre, 0)
+		writeData(c.Data, 1)
+		writeData(c.Post, 0)
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		//the capture is on the disk before the file is reported as closed
+		if err := dataFile.Sync(); err != nil {
+			return err
+		}
+		log.Printf("Closed %s\n", dataFileName)
+		return nil
+	}
 
-					ay = uint16(buf[2])<<8 | uint16(buf[3])
-					if ay > 32767 {
-						ay = ^ay + 0x01
-						thisData.Acc.Y = -1 * int16(ay)
-					} else {
-						thisData.Acc.Y = int16(ay)
+	//dump a capture into a binary file, the raw counts with the absolute
+	//times, on the writer goroutine
+	dumpBinary := func(c *acquisition.Capture[TimAccGyr]) error {
+		dataFileName := captureFile(c.Num, knobbin.EXTENSION)
+		log.Printf("Dump data %d to %s (pre %d, data %d, post %d)", c.Num, dataFileName, len(c.Pre), len(c.Data), len(c.Post))
+		start := c.Start
+		if len(c.Pre) > 0 {
+			start = c.Pre[0].Tim
+		}
+		fields := make(map[string]string)
+		if profile != nil && profile.Accel != nil {
+			fields["Calibration"] = calibFile + ", not applied to the counts"
+		}
+		if profile != nil && profile.Hardware != nil {
+			fields["Offset registers"] = calibFile + ", applied by the device"
+		}
+		fields["Gyroscope bias"] = biasLine(captureBias(c.Num))
+		fields["Temperature"] = tempLine(captureTemp(c))
+		if thermal != nil {
+			fields["Temperature"] += ", not applied to the counts"
+		}
+		if mag != nil {
+			asa := mag.ak.Adjustment()
+			fields["Magnetometer"] = fmt.Sprintf("AK8963 %v (%s), counts of the Y, X, -Z axes, sensitivity adjustment %g %g %g",
+				mag.ak.Mode(), magAccess, asa[0], asa[1], asa[2])
+		}
+		if recordCap {
+			fields["Electrodes"] = fmt.Sprintf("%s, thresholds %s", electrodeList, thresholdList)
+		}
+		bin := knobbin.New(knobbin.Header{
+			Name:    acquisitionName,
+			Num:     c.Num,
+			Start:   start,
+			Rate:    sampleRate,
+			AccFS:   accFS,
+			AccSens: accFSMAX,
+			GyrFS:   gyrFS,
+			GyrSens: gyrFSMAX,
+			Fields:  fields,
+		})
+		if mag != nil {
+			for _, ch := range mag.Channels() {
+				bin.AddChannel(ch.Name, ch.Scale, ch.Offset)
+			}
+		}
+		if recordCap {
+			for _, ele := range electrodes {
+				bin.AddChannel(fmt.Sprintf("filt%d", ele), 1, 0)
+				bin.AddChannel(fmt.Sprintf("base%d", ele), 1, 0)
+			}
+		}
+		if recordTemp {
+			bin.AddChannel("temp(oC)", 1/mpu9250.TEMP_SENSITIVITY, mpu9250.TempCelsius(0))
+		}
+		appendData := func(data []TimAccGyr, present bool) {
+			for _, value := range data {
+				counts := []int16{value.Acc.X, value.Acc.Y, value.Acc.Z, value.Gyr.X, value.Gyr.Y, value.Gyr.Z}
+				if mag != nil {
+					counts = append(counts, value.Mag.Y, value.Mag.X, value.Mag.Z)
+				}
+				if recordCap {
+					for _, ele := range electrodes {
+						counts = append(counts, int16(value.Cap.Filt[ele]), int16(value.Cap.Base[ele]))
 					}
+				}
+				if recordTemp {
+					counts = append(counts, value.Tmp)
+				}
+				bin.Append(value.Tim.Sub(start), present, counts...)
+			}
+		}
+		appendData(c.Pre, false)
+		appendData(c.Data, true)
+		appendData(c.Post, false)
+		f, err := openCapture(dataFileName)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := knobbin.Write(f, bin); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		log.Printf("Closed %s\n", dataFileName)
+		return nil
+	}
+	dump, dataExt := dumpData, DATAFILE_EXTENSION
+	if binFormat {
+		dump, dataExt = dumpBinary, knobbin.EXTENSION
+	}
 
-					az = uint16(buf[4])<<8 | uint16(buf[5])
-					if az > 32767 {
-						az = ^az + 0x01
-						thisData.Acc.Z = -1 * int16(az)
-					} else {
-						thisData.Acc.Z = int16(az)
-					}
+	//the metadata of a capture is taken on the acquisition goroutine, where
+	//the counters are updated, and written after the data
+	var metaMu sync.Mutex
+	metas := make(map[int]*metadata.Metadata)
+	if writeMeta {
+		dumpOnly := dump
+		dump = func(c *acquisition.Capture[TimAccGyr]) error {
+			err := dumpOnly(c)
+			metaMu.Lock()
+			meta := metas[c.Num]
+			delete(metas, c.Num)
+			metaMu.Unlock()
+			if meta == nil {
+				return err
+			}
+			name := captureFile(c.Num, dataExt)
+			meta.File, meta.Written = filepath.Base(name), time.Now()
+			if merr := metadata.Append(metadata.SidecarName(name), meta); err == nil {
+				err = merr
+			}
+			return err
+		}
+	}
 
-					gx = uint16(buf[8])<<8 | uint16(buf[9])
-					if gx > 32767 {
-						gx = ^gx + 0x01
-						thisData.Gyr.X = -1 * int16(gx)
-					} else {
-						thisData.Gyr.X = int16(gx)
-					}
+	//the subject of a capture is identified once it is written, on the
+	//writer goroutine so the sampling goes on
+	if modelFile != "" {
+		model, modelThreshold, err := ident.LoadFile(modelFile)
+		checkError(err)
+		if threshold == 0 {
+			threshold = modelThreshold
+		}
+		if threshold == 0 {
+			log.Fatalf("No threshold in %s, train it again with knobIdent or give -threshold", modelFile)
+		}
+		log.Printf("Identification with the %s model of %v, unknown under %.3f", model.Kind(), model.Subjects(), threshold)
+		events := os.Stdout
+		if eventsFile != "-" {
+			events, err = os.OpenFile(eventsFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+			checkError(err)
+			defer events.Close()
+		}
+		enc := json.NewEncoder(events)
+		identify := func(c *acquisition.Capture[TimAccGyr], writeErr error) {
+			subject, matches, err := ident.Identify(model, captureSamples(c, accG, gyrDPS), threshold)
+			if err != nil {
+				log.Printf("Error identifying capture %d: %v", c.Num, err)
+				return
+			}
+			ev := IdentEvent{Time: time.Now(), Name: acquisitionName, Num: c.Num, Subject: subject, Matches: matches[:min(len(matches), IDENT_TOP)]}
+			if len(matches) > 0 {
+				ev.Score = matches[0].Score
+			}
+			if writeErr != nil {
+				ev.Error = writeErr.Error()
+			}
+			if subject == ident.UNKNOWN {
+				log.Printf("Capture %d: unknown subject (score %.3f < %.3f)", c.Num, ev.Score, threshold)
+				leds.Show(blinks(IDENT_UNKNOWN_BLINKS))
+			} else {
+				log.Printf("Capture %d: subject %s identified (score %.3f)", c.Num, subject, ev.Score)
+				leds.Show(lit(IDENT_LED_ON))
+			}
+			if err := enc.Encode(ev); err != nil {
+				log.Printf("Error emitting the identification event: %v", err)
+			}
+		}
+		dumpOnly := dump
+		dump = func(c *acquisition.Capture[TimAccGyr]) error {
+			err := dumpOnly(c)
+			identify(c, err)
+			return err
+		}
+	}
 
-					gy = uint16(buf[10])<<8 | uint16(buf[11])
-					if gy > 32767 {
-						gy = ^gy + 0x01
-						thisData.Gyr.Y = -1 * int16(gy)
-					} else {
-						thisData.Gyr.Y = int16(gy)
-					}
+	//the repetitions of an enrollment are counted once written
+	if session != nil {
+		dumpOnly := dump
+		dump = func(c *acquisition.Capture[TimAccGyr]) error {
+			err := dumpOnly(c)
+			if err != nil {
+				log.Printf("Error writing the repetition file %02d: %v", c.Num, err)
+			}
+			session.Written(err)
+			return err
+		}
+	}
 
-					gz = uint16(buf[12])<<8 | uint16(buf[13])
-					if gz > 32767 {
-						gz = ^gz + 0x01
-						thisData.Gyr.Z = -1 * int16(gz)
-					} else {
-						thisData.Gyr.Z = int16(gz)
-					}
+	//captures are written in the background while sampling goes on
+	writer := acquisition.NewAsync[TimAccGyr](acquisition.SinkFunc[TimAccGyr](dump), WRITER_QUEUE)
+	defer func() {
+		log.Println("Waiting for the pending captures to be written")
+		if err := writer.Close(); err != nil {
+			log.Printf("Error writing the captures: %v", err)
+		}
+	}()
 
-					dataStore = append(dataStore, thisData)
+	knob := &knobSource{mpu: mpu, stream: stream, mag: mag}
+	if recordCap {
+		knob.mpr = mpr
+	}
+	//the gyroscope bias is refined on the windows of the pre-trigger, where
+	//the knob is idle, on the acquisition goroutine
+	var machine *acquisition.Machine[TimAccGyr]
+	idle := make([]TimAccGyr, 0, biasWindow)
+	source := acquisition.SourceFunc[TimAccGyr](func() ([]TimAccGyr, error) {
+		newData, err := knob.Read()
+		if machine.State() != acquisition.PRE_TRIGGER {
+			idle = idle[:0]
+			return newData, err
+		}
+		idle = append(idle, newData...)
+		if len(idle) >= biasWindow {
+			acc, gyr := physical(idle)
+			gyroBias.Update(acc, gyr, idle[len(idle)-1].Tim)
+			idle = idle[:0]
+		}
+		return newData, err
+	})
+	tool := metadata.NewTool("knobID", VERSION)
+	device := metadata.NewDevice()
+	samplingMode := "direct"
+	if stream != nil {
+		samplingMode = "fifo"
+	}
+	var fifoOverflows, magOverflows int //at the presence
 
-				}
-				log.Printf("Stop acquisition, dump data to file")
-				//dump the dataStore on the slice into a file
-				log.Printf("Data store size: %d (of %d)", len(dataStore), cap(dataStore))
-				log.Printf("Data acquisition rate: %d Hz", int(1000000.0*float32(len(dataStore))/float32(dataStore[len(dataStore)-1].Tim.Sub(time0)/time.Microsecond)))
-				//Create and open file
-				dataFileName = fmt.Sprintf("%s%s_%02d%s", filepath.Join(dataFilePath, acquisitionName), acquisitionConf, acquisitionNum, DATAFILE_EXTENSION)
-				//Create and Close if not exists
-				if _, err := os.Stat(dataFileName); os.IsNotExist(err) {
-					log.Printf("Creating %s\n", dataFileName)
-					dataFile, _ = os.Create(dataFileName)
-					//change user to pi, not root
-					//_ = os.Chown(dataFileName, os.Geteuid(), os.Getegid())
-					dataFile.Close()
-				}
-				//open file
-				log.Printf("Opennign %s\n", dataFileName)
-				dataFile, err := os.OpenFile(dataFileName, os.O_RDWR|os.O_APPEND, 0666)
-				if err != nil {
-					log.Println(err.Error())
-				}
+	//hand a capture to the writer, what was taken along with it is dropped
+	//with it
+	write := func(c *acquisition.Capture[TimAccGyr]) error {
+		if session != nil {
+			session.Handed()
+		}
+		err := writer.Write(c)
+		if err == acquisition.ErrQueueFull {
+			if session != nil {
+				session.Written(err)
+			}
+			metaMu.Lock()
+			delete(metas, c.Num)
+			metaMu.Unlock()
+			biasMu.Lock()
+			delete(biases, c.Num)
+			biasMu.Unlock()
+		}
+		return err
+	}
 
-				headLine := ""
-				if !noHead {
-					//headding line
-					headLine = headLine + "##########\n"
-					headLine = headLine + fmt.Sprintf("# %v Data Acquisition\n", time.Now())
-					headLine = headLine + fmt.Sprintf("# Acquisition name: %s\n", acquisitionName)
-					headLine = headLine + fmt.Sprintf("# Acquisition num: %d\n", acquisitionNum)
-					headLine = headLine + fmt.Sprintf("# Accelerometer full scale: %d (%d)\n", accFS, int(accFSMAX))
-					headLine = headLine + fmt.Sprintf("# Gyroscope full scale: %d (%d)\n", gyrFS, int(gyrFSMAX))
-					headLine = headLine + "##########\n"
+	//the captures go to the writer along with their metadata
+	sink := acquisition.SinkFunc[TimAccGyr](func(c *acquisition.Capture[TimAccGyr]) error {
+		bias := metadata.GyroBias{
+			Bias:       gyroBias.Bias,
+			Windows:    gyroBias.Windows,
+			Updated:    gyroBias.Updated,
+			Subtracted: subtractGyro && gyroBias.Windows > 0,
+		}
+		if bias.Subtracted {
+			counts := gyroBias.Counts(gyrFSMAX)
+			for _, part := range [][]TimAccGyr{c.Pre, c.Data, c.Post} {
+				for i := range part {
+					part[i].Gyr = subtractBias(part[i].Gyr, counts)
 				}
-				headLine = headLine + fmt.Sprintf("num; time(us); accX(g); accY(g); accZ(g); gyrX(o/s); gyrY(o/s); gyrZ(o/s);p\n")
-				dataFile.WriteString(headLine) //write headding line in the file
-				if margin > 0 {
-					firstValue = (lastValue + 1) % margin
-					//log.Printf("margin: %d, firstValue: %d, lastValue: %d", margin, firstValue, lastValue)
-					shiftTime = preDataStore[firstValue].Tim //shitt time to the beginning of pre
-					//log.Printf("shiftTime: %d", shiftTime)
-					for i := 1; i <= margin; i++ {
-						led.Toggle() //indicate transferring state with led
-						preValue = preDataStore[firstValue]
-						firstValue = (firstValue + 1) % margin
-						dataString := fmt.Sprintf("%d;%d;%f;%f;%f;%f;%f;%f;%d\n",
-							i,
-							int64(preValue.Tim.Sub(shiftTime)/time.Microsecond),
-							float64(preValue.Acc.X)/accFSMAX,
-							float64(preValue.Acc.Y)/accFSMAX,
-							float64(preValue.Acc.Z)/accFSMAX,
-							float64(preValue.Gyr.X)/gyrFSMAX,
-							float64(preValue.Gyr.Y)/gyrFSMAX,
-							float64(preValue.Gyr.Z)/gyrFSMAX,
-							0)
-						dataFile.WriteString(dataString) //write data in the file
+			}
+		}
+		biasMu.Lock()
+		biases[c.Num] = bias
+		biasMu.Unlock()
+		if !writeMeta {
+			return write(c)
+		}
+		meta := &metadata.Metadata{
+			Format:  metadata.FORMAT,
+			Name:    acquisitionName,
+			Num:     c.Num,
+			Tool:    tool,
+			Device:  device,
+			Sensors: sensors,
+			Margin:  metadata.Margin{Samples: margin, Millis: marginMs, Pre: len(c.Pre), Post: len(c.Post)},
+			Presence: metadata.Presence{
+				Sensor:     presenceSensor,
+				DebounceMs: debounceMs,
+			},
+			Trigger: metadata.Trigger{Start: c.Start, End: c.End, Samples: len(c.Data)},
+			Sampling: metadata.Sampling{
+				Mode:            samplingMode,
+				Nominal:         sampleRate,
+				CapturesDropped: writer.Dropped(),
+			},
+		}
+		if marginMs > 0 {
+			meta.Margin.Samples = 0
+		}
+		if profile != nil {
+			meta.Calibration = &metadata.Calibration{Source: calibFile, Offsets: profile.Offsets()}
+		}
+		meta.GyroBias = &bias
+		temp := captureTemp(c)
+		temp.Compensated = thermal != nil
+		meta.Temperature = &temp
+		if presenceSensor != "ir" {
+			meta.Presence.Electrodes, meta.Presence.Thresholds, meta.Presence.AutoConfig = electrodeList, thresholdList, autoCfg
+		}
+		times := make([]time.Time, 0, c.Len())
+		for _, part := range [][]TimAccGyr{c.Pre, c.Data, c.Post} {
+			for _, value := range part {
+				times = append(times, value.Tim)
+			}
+		}
+		if len(times) > 0 {
+			meta.Trigger.FirstSample, meta.Trigger.LastSample = times[0], times[len(times)-1]
+		}
+		effective, missing, maxGap := metadata.Timing(times, samplePeriod)
+		meta.Sampling.Effective = math.Round(10*effective) / 10
+		meta.Sampling.Missing, meta.Sampling.MaxGapUs = missing, maxGap.Microseconds()
+		if stream != nil {
+			meta.Sampling.FIFOOverflows = stream.Overflows - fifoOverflows
+		}
+		if mag != nil {
+			meta.Sampling.MagOverflows = mag.Overflows - magOverflows
+		}
+		metaMu.Lock()
+		metas[c.Num] = meta
+		metaMu.Unlock()
+		return write(c)
+	})
+	if marginMs > 0 {
+		stamp := func(s TimAccGyr) time.Time { return s.Tim }
+		machine = acquisition.NewDuration[TimAccGyr](source, sink, time.Duration(marginMs)*time.Millisecond, stamp)
+	} else {
+		machine = acquisition.New[TimAccGyr](source, sink, margin)
+	}
+	if session != nil {
+		machine.SetNum(session.First)
+	}
 
-					}
+	//stop on ctrl-c or kill, so the pending captures are written, or once
+	//the repetitions of an enrollment are taken
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	stopAll := func() { stopOnce.Do(func() { close(stop) }) }
+	machine.Notify = func(tr acquisition.Transition) {
+		switch tr.To {
+		case acquisition.CAPTURING:
+			leds.Capture(true)
+			if tr.From == acquisition.PRE_TRIGGER {
+				log.Println("Presence detected, begin acquisition")
+				if stream != nil {
+					fifoOverflows = stream.Overflows
 				}
-				//write dataStore to the file
-				for i, value := range dataStore {
-					led.Toggle() //indicate transferring state with led
-					dataString := fmt.Sprintf("%d;%d;%f;%f;%f;%f;%f;%f;%d\n",
-						margin+i+1, //continue the num from the pre-margin count
-						int64(value.Tim.Sub(shiftTime)/time.Microsecond),
-						float64(value.Acc.X)/accFSMAX,
-						float64(value.Acc.Y)/accFSMAX,
-						float64(value.Acc.Z)/accFSMAX,
-						float64(value.Gyr.X)/gyrFSMAX,
-						float64(value.Gyr.Y)/gyrFSMAX,
-						float64(value.Gyr.Z)/gyrFSMAX,
-						func(index int) int {
-							if index < len(dataStore)-margin {
-								return 1
-							} else {
-								return 0
-							}
-						}(i))
-
-					dataFile.WriteString(dataString) //write data in the file
+				if mag != nil {
+					magOverflows = mag.Overflows
 				}
-				led.Write(gpio.LOW)
-				dataFile.Close()
-				log.Printf("Closed %s\n", dataFileName)
-
-				acquisitionNum++ //increase num of acquisitions for the next time
-				//initialize the slices to prepare it for new data
-				preDataStore = make([]TimAccGyr, PRE_DATA_CAP)
-				lastValue = -1
-				dataStore = make([]TimAccGyr, 0, DATA_CAP)
-				//log.Printf("New data store size: %d (of %d)", len(dataStore), cap(dataStore))
-				log.Printf("Ready for new acquisition")
-				log.Println("Entering pre-acquisition mode...")
-
-			} else { //while no presence detected prefech data and store in prebuf[]
-
-				if margin > 0 {
-					//read the acc and gyro data in one step without err consideration
-					_, _ = mpu.i2c.Write([]byte{MPU9250_REG_ACCEL_XOUT_H})
-					_, _ = mpu.i2c.Read(buf)
-					//time of readdings
-					preThisData.Tim = time.Now() // .Sub(time0) substract time0 when dump to the file
-					//data of readdings
-					ax = uint16(buf[0])<<8 | uint16(buf[1])
-					if ax > 32767 {
-						ax = ^ax + 0x01
-						preThisData.Acc.X = -1 * int16(ax)
-					} else {
-						preThisData.Acc.X = int16(ax)
-					}
-
-					ay = uint16(buf[2])<<8 | uint16(buf[3])
-					if ay > 32767 {
-						ay = ^ay + 0x01
-						preThisData.Acc.Y = -1 * int16(ay)
-					} else {
-						preThisData.Acc.Y = int16(ay)
-					}
-
-					az = uint16(buf[4])<<8 | uint16(buf[5])
-					if az > 32767 {
-						az = ^az + 0x01
-						preThisData.Acc.Z = -1 * int16(az)
-					} else {
-						preThisData.Acc.Z = int16(az)
-					}
-
-					gx = uint16(buf[8])<<8 | uint16(buf[9])
-					if gx > 32767 {
-						gx = ^gx + 0x01
-						preThisData.Gyr.X = -1 * int16(gx)
-					} else {
-						preThisData.Gyr.X = int16(gx)
-					}
-
-					gy = uint16(buf[10])<<8 | uint16(buf[11])
-					if gy > 32767 {
-						gy = ^gy + 0x01
-						preThisData.Gyr.Y = -1 * int16(gy)
-					} else {
-						preThisData.Gyr.Y = int16(gy)
-					}
-
-					gz = uint16(buf[12])<<8 | uint16(buf[13])
-					if gz > 32767 {
-						gz = ^gz + 0x01
-						preThisData.Gyr.Z = -1 * int16(gz)
-					} else {
-						preThisData.Gyr.Z = int16(gz)
+			}
+		case acquisition.POST_MARGIN:
+			leds.Capture(false)
+			log.Println("Absence detected, stop acquisition")
+			log.Println("Doing post-acquisition")
+		case acquisition.FLUSHING:
+			log.Printf("Stop acquisition %d, hand data to the writer", tr.Num)
+			if pending := writer.Pending(); pending > 0 {
+				log.Printf("Warning: writer late, %d captures pending (of %d)", pending, WRITER_QUEUE)
+			}
+			if mag != nil && mag.Overflows > 0 {
+				log.Printf("Warning: %d magnetometer overflows so far", mag.Overflows)
+			}
+			if knob.CapErrors > 0 {
+				log.Printf("Warning: %d electrode read errors so far, the previous values kept", knob.CapErrors)
+			}
+		case acquisition.PRE_TRIGGER:
+			leds.Capture(false)
+			if session != nil && tr.From == acquisition.FLUSHING {
+				if session.Last() {
+					//complete only once the last repetitions are written
+					session.Wait()
+					if session.Complete() {
+						log.Printf("Enrollment of %s complete, %d repetitions taken", session.Subject.ID, session.Done())
+						leds.Show(blinks(ENROLL_DONE_BLINKS))
+						stopAll()
+						return
 					}
-
-					lastValue = (lastValue + 1) % margin
-					//log.Printf("lastValue: %d", lastValue)
-					preDataStore[lastValue] = preThisData
 				}
+				leds.Show(blinks(ENROLL_NEXT_BLINKS))
+				log.Printf("%s, release the knob and touch it again", session.Progress(tr.Num+1))
 			}
+			log.Printf("Ready for new acquisition")
+			log.Println("Entering pre-acquisition mode...")
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		stopAll()
+	}()
+
+	if session != nil {
+		log.Printf("%s, touch the knob", session.Progress(session.First))
+	}
+	log.Println("Entering pre-acquisition mode...")
+	for {
+		err := machine.Run(events, ticker.C, stop)
+		if err == acquisition.ErrQueueFull {
+			log.Printf("Warning: %v (%d so far)", err, writer.Dropped())
+			continue
+		}
+		if err != nil {
+			log.Printf("Error: %v", err)
+			continue
 		}
-		presenceBefore = presenceNow
+		break
 	}
 	//END
 }
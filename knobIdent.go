@@ -0,0 +1,144 @@
+// knobIdent trains an identification model on the captures of the enrolled
+// subjects, and identifies the subject of new captures with it:
+//
+//	go run knobIdent.go -train -model knn.json -session 170131 -conf a8w1000
+//	go run knobIdent.go -train -model dtw.json -kind dtw -manifest dataset/manifest.json
+//	go run knobIdent.go -model knn.json -top 3 data/170131/i004a8w1k_9.csv
+//
+// Every acquisition of the files given is identified, the subjects are
+// printed best first with their score. The model file holds the score
+// under which a subject is unknown, for knobID: the EER threshold of a
+// cross-validation with -folds on the training captures, as knobEval
+// -protocol kfold finds it, or -threshold.
+
+package main
+
+import (
+	"./dataset"
+	"./eval"
+	"./ident"
+	"./knobcsv"
+	"flag"
+	"fmt"
+	"log"
+)
+
+func checkError(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// calibrate returns the EER threshold of a cross-validation of the model
+// on the examples, 0 without genuine or impostor scores (one subject).
+func calibrate(kind string, k int, examples []ident.Example, folds int, seed int64) (float64, error) {
+	splits, err := eval.KFold(examples, folds, seed)
+	if err != nil {
+		return 0, err
+	}
+	newModel := func() (ident.Model, error) {
+		return ident.NewK(kind, k)
+	}
+	foldResults, predictions, err := eval.Run(newModel, examples, splits)
+	if err != nil {
+		return 0, err
+	}
+	result := eval.Summarise(eval.K_FOLD, kind, foldResults, predictions)
+	log.Printf("Cross-validation of %d folds: accuracy %.3f, EER %.3f at %.3f", folds, result.Accuracy, result.EER, result.EERScore)
+	return result.EERScore, nil
+}
+
+func main() {
+	var (
+		train     bool
+		modelFile string
+		kind      string
+		k         int
+		dataDir   string
+		manifest  string
+		subject   string
+		session   string
+		conf      string
+		top       int
+		folds     int
+		seed      int64
+		threshold float64
+	)
+	flag.BoolVar(&train, "train", false, "Train the model instead of identifying the files")
+	flag.StringVar(&modelFile, "model", "model.json", "Model file")
+	flag.StringVar(&kind, "kind", ident.KIND_KNN, "Model to train (knn, dtw)")
+	flag.IntVar(&k, "k", 0, "Closest captures scoring a subject, the default of the model if 0")
+	flag.StringVar(&dataDir, "data", "data", "Root folder of the acquisition files")
+	flag.StringVar(&manifest, "manifest", "", "Manifest of the training files (knobIndex), the data folder is indexed if empty")
+	flag.StringVar(&subject, "subject", "", "Only train with the files of a subject (i001)")
+	flag.StringVar(&session, "session", "", "Only train with the files of a session folder (170131)")
+	flag.StringVar(&conf, "conf", "", "Only train with the files of a configuration (a8w250)")
+	flag.IntVar(&top, "top", 5, "Subjects printed per capture, all if 0")
+	flag.IntVar(&folds, "folds", 5, "Folds of the cross-validation calibrating the threshold of the model, none if 0")
+	flag.Int64Var(&seed, "seed", 1, "Seed of the cross-validation shuffle")
+	flag.Float64Var(&threshold, "threshold", 0, "Score under which the subject is unknown, stored in the model instead of the calibrated one")
+	flag.Parse()
+
+	if train {
+		var m dataset.Manifest
+		var err error
+		if manifest != "" {
+			m, err = dataset.Load(manifest)
+		} else {
+			m, err = dataset.Index(dataDir)
+		}
+		checkError(err)
+		m = m.Filter(subject, session, conf)
+		examples, err := ident.Examples(dataDir, m)
+		if skipped, ok := err.(ident.SkipList); ok {
+			for _, fe := range skipped {
+				log.Printf("Error reading %s: %v", fe.Path, fe.Err)
+			}
+			log.Printf("%d files skipped", len(skipped))
+		} else {
+			checkError(err)
+		}
+
+		model, err := ident.NewK(kind, k)
+		checkError(err)
+		checkError(model.Train(examples))
+		if threshold == 0 && folds > 0 {
+			threshold, err = calibrate(kind, k, examples, folds, seed)
+			checkError(err)
+		}
+		if threshold == 0 {
+			log.Printf("Warning: no threshold in the model, knobID needs -threshold")
+		}
+		checkError(ident.SaveFile(modelFile, model, threshold))
+		log.Printf("%s model of %d subjects trained with %d captures, unknown under %.3f, saved in %s", kind, len(model.Subjects()), len(examples), threshold, modelFile)
+		return
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("No file to identify")
+	}
+	model, _, err := ident.LoadFile(modelFile)
+	checkError(err)
+	for _, file := range flag.Args() {
+		acqs, err := knobcsv.ReadFile(file)
+		if err != nil {
+			log.Printf("Error reading %s: %v", file, err)
+			continue
+		}
+		for i, a := range acqs {
+			matches, err := model.Rank(a.Samples)
+			if err != nil {
+				log.Printf("Error identifying %s (%d): %v", file, i, err)
+				continue
+			}
+			if top > 0 && len(matches) > top {
+				matches = matches[:top]
+			}
+			fmt.Printf("%s\t%d", file, i)
+			for _, m := range matches {
+				fmt.Printf("\t%s %.3f", m.Subject, m.Score)
+			}
+			fmt.Println()
+		}
+	}
+}
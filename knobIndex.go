@@ -0,0 +1,88 @@
+// knobIndex walks the acquisition files and writes the manifest of the
+// dataset: subject, session, full scales and repetition of every file, with
+// the inconsistencies between the file names, the headers and the data.
+//
+//	go run knobIndex.go -data data -o dataset/manifest.json
+//	go run knobIndex.go -subject i001 -conf a8w250 -o i001.csv
+
+package main
+
+import (
+	"./dataset"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func checkError(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	var (
+		dataDir string
+		output  string
+		format  string
+		subject string
+		session string
+		conf    string
+		issues  bool
+	)
+	flag.StringVar(&dataDir, "data", "data", "Root folder of the acquisition files")
+	flag.StringVar(&output, "o", "", "Manifest file, standard output if empty")
+	flag.StringVar(&format, "format", "", "Manifest format (json, csv), from the extension of -o by default")
+	flag.StringVar(&subject, "subject", "", "Only the files of a subject (i001)")
+	flag.StringVar(&session, "session", "", "Only the files of a session folder (170131)")
+	flag.StringVar(&conf, "conf", "", "Only the files of a configuration (a8w250)")
+	flag.BoolVar(&issues, "issues", false, "Only the files with inconsistencies")
+	flag.Parse()
+
+	if subject != "" {
+		s, err := dataset.Subject(subject)
+		checkError(err)
+		subject = s
+	}
+	if format == "" {
+		format = "json"
+		if strings.EqualFold(filepath.Ext(output), ".csv") {
+			format = "csv"
+		}
+	}
+	if format != "json" && format != "csv" {
+		log.Fatalf("Invalid format %q", format)
+	}
+
+	m, err := dataset.Index(dataDir)
+	checkError(err)
+	log.Printf("%d files, subjects %v, sessions %v, configurations %v", len(m), m.Subjects(), m.Sessions(), m.Confs())
+	flagged := m.Select(func(e *dataset.Entry) bool { return len(e.Issues) > 0 })
+	for _, e := range flagged {
+		log.Printf("%s: %s", e.Path, strings.Join(e.Issues, "; "))
+	}
+	log.Printf("%d files with inconsistencies", len(flagged))
+
+	m = m.Filter(subject, session, conf)
+	if issues {
+		m = m.Select(func(e *dataset.Entry) bool { return len(e.Issues) > 0 })
+	}
+
+	out := os.Stdout
+	if output != "" {
+		out, err = os.Create(output)
+		checkError(err)
+	}
+	if format == "csv" {
+		err = m.WriteCSV(out)
+	} else {
+		err = m.WriteJSON(out)
+	}
+	checkError(err)
+	if output != "" {
+		checkError(out.Close())
+		log.Printf("%d entries written to %s", len(m), output)
+	}
+}
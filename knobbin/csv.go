@@ -0,0 +1,134 @@
+package knobbin
+
+import (
+	"../knobcsv"
+	"../mpu9250"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Tolerance when turning CSV values back into counts, in counts, on top of
+// the rounding of the 6 decimals of the CSV files.
+const COUNT_TOLERANCE = 0.01
+
+// Count of a value of a channel, if it fits an int16 and, when exact, the
+// value is a whole number of counts.
+func count(v float64, c Channel, exact bool) (int16, bool) {
+	x := (v - c.Offset) / c.Scale
+	r := math.Round(x)
+	if r < math.MinInt16 || r > math.MaxInt16 {
+		return 0, false
+	}
+	if exact && math.Abs(x-r) > COUNT_TOLERANCE+0.5e-6/c.Scale {
+		return 0, false
+	}
+	return int16(r), true
+}
+
+// Scale and offset of the extra CSV columns. The counts of the MPR121 are
+// integers, the magnetometer is rounded to MAG_SCALE and the temperature,
+// rounded to 0.01 oC in the CSV files, to the nearest count of the MPU9250.
+func extraScale(column string) (scale, offset float64, exact bool) {
+	if strings.HasSuffix(column, "(uT)") {
+		return MAG_SCALE, 0, false
+	}
+	if strings.HasSuffix(column, "(oC)") {
+		return 1 / mpu9250.TEMP_SENSITIVITY, mpu9250.TempCelsius(0), false
+	}
+	return 1, 0, true
+}
+
+// FromCSV converts a CSV acquisition, the accelerometer and gyroscope
+// values must be whole counts at the given sensitivities, in LSB/g and
+// LSB/(o/s). The header time of the CSV file, when the capture was
+// written, becomes the start.
+func FromCSV(c *knobcsv.Acquisition, accFS int, accSens float64, gyrFS int, gyrSens float64) (*Acquisition, error) {
+	h := &c.Header
+	a := New(Header{
+		Name:    h.Name,
+		Num:     h.Num,
+		Start:   h.Time,
+		AccFS:   accFS,
+		AccSens: accSens,
+		GyrFS:   gyrFS,
+		GyrSens: gyrSens,
+		Fields:  h.Fields,
+	})
+	exact := []bool{true, true, true, true, true, true}
+	for _, col := range h.Extra {
+		scale, offset, ex := extraScale(col)
+		a.AddChannel(col, scale, offset)
+		exact = append(exact, ex)
+	}
+	if n := len(c.Samples); n > 1 {
+		if d := c.Samples[n-1].Time - c.Samples[0].Time; d > 0 {
+			a.Rate = math.Round(float64(n-1) / d.Seconds())
+		}
+	}
+	counts := make([]int16, len(a.Channels))
+	for i, s := range c.Samples {
+		values := append(append(s.Acc[:], s.Gyr[:]...), s.Extra...)
+		for ch, v := range values {
+			cnt, ok := count(v, a.Channels[ch], exact[ch])
+			if !ok {
+				return nil, fmt.Errorf("knobbin: sample %d: %s %f is not an int16 count of %g", s.Num, a.Channels[ch].Name, v, a.Channels[ch].Scale)
+			}
+			counts[ch] = cnt
+		}
+		if s.Num != i+1 {
+			return nil, fmt.Errorf("knobbin: sample %d numbered %d", i+1, s.Num)
+		}
+		a.Append(s.Time, s.Present, counts...)
+	}
+	return a, nil
+}
+
+// CSV converts the acquisition back to the CSV layout of knobID, with the
+// times in microseconds from the first sample.
+func (a *Acquisition) CSV() (*knobcsv.Acquisition, error) {
+	if len(a.Channels) < len(ACC_CHANNELS)+len(GYR_CHANNELS) {
+		return nil, fmt.Errorf("knobbin: %d channels, no accelerometer and gyroscope", len(a.Channels))
+	}
+	h := knobcsv.Header{
+		Time:    a.Start,
+		Name:    a.Name,
+		Num:     a.Num,
+		HasNum:  a.Name != "",
+		AccFS:   a.AccFS,
+		AccSens: a.AccSens,
+		GyrFS:   a.GyrFS,
+		GyrSens: a.GyrSens,
+		Fields:  a.Fields,
+		HasP:    true,
+	}
+	for _, c := range a.Channels {
+		h.Columns = append(h.Columns, c.Name)
+	}
+	h.Extra = h.Columns[len(ACC_CHANNELS)+len(GYR_CHANNELS):]
+	h.Columns = append(append(append([]string{}, knobcsv.COLUMNS...), h.Extra...), knobcsv.PRESENCE_COLUMN)
+
+	c := &knobcsv.Acquisition{Header: h, Samples: make([]knobcsv.Sample, a.Len())}
+	var first time.Duration
+	if a.Len() > 0 {
+		first = a.Time[0]
+	}
+	for i := range c.Samples {
+		s := &c.Samples[i]
+		s.Num = i + 1
+		s.Time = (a.Time[i] - first).Truncate(time.Microsecond)
+		s.Present = a.Present[i]
+		for j := 0; j < 3; j++ {
+			s.Acc[j] = float64(a.Data[j][i]) / a.AccSens
+			s.Gyr[j] = float64(a.Data[3+j][i]) / a.GyrSens
+		}
+		if len(h.Extra) > 0 {
+			s.Extra = make([]float64, len(h.Extra))
+			for j := range s.Extra {
+				s.Extra[j] = a.Value(6+j, i)
+			}
+		}
+	}
+	return c, nil
+}
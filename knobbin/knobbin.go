@@ -0,0 +1,432 @@
+// Package knobbin is the compact binary format of the acquisitions: the raw
+// int16 counts of the sensors, column after column, with nanosecond
+// timestamps, so the data is stored exactly and in about a third of the
+// size of the CSV files.
+//
+// A file is a sequence of records, one per acquisition, all little endian:
+//
+//	magic    "KNB\x00"
+//	version  uint16
+//	header   name, num, start time and zone, rate, full scales, fields, channels
+//	n        uint32, number of samples
+//	times    n varints, ns from the start then from the previous sample
+//	presence (n+7)/8 bytes, bit i%8 of byte i/8 for sample i
+//	channels n int16 per channel, one channel after the other
+//
+// Strings are a uvarint length followed by the bytes. Records are appended
+// the same way knobID appends captures to the CSV files.
+package knobbin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// Magic of every record.
+const MAGIC = "KNB\x00"
+
+// Version written, the reader accepts this one and the previous ones.
+const VERSION uint16 = 1
+
+// Extension of the binary acquisition files.
+const EXTENSION = ".knb"
+
+// Names of the channels of the accelerometer and gyroscope, as the CSV
+// columns, always the first six.
+var (
+	ACC_CHANNELS = []string{"accX(g)", "accY(g)", "accZ(g)"}
+	GYR_CHANNELS = []string{"gyrX(o/s)", "gyrY(o/s)", "gyrZ(o/s)"}
+)
+
+// Scale of the magnetometer channels converted from CSV files, uT. The CSV
+// columns are adjusted by the AK8963 sensitivity adjustment, which is not
+// recorded, so they are rounded to 0.1 uT (its LSB is 0.15 uT) for a range
+// of 3276 uT. knobID writes the counts of the AK8963 instead.
+const MAG_SCALE = 0.1
+
+// ErrFormat is returned for data that is not a knobbin record.
+var ErrFormat = errors.New("knobbin: invalid format")
+
+// Channel is a column of raw counts, the value is Offset plus Scale times
+// the count.
+type Channel struct {
+	Name   string
+	Scale  float64
+	Offset float64
+}
+
+// Header of an acquisition.
+type Header struct {
+	Version uint16 // of the record read, VERSION when writing
+	Name    string
+	Num     int
+	Start   time.Time // the times are relative to it, zero if unknown
+	Rate    float64   // nominal Hz, 0 if unknown
+	AccFS   int       // g
+	AccSens float64   // LSB/g
+	GyrFS   int       // o/s
+	GyrSens float64   // LSB/(o/s)
+	Fields  map[string]string
+	// Channels, the accelerometer and gyroscope ones first
+	Channels []Channel
+}
+
+// Acquisition is a header with its columns.
+type Acquisition struct {
+	Header
+	Time    []time.Duration // from Start
+	Present []bool
+	Data    [][]int16 // one column per channel
+}
+
+// New returns an empty acquisition with the accelerometer and gyroscope
+// channels, for the given sensitivities in LSB/g and LSB/(o/s).
+func New(h Header) *Acquisition {
+	if len(h.Channels) == 0 {
+		for _, name := range ACC_CHANNELS {
+			h.Channels = append(h.Channels, Channel{Name: name, Scale: 1 / h.AccSens})
+		}
+		for _, name := range GYR_CHANNELS {
+			h.Channels = append(h.Channels, Channel{Name: name, Scale: 1 / h.GyrSens})
+		}
+	}
+	return &Acquisition{Header: h, Data: make([][]int16, len(h.Channels))}
+}
+
+// AddChannel adds an extra channel, it must be done before the first
+// sample is appended.
+func (a *Acquisition) AddChannel(name string, scale, offset float64) {
+	a.Channels = append(a.Channels, Channel{name, scale, offset})
+	a.Data = append(a.Data, nil)
+}
+
+// Append adds a sample, with one count per channel.
+func (a *Acquisition) Append(t time.Duration, present bool, counts ...int16) {
+	if len(counts) != len(a.Channels) {
+		panic(fmt.Sprintf("knobbin: %d counts for %d channels", len(counts), len(a.Channels)))
+	}
+	a.Time = append(a.Time, t)
+	a.Present = append(a.Present, present)
+	for i, c := range counts {
+		a.Data[i] = append(a.Data[i], c)
+	}
+}
+
+// Len is the number of samples.
+func (a *Acquisition) Len() int {
+	return len(a.Time)
+}
+
+// Value is the i-th sample of a channel, in the unit of the channel.
+func (a *Acquisition) Value(ch, i int) float64 {
+	c := a.Channels[ch]
+	return c.Offset + float64(a.Data[ch][i])*c.Scale
+}
+
+// Channel returns the index of a channel, or -1.
+func (h *Header) Channel(name string) int {
+	for i, c := range h.Channels {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Encoding helpers, the errors of a bytes.Buffer are always nil.
+
+func putUvarint(b *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	b.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+func putVarint(b *bytes.Buffer, v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	b.Write(tmp[:binary.PutVarint(tmp[:], v)])
+}
+
+func putString(b *bytes.Buffer, s string) {
+	putUvarint(b, uint64(len(s)))
+	b.WriteString(s)
+}
+
+func putFloat(b *bytes.Buffer, f float64) {
+	binary.Write(b, binary.LittleEndian, math.Float64bits(f))
+}
+
+// Write writes an acquisition as one record.
+func Write(w io.Writer, a *Acquisition) error {
+	n := a.Len()
+	if len(a.Present) != n || len(a.Data) != len(a.Channels) {
+		return fmt.Errorf("knobbin: inconsistent acquisition")
+	}
+	for i, col := range a.Data {
+		if len(col) != n {
+			return fmt.Errorf("knobbin: channel %s has %d samples, %d expected", a.Channels[i].Name, len(col), n)
+		}
+	}
+	var b bytes.Buffer
+	b.WriteString(MAGIC)
+	binary.Write(&b, binary.LittleEndian, VERSION)
+
+	putString(&b, a.Name)
+	putVarint(&b, int64(a.Num))
+	start := int64(0) //unknown
+	if !a.Start.IsZero() {
+		start = a.Start.UnixNano()
+	}
+	putVarint(&b, start)
+	zone, offset := a.Start.Zone()
+	putString(&b, zone)
+	putVarint(&b, int64(offset))
+	putFloat(&b, a.Rate)
+	putUvarint(&b, uint64(a.AccFS))
+	putFloat(&b, a.AccSens)
+	putUvarint(&b, uint64(a.GyrFS))
+	putFloat(&b, a.GyrSens)
+	keys := make([]string, 0, len(a.Fields))
+	for k := range a.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	putUvarint(&b, uint64(len(keys)))
+	for _, k := range keys {
+		putString(&b, k)
+		putString(&b, a.Fields[k])
+	}
+	putUvarint(&b, uint64(len(a.Channels)))
+	for _, c := range a.Channels {
+		putString(&b, c.Name)
+		putFloat(&b, c.Scale)
+		putFloat(&b, c.Offset)
+	}
+
+	binary.Write(&b, binary.LittleEndian, uint32(n))
+	prev := time.Duration(0)
+	for _, t := range a.Time {
+		putVarint(&b, int64(t-prev))
+		prev = t
+	}
+	bits := make([]byte, (n+7)/8)
+	for i, p := range a.Present {
+		if p {
+			bits[i/8] |= 1 << uint(i%8)
+		}
+	}
+	b.Write(bits)
+	for _, col := range a.Data {
+		binary.Write(&b, binary.LittleEndian, col)
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// Decoding, the first error sticks.
+type decoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+	}
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d.r)
+	d.fail(err)
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(d.r)
+	d.fail(err)
+	return v
+}
+
+// Longest string accepted, to fail on garbage instead of allocating it.
+const MAX_STRING = 1 << 16
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if n > MAX_STRING {
+		d.fail(ErrFormat)
+		return ""
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(d.r, buf)
+	d.fail(err)
+	return string(buf)
+}
+
+func (d *decoder) read(v interface{}) {
+	if d.err != nil {
+		return
+	}
+	d.fail(binary.Read(d.r, binary.LittleEndian, v))
+}
+
+func (d *decoder) float() float64 {
+	var bits uint64
+	d.read(&bits)
+	return math.Float64frombits(bits)
+}
+
+// Most samples and channels accepted in a record.
+const (
+	MAX_SAMPLES  = 1 << 24
+	MAX_CHANNELS = 1 << 10
+)
+
+// Reader reads the records of a file one after the other.
+type Reader struct {
+	d decoder
+}
+
+// NewReader returns a reader of the records of r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{d: decoder{r: bufio.NewReader(r)}}
+}
+
+// Read returns the next acquisition, or io.EOF after the last one.
+func (rd *Reader) Read() (*Acquisition, error) {
+	d := &rd.d
+	if d.err != nil {
+		return nil, d.err
+	}
+	var magic [len(MAGIC)]byte
+	if _, err := io.ReadFull(d.r, magic[:]); err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		d.fail(err)
+		return nil, d.err
+	}
+	if string(magic[:]) != MAGIC {
+		d.fail(ErrFormat)
+		return nil, d.err
+	}
+	var h Header
+	d.read(&h.Version)
+	if d.err == nil && (h.Version == 0 || h.Version > VERSION) {
+		d.fail(fmt.Errorf("knobbin: unsupported version %d", h.Version))
+	}
+	h.Name = d.string()
+	h.Num = int(d.varint())
+	start := d.varint()
+	zone := d.string()
+	offset := int(d.varint())
+	if start != 0 {
+		h.Start = time.Unix(0, start).In(time.FixedZone(zone, offset))
+	}
+	h.Rate = d.float()
+	h.AccFS = int(d.uvarint())
+	h.AccSens = d.float()
+	h.GyrFS = int(d.uvarint())
+	h.GyrSens = d.float()
+	nf := d.uvarint()
+	if nf > 0 && d.err == nil {
+		h.Fields = make(map[string]string)
+	}
+	for i := uint64(0); i < nf && d.err == nil; i++ {
+		k := d.string()
+		h.Fields[k] = d.string()
+	}
+	nc := d.uvarint()
+	if nc > MAX_CHANNELS {
+		d.fail(ErrFormat)
+	}
+	for i := uint64(0); i < nc && d.err == nil; i++ {
+		h.Channels = append(h.Channels, Channel{Name: d.string(), Scale: d.float(), Offset: d.float()})
+	}
+	var n uint32
+	d.read(&n)
+	if n > MAX_SAMPLES {
+		d.fail(ErrFormat)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	a := &Acquisition{Header: h, Time: make([]time.Duration, n), Present: make([]bool, n), Data: make([][]int16, len(h.Channels))}
+	t := time.Duration(0)
+	for i := range a.Time {
+		t += time.Duration(d.varint())
+		a.Time[i] = t
+	}
+	bits := make([]byte, (n+7)/8)
+	d.read(bits)
+	for i := range a.Present {
+		a.Present[i] = bits[i/8]&(1<<uint(i%8)) != 0
+	}
+	for i := range a.Data {
+		a.Data[i] = make([]int16, n)
+		d.read(a.Data[i])
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return a, nil
+}
+
+// ReadAll reads all the acquisitions of r.
+func ReadAll(r io.Reader) ([]*Acquisition, error) {
+	rd := NewReader(r)
+	var acqs []*Acquisition
+	for {
+		a, err := rd.Read()
+		if err == io.EOF {
+			return acqs, nil
+		}
+		if err != nil {
+			return acqs, err
+		}
+		acqs = append(acqs, a)
+	}
+}
+
+// ReadFile reads all the acquisitions of a file.
+func ReadFile(name string) ([]*Acquisition, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadAll(f)
+}
+
+// AppendFile appends an acquisition to a file, created if needed.
+func AppendFile(name string, a *Acquisition) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	err = Write(f, a)
+	if serr := f.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
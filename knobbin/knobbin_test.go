@@ -0,0 +1,162 @@
+package knobbin
+
+import (
+	"../mpu9250"
+	"bytes"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// acquisition of the accelerometer, gyroscope, magnetometer and temperature
+// counts knobID writes, with n samples at 1 kHz.
+func acquisition(n int) *Acquisition {
+	start := time.Date(2017, 1, 31, 13, 42, 11, 167947000, time.FixedZone("CET", 3600))
+	a := New(Header{
+		Name:    "i001",
+		Num:     3,
+		Start:   start,
+		Rate:    1000,
+		AccFS:   8,
+		AccSens: 4096,
+		GyrFS:   250,
+		GyrSens: 131,
+		Fields:  map[string]string{"Session": "170131"},
+	})
+	//the AK8963 scales, with their sensitivity adjustment
+	a.AddChannel("magX(uT)", 1.08*0.15, 0)
+	a.AddChannel("magY(uT)", 1.1*0.15, 0)
+	a.AddChannel("magZ(uT)", -1.02*0.15, 0)
+	a.AddChannel("temp(oC)", 1/mpu9250.TEMP_SENSITIVITY, mpu9250.TempCelsius(0))
+	for i := 0; i < n; i++ {
+		v := int16(i * 37)
+		a.Append(time.Duration(i)*time.Millisecond+time.Duration(i%3)*time.Microsecond, i%4 < 2,
+			v, -v, 4096, 131*int16(i%5), -7, math.MaxInt16,
+			int16(200-i), int16(-150+2*i), int16(i*i), int16(1200+i))
+	}
+	return a
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := acquisition(50)
+	var b bytes.Buffer
+	for i := 0; i < 2; i++ {
+		if err := Write(&b, want); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	acqs, err := ReadAll(&b)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(acqs) != 2 {
+		t.Fatalf("%d acquisitions, want 2", len(acqs))
+	}
+	got := acqs[1]
+	if got.Version != VERSION {
+		t.Errorf("version %d, want %d", got.Version, VERSION)
+	}
+	got.Version = 0
+	if !got.Start.Equal(want.Start) {
+		t.Errorf("start %v, want %v", got.Start, want.Start)
+	}
+	got.Start = want.Start
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %+v, want %+v", got, want)
+	}
+	temp := got.Channel("temp(oC)")
+	if v := got.Value(temp, 0); math.Abs(v-mpu9250.TempCelsius(1200)) > 1e-9 {
+		t.Errorf("temperature %g, want %g", v, mpu9250.TempCelsius(1200))
+	}
+}
+
+// Through the CSV layout and back: the accelerometer, gyroscope and
+// temperature counts are kept, the magnetometer is rounded to MAG_SCALE.
+func TestCSVRoundTrip(t *testing.T) {
+	want := acquisition(50)
+	var b bytes.Buffer
+	if err := Write(&b, want); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	read, err := NewReader(&b).Read()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	c, err := read.CSV()
+	if err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	if !reflect.DeepEqual(c.Header.Extra, []string{"magX(uT)", "magY(uT)", "magZ(uT)", "temp(oC)"}) || !c.Header.HasP {
+		t.Fatalf("columns %v", c.Header.Columns)
+	}
+	got, err := FromCSV(c, want.AccFS, want.AccSens, want.GyrFS, want.GyrSens)
+	if err != nil {
+		t.Fatalf("from csv: %v", err)
+	}
+	if got.Len() != want.Len() {
+		t.Fatalf("%d samples, want %d", got.Len(), want.Len())
+	}
+	if got.Name != want.Name || got.Num != want.Num || !got.Start.Equal(want.Start) || !reflect.DeepEqual(got.Fields, want.Fields) {
+		t.Errorf("header %+v, want %+v", got.Header, want.Header)
+	}
+	if !reflect.DeepEqual(got.Present, want.Present) {
+		t.Errorf("presence %v, want %v", got.Present, want.Present)
+	}
+	for i := range got.Time {
+		if d := want.Time[i] - want.Time[0]; got.Time[i] != d {
+			t.Fatalf("time %d: %v, want %v", i, got.Time[i], d)
+		}
+	}
+	for ch, wc := range want.Channels {
+		gc := got.Channels[ch]
+		if gc.Name != wc.Name {
+			t.Fatalf("channel %d: %s, want %s", ch, gc.Name, wc.Name)
+		}
+		mag := ch >= 6 && ch < 9
+		if !mag && (gc.Scale != wc.Scale || gc.Offset != wc.Offset || !reflect.DeepEqual(got.Data[ch], want.Data[ch])) {
+			t.Errorf("%s: %v, want %v", wc.Name, got.Data[ch], want.Data[ch])
+		}
+		if mag && gc.Scale != MAG_SCALE {
+			t.Errorf("%s: scale %g, want %g", wc.Name, gc.Scale, MAG_SCALE)
+		}
+		for i := 0; i < want.Len(); i++ {
+			if d := math.Abs(got.Value(ch, i) - want.Value(ch, i)); d > gc.Scale/2+1e-9 {
+				t.Fatalf("%s %d: %g, want %g", wc.Name, i, got.Value(ch, i), want.Value(ch, i))
+			}
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, acquisition(10)); err != nil {
+		t.Fatal(err)
+	}
+	record := b.Bytes()
+	future := append([]byte{}, record...)
+	future[len(MAGIC)] = byte(VERSION + 1)
+	tests := []struct {
+		name string
+		data []byte
+		want error // nil for any error
+	}{
+		{"empty", nil, io.EOF},
+		{"not a record", []byte("CSV\x00\x01\x00"), ErrFormat},
+		{"truncated header", record[:20], io.ErrUnexpectedEOF},
+		{"truncated data", record[:len(record)-1], io.ErrUnexpectedEOF},
+		{"future version", future, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewReader(bytes.NewReader(tt.data)).Read()
+			if err == nil || a != nil {
+				t.Fatalf("read %v %v, want an error", a, err)
+			}
+			if tt.want != nil && err != tt.want {
+				t.Errorf("error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+// Package knobcsv reads the acquisition files written by knobID: an optional
+// block of '#' header lines, a column line and semicolon separated rows
+//
+//	##########
+//	# 2017-01-31 13:42:11.167947041 +0100 CET Data Acquisition
+//	# Acquisition name: i001
+//	# Acquisition num: 0
+//	# Accelerometer full scale: 8 (4096)
+//	# Gyroscope full scale: 250 (131)
+//	##########
+//	num; time(us); accX(g); accY(g); accZ(g); gyrX(o/s); gyrY(o/s); gyrZ(o/s);p
+//	1;0;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;0
+//
+// Files written with -nohd start at the column line, files of calib.go and
+// kn3.go have no p column and newer files have extra columns (magnetometer,
+// electrodes) before it. knobID appends to existing files, so a file may
+// hold several acquisitions, each starting with its header or column line.
+package knobcsv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Columns always present, in this order.
+var COLUMNS = []string{"num", "time(us)", "accX(g)", "accY(g)", "accZ(g)", "gyrX(o/s)", "gyrY(o/s)", "gyrZ(o/s)"}
+
+// Name of the presence column, the last one when present.
+const PRESENCE_COLUMN = "p"
+
+// Layout of the acquisition time, as printed by time.Time.String.
+const TIME_LAYOUT = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// Header of an acquisition. The fields are zero when their line is missing.
+type Header struct {
+	Time     time.Time
+	Name     string
+	Num      int
+	HasNum   bool
+	AccFS    int               // g
+	AccSens  float64           // LSB/g
+	GyrFS    int               // o/s
+	GyrSens  float64           // LSB/(o/s)
+	Fields   map[string]string // other "# key: value" lines
+	Comments []string          // other '#' lines, without the '#'
+	Columns  []string          // as in the column line
+	Extra    []string          // columns between gyrZ and p
+	HasP     bool              // there is a p column
+}
+
+// Column returns the index in Sample.Extra of an extra column, or -1.
+func (h *Header) Column(name string) int {
+	for i, c := range h.Extra {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// One row.
+type Sample struct {
+	Num     int
+	Time    time.Duration // from the first sample
+	Acc     [3]float64    // g
+	Gyr     [3]float64    // o/s
+	Extra   []float64     // as named by Header.Extra
+	Present bool          // p column, false without it
+}
+
+// Acquisition is a header with its rows.
+type Acquisition struct {
+	Header  Header
+	Samples []Sample
+	Line    int // of the header, or of the column line without header
+}
+
+// RowError is a line that could not be parsed.
+type RowError struct {
+	File string
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// ErrorList is the list of the malformed lines of a file.
+type ErrorList []*RowError
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Parse the value of a full scale line, "8 (4096)" or "8 (4096.000000)".
+func parseFS(v string) (int, float64, error) {
+	var fs int
+	var sens float64
+	if _, err := fmt.Sscanf(v, "%d (%g)", &fs, &sens); err != nil {
+		return 0, 0, fmt.Errorf("invalid full scale %q", v)
+	}
+	return fs, sens, nil
+}
+
+// Parse a header line, without the '#'.
+func (h *Header) parseLine(line string) error {
+	line = strings.TrimSpace(line)
+	if strings.HasSuffix(line, " Data Acquisition") {
+		ts := strings.TrimSuffix(line, " Data Acquisition")
+		if i := strings.Index(ts, " m="); i >= 0 { //monotonic clock reading
+			ts = ts[:i]
+		}
+		t, err := time.Parse(TIME_LAYOUT, ts)
+		if err != nil {
+			return fmt.Errorf("invalid acquisition time %q", ts)
+		}
+		h.Time = t
+		return nil
+	}
+	i := strings.Index(line, ":")
+	if i < 0 {
+		h.Comments = append(h.Comments, line)
+		return nil
+	}
+	key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+	var err error
+	switch key {
+	case "Acquisition name":
+		h.Name = value
+	case "Acquisition num":
+		h.Num, err = strconv.Atoi(value)
+		h.HasNum = err == nil
+	case "Accelerometer full scale":
+		h.AccFS, h.AccSens, err = parseFS(value)
+	case "Gyroscope full scale":
+		h.GyrFS, h.GyrSens, err = parseFS(value)
+	default:
+		if h.Fields == nil {
+			h.Fields = make(map[string]string)
+		}
+		h.Fields[key] = value
+	}
+	return err
+}
+
+// Parse the column line.
+func (h *Header) parseColumns(line string) error {
+	var cols []string
+	for _, c := range strings.Split(line, ";") {
+		cols = append(cols, strings.TrimSpace(c))
+	}
+	if len(cols) < len(COLUMNS) {
+		return fmt.Errorf("%d columns, at least %d expected", len(cols), len(COLUMNS))
+	}
+	for i, c := range COLUMNS {
+		if cols[i] != c {
+			return fmt.Errorf("column %d is %q, %q expected", i+1, cols[i], c)
+		}
+	}
+	h.Columns = cols
+	h.Extra = cols[len(COLUMNS):]
+	h.HasP = false
+	if n := len(h.Extra); n > 0 && h.Extra[n-1] == PRESENCE_COLUMN {
+		h.Extra = h.Extra[:n-1]
+		h.HasP = true
+	}
+	return nil
+}
+
+// Parse a row given the columns of the header.
+func (h *Header) parseRow(line string) (Sample, error) {
+	var s Sample
+	fields := strings.Split(line, ";")
+	if len(fields) != len(h.Columns) {
+		return s, fmt.Errorf("%d fields, %d expected", len(fields), len(h.Columns))
+	}
+	num, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return s, fmt.Errorf("invalid num %q", fields[0])
+	}
+	us, err := strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64)
+	if err != nil {
+		return s, fmt.Errorf("invalid time %q", fields[1])
+	}
+	s.Num, s.Time = num, time.Duration(us)*time.Microsecond
+	values := make([]float64, len(fields)-2)
+	for i, f := range fields[2:] {
+		if h.HasP && i == len(values)-1 {
+			break
+		}
+		if values[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64); err != nil {
+			return s, fmt.Errorf("invalid %s %q", h.Columns[i+2], f)
+		}
+	}
+	copy(s.Acc[:], values[0:3])
+	copy(s.Gyr[:], values[3:6])
+	if len(h.Extra) > 0 {
+		s.Extra = values[6 : 6+len(h.Extra)]
+	}
+	if h.HasP {
+		p := strings.TrimSpace(fields[len(fields)-1])
+		switch p {
+		case "0":
+		case "1":
+			s.Present = true
+		default:
+			return s, fmt.Errorf("invalid p %q", p)
+		}
+	}
+	return s, nil
+}
+
+// Read the acquisitions of r. Malformed lines are skipped and reported in
+// an ErrorList along with the acquisitions read; any other error stops the
+// reading.
+func Read(r io.Reader) ([]Acquisition, error) {
+	return read(r, "")
+}
+
+// ReadFile reads the acquisitions of a file, see Read.
+func ReadFile(name string) ([]Acquisition, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return read(f, name)
+}
+
+func read(r io.Reader, name string) ([]Acquisition, error) {
+	var (
+		acqs   []Acquisition
+		cur    *Acquisition
+		inHead bool // between the ########## lines
+		errs   ErrorList
+	)
+	report := func(n int, text string, err error) {
+		errs = append(errs, &RowError{File: name, Line: n, Text: text, Err: err})
+	}
+	begin := func(n int) {
+		acqs = append(acqs, Acquisition{Line: n})
+		cur = &acqs[len(acqs)-1]
+	}
+
+	sc := bufio.NewScanner(r)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimRight(sc.Text(), "\r")
+		switch {
+		case strings.TrimSpace(line) == "":
+		case strings.HasPrefix(line, "#"):
+			if strings.Trim(line, "#") == "" { //block delimiter
+				if !inHead && (cur == nil || cur.Header.Columns != nil) {
+					begin(n)
+				}
+				inHead = !inHead
+				continue
+			}
+			if cur == nil || (!inHead && cur.Header.Columns != nil) {
+				begin(n)
+			}
+			if err := cur.Header.parseLine(line[1:]); err != nil {
+				report(n, line, err)
+			}
+		case strings.HasPrefix(strings.TrimSpace(line), COLUMNS[0]+";"):
+			inHead = false
+			if cur == nil || cur.Header.Columns != nil {
+				begin(n)
+			}
+			if err := cur.Header.parseColumns(line); err != nil {
+				report(n, line, err)
+			}
+		default:
+			if cur == nil || cur.Header.Columns == nil {
+				report(n, line, fmt.Errorf("row before the column line"))
+				continue
+			}
+			s, err := cur.Header.parseRow(line)
+			if err != nil {
+				report(n, line, err)
+				continue
+			}
+			cur.Samples = append(cur.Samples, s)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return acqs, err
+	}
+	if len(errs) > 0 {
+		return acqs, errs
+	}
+	return acqs, nil
+}
@@ -0,0 +1,178 @@
+package knobcsv
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const header = `##########
+# 2017-01-31 13:42:11.167947041 +0100 CET Data Acquisition
+# Acquisition name: i001
+# Acquisition num: 0
+# Accelerometer full scale: 8 (4096)
+# Gyroscope full scale: 250 (131)
+# Session: 170131
+# knob a
+##########
+`
+
+const columns = "num; time(us); accX(g); accY(g); accZ(g); gyrX(o/s); gyrY(o/s); gyrZ(o/s);p\n"
+
+// writeFile writes text to a file of a temporary directory.
+func writeFile(t *testing.T, text string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "i001.csv")
+	if err := os.WriteFile(name, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// summary of an acquisition read.
+type summary struct {
+	Line    int
+	Name    string
+	Extra   []string
+	HasP    bool
+	Samples int
+}
+
+func summarize(acqs []Acquisition) []summary {
+	var out []summary
+	for _, a := range acqs {
+		out = append(out, summary{a.Line, a.Header.Name, a.Header.Extra, a.Header.HasP, len(a.Samples)})
+	}
+	return out
+}
+
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		want     []summary
+		errLines []int
+	}{
+		{
+			name: "header block",
+			text: header + columns +
+				"1;0;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;0\n" +
+				"2;2000;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;1\n",
+			want: []summary{{1, "i001", []string{}, true, 2}},
+		},
+		{
+			name: "nohd",
+			text: columns +
+				"1;0;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;0\n",
+			want: []summary{{1, "", []string{}, true, 1}},
+		},
+		{
+			name: "without p",
+			text: "num; time(us); accX(g); accY(g); accZ(g); gyrX(o/s); gyrY(o/s); gyrZ(o/s)\n" +
+				"1;0;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962\n",
+			want: []summary{{1, "", []string{}, false, 1}},
+		},
+		{
+			name: "extra columns",
+			text: "num; time(us); accX(g); accY(g); accZ(g); gyrX(o/s); gyrY(o/s); gyrZ(o/s);magX;e0;p\n" +
+				"1;0;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;120;512;1\n",
+			want: []summary{{1, "", []string{"magX", "e0"}, true, 1}},
+		},
+		{
+			name: "appended acquisitions",
+			text: header + columns +
+				"1;0;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;0\n" +
+				header + columns +
+				"1;0;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;0\n" +
+				"2;2000;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;0\n",
+			want: []summary{{1, "i001", []string{}, true, 1}, {12, "i001", []string{}, true, 2}},
+		},
+		{
+			name: "appended nohd acquisitions",
+			text: columns +
+				"1;0;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;0\n" +
+				columns +
+				"1;0;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;0\n",
+			want: []summary{{1, "", []string{}, true, 1}, {3, "", []string{}, true, 1}},
+		},
+		{
+			name: "row errors",
+			text: "1;0;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;0\n" +
+				header + columns +
+				"1;0;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;0\n" +
+				"2;2000;0.010254;-0.475586\n" +
+				"3;x;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;0\n" +
+				"4;6000;0.010254;-0.475586;-0.883789;0.908397;-0.129771;abc;0\n" +
+				"5;8000;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;2\n" +
+				"6;10000;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;1\n",
+			want:     []summary{{2, "i001", []string{}, true, 2}},
+			errLines: []int{1, 13, 14, 15, 16},
+		},
+		{
+			name:     "invalid header",
+			text:     "##########\n# Accelerometer full scale: eight\n##########\n" + columns,
+			want:     []summary{{1, "", []string{}, true, 0}},
+			errLines: []int{2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := writeFile(t, tt.text)
+			acqs, err := ReadFile(name)
+			if got := summarize(acqs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("acquisitions %+v, want %+v", got, tt.want)
+			}
+			var lines []int
+			if err != nil {
+				errs, ok := err.(ErrorList)
+				if !ok {
+					t.Fatalf("error %v, want an ErrorList", err)
+				}
+				for _, e := range errs {
+					if e.File != name {
+						t.Errorf("error of %q, want %q", e.File, name)
+					}
+					lines = append(lines, e.Line)
+				}
+			}
+			if !reflect.DeepEqual(lines, tt.errLines) {
+				t.Errorf("errors on lines %v, want %v", lines, tt.errLines)
+			}
+		})
+	}
+}
+
+func TestReadFileHeader(t *testing.T) {
+	acqs, err := ReadFile(writeFile(t, header+columns+
+		"1;0;0.010254;-0.475586;-0.883789;0.908397;-0.129771;-0.854962;0\n"+
+		"2;2000;0.5;1;-1;2.5;0;-3;1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acqs) != 1 {
+		t.Fatalf("%d acquisitions, want 1", len(acqs))
+	}
+	h := acqs[0].Header
+	when, _ := time.Parse(TIME_LAYOUT, "2017-01-31 13:42:11.167947041 +0100 CET")
+	if !h.Time.Equal(when) {
+		t.Errorf("time %v, want %v", h.Time, when)
+	}
+	if h.Name != "i001" || h.Num != 0 || !h.HasNum {
+		t.Errorf("name %q num %d (%t), want i001 0", h.Name, h.Num, h.HasNum)
+	}
+	if h.AccFS != 8 || h.AccSens != 4096 || h.GyrFS != 250 || h.GyrSens != 131 {
+		t.Errorf("full scales %d (%g) %d (%g), want 8 (4096) 250 (131)", h.AccFS, h.AccSens, h.GyrFS, h.GyrSens)
+	}
+	if !reflect.DeepEqual(h.Fields, map[string]string{"Session": "170131"}) {
+		t.Errorf("fields %v", h.Fields)
+	}
+	if !reflect.DeepEqual(h.Comments, []string{"knob a"}) {
+		t.Errorf("comments %q", h.Comments)
+	}
+	want := Sample{Num: 2, Time: 2 * time.Millisecond, Acc: [3]float64{0.5, 1, -1}, Gyr: [3]float64{2.5, 0, -3}, Present: true}
+	if got := acqs[0].Samples[1]; !reflect.DeepEqual(got, want) {
+		t.Errorf("sample %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,92 @@
+package knobcsv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Line delimiting the header block.
+const HEADER_DELIMITER = "##########"
+
+// Whether the header has something to write, files of -nohd have not.
+func (h *Header) hasBlock() bool {
+	return !h.Time.IsZero() || h.Name != "" || h.HasNum || h.AccFS != 0 || h.GyrFS != 0 || len(h.Fields) > 0 || len(h.Comments) > 0
+}
+
+// Format an extra value: counts as integers, the rest as knobID does.
+func formatExtra(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+		return fmt.Sprintf("%d", int64(v))
+	}
+	return fmt.Sprintf("%f", v)
+}
+
+// Write writes an acquisition the way knobID does: the header block, if
+// the header has any field, the column line and the rows. The columns are
+// the standard ones, then Header.Extra and p if Header.HasP.
+func Write(w io.Writer, a *Acquisition) error {
+	bw := bufio.NewWriter(w)
+	h := &a.Header
+	if h.hasBlock() {
+		fmt.Fprintln(bw, HEADER_DELIMITER)
+		if !h.Time.IsZero() {
+			fmt.Fprintf(bw, "# %s Data Acquisition\n", h.Time.Format(TIME_LAYOUT))
+		}
+		if h.Name != "" {
+			fmt.Fprintf(bw, "# Acquisition name: %s\n", h.Name)
+		}
+		if h.HasNum {
+			fmt.Fprintf(bw, "# Acquisition num: %d\n", h.Num)
+		}
+		if h.AccFS != 0 {
+			fmt.Fprintf(bw, "# Accelerometer full scale: %d (%d)\n", h.AccFS, int(h.AccSens))
+		}
+		if h.GyrFS != 0 {
+			fmt.Fprintf(bw, "# Gyroscope full scale: %d (%d)\n", h.GyrFS, int(h.GyrSens))
+		}
+		keys := make([]string, 0, len(h.Fields))
+		for k := range h.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(bw, "# %s: %s\n", k, h.Fields[k])
+		}
+		for _, c := range h.Comments {
+			fmt.Fprintf(bw, "# %s\n", c)
+		}
+		fmt.Fprintln(bw, HEADER_DELIMITER)
+	}
+
+	cols := strings.Join(COLUMNS, "; ")
+	for _, c := range h.Extra {
+		cols += "; " + c
+	}
+	if h.HasP {
+		cols += ";" + PRESENCE_COLUMN
+	}
+	fmt.Fprintln(bw, cols)
+	for i, s := range a.Samples {
+		if len(s.Extra) != len(h.Extra) {
+			return fmt.Errorf("knobcsv: sample %d has %d extra values, %d expected", i, len(s.Extra), len(h.Extra))
+		}
+		fmt.Fprintf(bw, "%d;%d;%f;%f;%f;%f;%f;%f", s.Num, s.Time.Microseconds(),
+			s.Acc[0], s.Acc[1], s.Acc[2], s.Gyr[0], s.Gyr[1], s.Gyr[2])
+		for _, v := range s.Extra {
+			bw.WriteString(";" + formatExtra(v))
+		}
+		if h.HasP {
+			p := 0
+			if s.Present {
+				p = 1
+			}
+			fmt.Fprintf(bw, ";%d", p)
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,243 @@
+// Package metadata is the machine readable record of an acquisition, saved
+// in a sidecar next to its data file (i001a8w250_00.csv gets
+// i001a8w250_00.jsonl): the device and the tool that took it, the sensors
+// and their registers as written, the calibration applied, the margin, the
+// presence sensor, the trigger times and how well the sampling went, so any
+// entry of the dataset can be audited and reproduced.
+package metadata
+
+import (
+	"../i2c"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Version of the sidecar layout, raised when a field changes meaning.
+const FORMAT = 1
+
+// Extension of the sidecar files, JSON lines.
+const EXTENSION = ".jsonl"
+
+// Metadata of one capture.
+type Metadata struct {
+	Format      int          `json:"format"`
+	File        string       `json:"file"` // data file, base name
+	Name        string       `json:"name"`
+	Num         int          `json:"num"`
+	Written     time.Time    `json:"written"`
+	Tool        Tool         `json:"tool"`
+	Device      Device       `json:"device"`
+	Sensors     []Sensor     `json:"sensors"`
+	Calibration *Calibration `json:"calibration,omitempty"` // nil if none applied
+	GyroBias    *GyroBias    `json:"gyroBias,omitempty"`    // nil if not recorded
+	Temperature *Temperature `json:"temperature,omitempty"` // nil if not recorded
+	Margin      Margin       `json:"margin"`
+	Presence    Presence     `json:"presence"`
+	Trigger     Trigger      `json:"trigger"`
+	Sampling    Sampling     `json:"sampling"`
+}
+
+// Tool is the program that took the acquisition.
+type Tool struct {
+	Name    string   `json:"name"`
+	Version string   `json:"version"`
+	Go      string   `json:"go"`
+	Args    []string `json:"args"`
+}
+
+// Device is the machine the knob is attached to.
+type Device struct {
+	Host   string `json:"host"`
+	Model  string `json:"model,omitempty"`
+	Serial string `json:"serial,omitempty"`
+}
+
+// Sensor is an i2c sensor with its configuration.
+type Sensor struct {
+	Name      string            `json:"name"`
+	Bus       int               `json:"bus"`
+	Address   byte              `json:"address"`
+	Access    string            `json:"access,omitempty"` // how it is reached, if not directly
+	Config    map[string]string `json:"config,omitempty"`
+	Registers []i2c.Register    `json:"registers,omitempty"`
+}
+
+// Calibration applied to the data before it was written.
+type Calibration struct {
+	Source  string               `json:"source,omitempty"` // profile file
+	Offsets map[string][]float64 `json:"offsets"`
+}
+
+// GyroBias estimated while the knob was still, at the capture.
+type GyroBias struct {
+	Bias       [3]float64 `json:"bias"`    // o/s
+	Windows    int        `json:"windows"` // still windows averaged, 0 if none yet
+	Updated    time.Time  `json:"updated,omitzero"`
+	Subtracted bool       `json:"subtracted"` // from the data, in counts
+}
+
+// Temperature of the die of the MPU over the capture, oC.
+type Temperature struct {
+	Mean        float64 `json:"mean"`
+	Min         float64 `json:"min"`
+	Max         float64 `json:"max"`
+	Compensated bool    `json:"compensated"` // drift of the biases removed from the data
+}
+
+// Margin before the presence and after the release, in samples or in time.
+type Margin struct {
+	Samples int `json:"samples,omitempty"`
+	Millis  int `json:"millis,omitempty"`
+	Pre     int `json:"pre"`  // samples kept before the presence
+	Post    int `json:"post"` // samples kept after the release
+}
+
+// Presence sensor that triggered the capture.
+type Presence struct {
+	Sensor     string `json:"sensor"` // cap (MPR121) or ir
+	DebounceMs int    `json:"debounceMs"`
+	Electrodes string `json:"electrodes,omitempty"`
+	Thresholds string `json:"thresholds,omitempty"`
+	AutoConfig bool   `json:"autoConfig,omitempty"`
+}
+
+// Trigger times of the capture.
+type Trigger struct {
+	Start       time.Time `json:"start"` // presence
+	End         time.Time `json:"end"`   // release
+	FirstSample time.Time `json:"firstSample"`
+	LastSample  time.Time `json:"lastSample"`
+	Samples     int       `json:"samples"` // while present
+}
+
+// Sampling of the capture.
+type Sampling struct {
+	Mode            string  `json:"mode"` // direct reads or fifo
+	Nominal         float64 `json:"nominal"`
+	Effective       float64 `json:"effective"` // Hz, over the whole capture
+	Missing         int     `json:"missing"`   // samples missing from the gaps of the timestamps
+	MaxGapUs        int64   `json:"maxGapUs"`
+	FIFOOverflows   int     `json:"fifoOverflows"`   // during the capture
+	MagOverflows    int     `json:"magOverflows"`    // during the capture
+	CapturesDropped int     `json:"capturesDropped"` // by the writer so far
+}
+
+// A gap longer than GAP_FACTOR periods is counted as missing samples.
+const GAP_FACTOR = 1.5
+
+// Timing measures the effective rate of sampling times and the samples
+// missing for the nominal period.
+func Timing(times []time.Time, period time.Duration) (effective float64, missing int, maxGap time.Duration) {
+	n := len(times)
+	if n < 2 {
+		return 0, 0, 0
+	}
+	if d := times[n-1].Sub(times[0]); d > 0 {
+		effective = float64(n-1) / d.Seconds()
+	}
+	for i := 1; i < n; i++ {
+		gap := times[i].Sub(times[i-1])
+		if gap > maxGap {
+			maxGap = gap
+		}
+		if period > 0 && float64(gap) > GAP_FACTOR*float64(period) {
+			missing += int((gap+period/2)/period) - 1
+		}
+	}
+	return effective, missing, maxGap
+}
+
+// NewTool describes the running program, with its arguments.
+func NewTool(name, version string) Tool {
+	return Tool{Name: name, Version: version, Go: runtime.Version(), Args: os.Args[1:]}
+}
+
+// Value of a "key : value" line of /proc/cpuinfo.
+func cpuinfo(key string) string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), ":")
+		if ok && strings.TrimSpace(k) == key {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// Content of a small file, without the trailing NUL and spaces.
+func readString(name string) string {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
+
+// NewDevice describes the machine: the host name, and on a Raspberry Pi its
+// model and serial number, the machine id elsewhere.
+func NewDevice() Device {
+	d := Device{}
+	d.Host, _ = os.Hostname()
+	d.Model = readString("/proc/device-tree/model")
+	if d.Model == "" {
+		d.Model = cpuinfo("Model")
+	}
+	d.Serial = cpuinfo("Serial")
+	if d.Serial == "" {
+		d.Serial = readString("/etc/machine-id")
+	}
+	return d
+}
+
+// SidecarName is the sidecar file of a data file.
+func SidecarName(dataFile string) string {
+	return strings.TrimSuffix(dataFile, filepath.Ext(dataFile)) + EXTENSION
+}
+
+// Append adds the metadata of a capture to a sidecar file, created if
+// needed.
+func Append(name string, m *Metadata) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ReadFile loads the metadata of all the captures of a sidecar file.
+func ReadFile(name string) ([]*Metadata, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ms []*Metadata
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		m := new(Metadata)
+		if err := dec.Decode(m); err != nil {
+			return ms, fmt.Errorf("metadata: %s: capture %d: %v", name, len(ms), err)
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
@@ -0,0 +1,425 @@
+// Package mpr121 drives the Freescale MPR121 12 electrodes capacitive touch
+// sensor over an i2c.Bus.
+//
+// Most registers can only be written in stop mode: configure the device
+// after Reset or Stop and then Start the electrodes.
+package mpr121
+
+import (
+	"../i2c"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Time the device needs after a soft reset.
+const RESET_TIME = 10 * time.Millisecond
+
+// ErrOverCurrent is returned when over current was detected on the REXT
+// pin, the device stops measuring until it is reset.
+var ErrOverCurrent = errors.New("mpr121: over current on REXT")
+
+// Charge time, the CDT field. The electrode value 0 means the global one.
+type ChargeTime byte
+
+const (
+	CHARGE_TIME_GLOBAL ChargeTime = 0
+	CHARGE_TIME_0_5US  ChargeTime = 1
+	CHARGE_TIME_1US    ChargeTime = 2
+	CHARGE_TIME_2US    ChargeTime = 3
+	CHARGE_TIME_4US    ChargeTime = 4
+	CHARGE_TIME_8US    ChargeTime = 5
+	CHARGE_TIME_16US   ChargeTime = 6
+	CHARGE_TIME_32US   ChargeTime = 7
+)
+
+// Duration of the charge, 0 for CHARGE_TIME_GLOBAL.
+func (t ChargeTime) Duration() time.Duration {
+	if t == CHARGE_TIME_GLOBAL {
+		return 0
+	}
+	return 500 * time.Nanosecond << (t - 1)
+}
+
+func (t ChargeTime) String() string {
+	if t == CHARGE_TIME_GLOBAL {
+		return "global"
+	}
+	return t.Duration().String()
+}
+
+// MPR121 is safe for concurrent use, the touch status can be polled while
+// the electrode data is read: every method holds the bus for its whole
+// transaction.
+type MPR121 struct {
+	mu         sync.Mutex
+	i2c        i2c.Bus
+	electrodes uint16 // started electrodes
+	buf        []byte
+}
+
+// New drives the device behind dev.
+func New(dev i2c.Bus) *MPR121 {
+	return &MPR121{
+		i2c: dev,
+		buf: make([]byte, 2*NUM_ELECTRODES),
+	}
+}
+
+// Open the device at the default address of the given bus.
+func Open(open i2c.Opener, bus int) (*MPR121, error) {
+	dev, err := open(DEVICE_ADDRESS, bus)
+	if err != nil {
+		return nil, err
+	}
+	return New(dev), nil
+}
+
+// Close the connection to the device.
+func (mpr *MPR121) Close() error {
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	return mpr.i2c.Close()
+}
+
+func checkElectrode(ele int) error {
+	if ele < 0 || ele >= NUM_ELECTRODES {
+		return fmt.Errorf("mpr121: invalid electrode %d", ele)
+	}
+	return nil
+}
+
+// Reset all the registers to their default values, the device is in stop
+// mode afterwards.
+func (mpr *MPR121) Reset() error {
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	if err := mpr.i2c.WriteRegU8(REG_SOFTRESET, PARAM_SOFTRESET); err != nil {
+		return err
+	}
+	time.Sleep(RESET_TIME)
+	mpr.electrodes = 0
+	return nil
+}
+
+// Start measuring the electrodes of the mask, bit n for electrode n, with
+// baseline tracking. The MPR121 runs electrodes 0 to the highest one of the
+// mask, the others are measured but never reported as touched.
+func (mpr *MPR121) Start(electrodes uint16) error {
+	electrodes &= PARAM_TOUCH_MASK
+	if electrodes == 0 {
+		return errors.New("mpr121: no electrode to start")
+	}
+	n := 0
+	for m := electrodes; m != 0; m >>= 1 {
+		n++
+	}
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	if err := mpr.i2c.WriteRegU8(REG_ECR, PARAM_CL_TRACK_5BIT|byte(n)); err != nil {
+		return err
+	}
+	mpr.electrodes = electrodes
+	return nil
+}
+
+// Stop measuring, the configuration registers can be written again.
+func (mpr *MPR121) Stop() error {
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	if err := mpr.i2c.WriteRegU8(REG_ECR, 0); err != nil {
+		return err
+	}
+	mpr.electrodes = 0
+	return nil
+}
+
+// Electrodes returns the mask of the started electrodes.
+func (mpr *MPR121) Electrodes() uint16 {
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	return mpr.electrodes
+}
+
+// SetDefaultFilter sets the baseline filter and the global charge of the
+// Adafruit library: 16 uA, 0.5 us and 1 ms sampling period.
+func (mpr *MPR121) SetDefaultFilter() error {
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	regs := []struct{ reg, value byte }{
+		{REG_MHDR, 0x01}, {REG_NHDR, 0x01}, {REG_NCLR, 0x0e}, {REG_FDLR, 0x00},
+		{REG_MHDF, 0x01}, {REG_NHDF, 0x05}, {REG_NCLF, 0x01}, {REG_FDLF, 0x00},
+		{REG_NHDT, 0x00}, {REG_NCLT, 0x00}, {REG_FDLT, 0x00},
+		{REG_DEBOUNCE, 0},
+		{REG_CONFIG1, 0x10}, // default, 16uA charge current
+		{REG_CONFIG2, 0x20}, // 0.5uS encoding, 1ms period
+	}
+	for _, r := range regs {
+		if err := mpr.i2c.WriteRegU8(r.reg, r.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetThresholds sets the touch and release thresholds of an electrode, in
+// counts of baseline minus filtered data. Touch should be above release.
+func (mpr *MPR121) SetThresholds(ele int, touch, release byte) error {
+	if err := checkElectrode(ele); err != nil {
+		return err
+	}
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	if err := mpr.i2c.WriteRegU8(REG_TOUCHTH_0+byte(2*ele), touch); err != nil {
+		return err
+	}
+	return mpr.i2c.WriteRegU8(REG_RELEASETH_0+byte(2*ele), release)
+}
+
+// SetDebounce sets the number of consecutive samples needed to report a
+// touch and a release, 0 to 7.
+func (mpr *MPR121) SetDebounce(touch, release byte) error {
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	return mpr.i2c.WriteRegU8(REG_DEBOUNCE, (release&0x07)<<4|touch&0x07)
+}
+
+// SetGlobalCharge sets the charge current in uA (1 to 63) and time used by
+// the electrodes without their own.
+func (mpr *MPR121) SetGlobalCharge(ua byte, t ChargeTime) error {
+	if ua == 0 || ua > MAX_CHARGE_CURRENT || t == CHARGE_TIME_GLOBAL || t > CHARGE_TIME_32US {
+		return fmt.Errorf("mpr121: invalid global charge %d uA, %v", ua, t)
+	}
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	cfg, err := mpr.i2c.ReadRegU8(REG_CONFIG1)
+	if err != nil {
+		return err
+	}
+	if err := mpr.i2c.WriteRegU8(REG_CONFIG1, cfg&^PARAM_CDC_MASK|ua); err != nil {
+		return err
+	}
+	cfg, err = mpr.i2c.ReadRegU8(REG_CONFIG2)
+	if err != nil {
+		return err
+	}
+	return mpr.i2c.WriteRegU8(REG_CONFIG2, cfg&^PARAM_CDT_MASK|byte(t)<<5)
+}
+
+// SetChargeCurrent sets the charge current of an electrode in uA, 0 to use
+// the global one.
+func (mpr *MPR121) SetChargeCurrent(ele int, ua byte) error {
+	if err := checkElectrode(ele); err != nil {
+		return err
+	}
+	if ua > MAX_CHARGE_CURRENT {
+		return fmt.Errorf("mpr121: invalid charge current %d uA", ua)
+	}
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	return mpr.i2c.WriteRegU8(REG_CHARGECURR_0+byte(ele), ua)
+}
+
+// SetChargeTime sets the charge time of an electrode, CHARGE_TIME_GLOBAL
+// to use the global one.
+func (mpr *MPR121) SetChargeTime(ele int, t ChargeTime) error {
+	if err := checkElectrode(ele); err != nil {
+		return err
+	}
+	if t > CHARGE_TIME_32US {
+		return fmt.Errorf("mpr121: invalid charge time %d", t)
+	}
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	reg := REG_CHARGETIME_0 + byte(ele/2)
+	shift := uint(4 * (ele % 2))
+	v, err := mpr.i2c.ReadRegU8(reg)
+	if err != nil {
+		return err
+	}
+	v = v&^(0x07<<shift) | byte(t)<<shift
+	return mpr.i2c.WriteRegU8(reg, v)
+}
+
+// ReadCharge returns the charge current in uA and time of an electrode,
+// as set by SetChargeCurrent and SetChargeTime or by the auto-configuration.
+func (mpr *MPR121) ReadCharge(ele int) (byte, ChargeTime, error) {
+	if err := checkElectrode(ele); err != nil {
+		return 0, 0, err
+	}
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	ua, err := mpr.i2c.ReadRegU8(REG_CHARGECURR_0 + byte(ele))
+	if err != nil {
+		return 0, 0, err
+	}
+	v, err := mpr.i2c.ReadRegU8(REG_CHARGETIME_0 + byte(ele/2))
+	if err != nil {
+		return 0, 0, err
+	}
+	return ua & PARAM_CDC_MASK, ChargeTime(v>>uint(4*(ele%2))) & 0x07, nil
+}
+
+// EnableAutoConfig makes the device search the charge current and time of
+// every electrode on Start, so the data sits between the limits derived
+// from the supply voltage vdd, as recommended by the application note AN3889.
+func (mpr *MPR121) EnableAutoConfig(vdd float64) error {
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	if vdd <= 0.7 {
+		return fmt.Errorf("mpr121: invalid supply voltage %.2f V", vdd)
+	}
+	usl := 256 * (vdd - 0.7) / vdd
+	regs := []struct{ reg, value byte }{
+		{REG_UPLIMIT, byte(usl)},
+		{REG_TARGETLIMIT, byte(usl * 0.9)},
+		{REG_LOWLIMIT, byte(usl * 0.65)},
+		{REG_AUTOCONFIG1, 0},
+		// BVA like the CL of Start, FFI and retries to their default
+		{REG_AUTOCONFIG0, PARAM_CL_TRACK_5BIT>>4 | PARAM_ARE | PARAM_ACE},
+	}
+	for _, r := range regs {
+		if err := mpr.i2c.WriteRegU8(r.reg, r.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AutoConfigStatus returns an error naming the electrodes that the
+// auto-configuration could not bring within the limits.
+func (mpr *MPR121) AutoConfigStatus() error {
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	oor, err := mpr.i2c.ReadRegU16LE(REG_OORSTATUS_L)
+	if err != nil {
+		return err
+	}
+	if oor&(PARAM_ACFF|PARAM_ARFF) == 0 {
+		return nil
+	}
+	return fmt.Errorf("mpr121: auto-configuration failed, out of range electrodes %012b", oor&PARAM_TOUCH_MASK)
+}
+
+// TouchStatus returns the touched electrodes among the started ones, bit n
+// for electrode n.
+func (mpr *MPR121) TouchStatus() (uint16, error) {
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	status, err := mpr.i2c.ReadRegU16LE(REG_TOUCHSTATUS_L)
+	if err != nil {
+		return 0, err
+	}
+	if status&PARAM_OVCF != 0 {
+		return 0, ErrOverCurrent
+	}
+	return status & mpr.electrodes, nil
+}
+
+// Touched reports whether any of the started electrodes is touched.
+func (mpr *MPR121) Touched() (bool, error) {
+	status, err := mpr.TouchStatus()
+	return status != 0, err
+}
+
+// ReadFilteredData reads the 10 bits filtered data of all the electrodes.
+func (mpr *MPR121) ReadFilteredData() ([NUM_ELECTRODES]uint16, error) {
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	var data [NUM_ELECTRODES]uint16
+	if _, err := mpr.i2c.Write([]byte{REG_FILTDATA_0L}); err != nil {
+		return data, err
+	}
+	if _, err := mpr.i2c.Read(mpr.buf); err != nil {
+		return data, err
+	}
+	for i := range data {
+		data[i] = uint16(mpr.buf[2*i+1]&0x03)<<8 | uint16(mpr.buf[2*i])
+	}
+	return data, nil
+}
+
+// ReadBaseline reads the baseline of all the electrodes, in the 10 bits
+// scale of the filtered data.
+func (mpr *MPR121) ReadBaseline() ([NUM_ELECTRODES]uint16, error) {
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	var data [NUM_ELECTRODES]uint16
+	buf := mpr.buf[:NUM_ELECTRODES]
+	if _, err := mpr.i2c.Write([]byte{REG_BASELINE_0}); err != nil {
+		return data, err
+	}
+	if _, err := mpr.i2c.Read(buf); err != nil {
+		return data, err
+	}
+	for i := range data {
+		data[i] = uint16(buf[i]) << 2
+	}
+	return data, nil
+}
+
+// Config resets the device, sets the default filter and the same
+// thresholds on every electrode and starts the electrodes of the mask.
+func (mpr *MPR121) Config(electrodes uint16, touch, release byte) error {
+	if err := mpr.Reset(); err != nil {
+		return err
+	}
+	if err := mpr.Stop(); err != nil {
+		return err
+	}
+	for ele := 0; ele < NUM_ELECTRODES; ele++ {
+		if err := mpr.SetThresholds(ele, touch, release); err != nil {
+			return err
+		}
+	}
+	if err := mpr.SetDefaultFilter(); err != nil {
+		return err
+	}
+	return mpr.Start(electrodes)
+}
+
+// Configuration registers, as recorded in the metadata of the acquisitions:
+// filters, thresholds, debounce, charge, electrodes and auto-configuration.
+var CONFIG_REGISTERS = configRegisters()
+
+func configRegisters() map[byte]string {
+	regs := map[byte]string{
+		REG_MHDR:        "MHDR",
+		REG_NHDR:        "NHDR",
+		REG_NCLR:        "NCLR",
+		REG_FDLR:        "FDLR",
+		REG_MHDF:        "MHDF",
+		REG_NHDF:        "NHDF",
+		REG_NCLF:        "NCLF",
+		REG_FDLF:        "FDLF",
+		REG_NHDT:        "NHDT",
+		REG_NCLT:        "NCLT",
+		REG_FDLT:        "FDLT",
+		REG_DEBOUNCE:    "DEBOUNCE",
+		REG_CONFIG1:     "CONFIG1",
+		REG_CONFIG2:     "CONFIG2",
+		REG_ECR:         "ECR",
+		REG_AUTOCONFIG0: "AUTOCONFIG0",
+		REG_AUTOCONFIG1: "AUTOCONFIG1",
+		REG_UPLIMIT:     "UPLIMIT",
+		REG_LOWLIMIT:    "LOWLIMIT",
+		REG_TARGETLIMIT: "TARGETLIMIT",
+	}
+	for ele := 0; ele < NUM_ELECTRODES; ele++ {
+		regs[REG_TOUCHTH_0+byte(2*ele)] = fmt.Sprintf("TOUCHTH_%d", ele)
+		regs[REG_RELEASETH_0+byte(2*ele)] = fmt.Sprintf("RELEASETH_%d", ele)
+		regs[REG_CHARGECURR_0+byte(ele)] = fmt.Sprintf("CHARGECURR_%d", ele)
+	}
+	for i := 0; i < NUM_ELECTRODES/2; i++ {
+		regs[REG_CHARGETIME_0+byte(i)] = fmt.Sprintf("CHARGETIME_%d", i)
+	}
+	return regs
+}
+
+// ReadConfig reads the configuration registers.
+func (mpr *MPR121) ReadConfig() ([]i2c.Register, error) {
+	mpr.mu.Lock()
+	defer mpr.mu.Unlock()
+	return i2c.ReadRegisters(mpr.i2c, CONFIG_REGISTERS)
+}
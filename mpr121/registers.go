@@ -0,0 +1,108 @@
+package mpr121
+
+// Register map, from the MPR121 datasheet rev 4 and
+// https://github.com/adafruit/Adafruit_MPR121/blob/master/Adafruit_MPR121.cpp
+const (
+	// The default address, ADDR pin to ground. 0x5b to 0x5d with ADDR to
+	// VDD, SDA or SCL.
+	DEVICE_ADDRESS = 0x5a
+
+	REG_TOUCHSTATUS_L = 0x00
+	REG_TOUCHSTATUS_H = 0x01
+	REG_OORSTATUS_L   = 0x02 // out of range
+	REG_OORSTATUS_H   = 0x03
+
+	// electrode filtered data, 10 bits little endian, 2 registers per electrode
+	REG_FILTDATA_0L = 0x04
+	REG_FILTDATA_0H = 0x05
+
+	// electrode baseline, bits 9:2 of the 10 bits value, 1 register per electrode
+	REG_BASELINE_0 = 0x1e
+
+	// baseline filter, rising, falling and touched
+	REG_MHDR = 0x2b
+	REG_NHDR = 0x2c
+	REG_NCLR = 0x2d
+	REG_FDLR = 0x2e
+	REG_MHDF = 0x2f
+	REG_NHDF = 0x30
+	REG_NCLF = 0x31
+	REG_FDLF = 0x32
+	REG_NHDT = 0x33
+	REG_NCLT = 0x34
+	REG_FDLT = 0x35
+
+	// touch and release thresholds, 2 registers per electrode
+	REG_TOUCHTH_0   = 0x41
+	REG_RELEASETH_0 = 0x42
+
+	REG_DEBOUNCE = 0x5b
+	REG_CONFIG1  = 0x5c // FFI and global CDC
+	REG_CONFIG2  = 0x5d // global CDT, SFI and ESI
+	REG_ECR      = 0x5e // electrode configuration
+
+	// electrode charge current, 1 register per electrode
+	REG_CHARGECURR_0 = 0x5f
+
+	// electrode charge time, 2 electrodes per register, even ones in the low nibble
+	REG_CHARGETIME_0 = 0x6c
+
+	REG_GPIOCTL0   = 0x73
+	REG_GPIOCTL1   = 0x74
+	REG_GPIODATA   = 0x75
+	REG_GPIODIR    = 0x76
+	REG_GPIOEN     = 0x77
+	REG_GPIOSET    = 0x78
+	REG_GPIOCLR    = 0x79
+	REG_GPIOTOGGLE = 0x7a
+
+	// auto-configuration
+	REG_AUTOCONFIG0 = 0x7b
+	REG_AUTOCONFIG1 = 0x7c
+	REG_UPLIMIT     = 0x7d
+	REG_LOWLIMIT    = 0x7e
+	REG_TARGETLIMIT = 0x7f
+
+	REG_SOFTRESET = 0x80
+
+	// SOFTRESET value
+	PARAM_SOFTRESET = 0x63
+
+	// TOUCHSTATUS bits
+	PARAM_TOUCH_MASK = 0x0fff
+	PARAM_OVCF       = 0x8000 // over current on REXT
+
+	// OORSTATUS bits
+	PARAM_ACFF = 0x8000 // auto-configuration failed
+	PARAM_ARFF = 0x4000 // auto-reconfiguration failed
+
+	// ECR bits
+	PARAM_CL_TRACK       = 0x00 // baseline tracking, initial value from the current one
+	PARAM_CL_OFF         = 0x40 // no baseline tracking
+	PARAM_CL_TRACK_5BIT  = 0x80 // baseline tracking, initial value from the 5 high bits of the data
+	PARAM_CL_TRACK_10BIT = 0xc0 // baseline tracking, initial value from all the bits of the data
+	PARAM_ELEPROX_MASK   = 0x30
+	PARAM_ELE_MASK       = 0x0f
+
+	// CONFIG1 bits
+	PARAM_FFI_MASK = 0xc0
+	PARAM_CDC_MASK = 0x3f
+
+	// CONFIG2 bits
+	PARAM_CDT_MASK = 0xe0
+	PARAM_SFI_MASK = 0x18
+	PARAM_ESI_MASK = 0x07
+
+	// AUTOCONFIG0 bits
+	PARAM_AFES_MASK  = 0xc0 // must match FFI
+	PARAM_RETRY_MASK = 0x30
+	PARAM_BVA_MASK   = 0x0c // must match CL
+	PARAM_ARE        = 0x02 // auto-reconfiguration enable
+	PARAM_ACE        = 0x01 // auto-configuration enable
+
+	// AUTOCONFIG1 bits
+	PARAM_SCTS = 0x80 // skip charge time search
+
+	NUM_ELECTRODES     = 12
+	MAX_CHARGE_CURRENT = 63 // uA
+)
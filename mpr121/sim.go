@@ -0,0 +1,137 @@
+package mpr121
+
+import (
+	"../i2c"
+	"sync"
+)
+
+// A simulated MPR121 on top of a register file.
+type simMPR struct {
+	dev      *i2c.SimDevice
+	fn       func() [NUM_ELECTRODES]float64
+	mu       sync.Mutex
+	n        int // running electrodes
+	baseline [NUM_ELECTRODES]float64
+	filt     [NUM_ELECTRODES]uint16
+	touched  uint16
+}
+
+// Simulate makes a simulated i2c device behave like a MPR121. While
+// started, every read of the touch status, the filtered data or the
+// baseline takes a measurement of the electrodes from fn, in counts of the
+// 10 bits filtered data. The baseline follows the data slowly and the
+// touch status compares both with the thresholds of each electrode. The
+// auto-configuration just sets a charge of 16 uA and 0.5 us.
+func Simulate(dev *i2c.SimDevice, fn func() [NUM_ELECTRODES]float64) {
+	sim := &simMPR{dev: dev, fn: fn}
+	sim.powerOn()
+	dev.OnWrite(sim.onWrite)
+	dev.Generate(REG_TOUCHSTATUS_L, sim.touchStatus)
+	dev.Generate(REG_FILTDATA_0L, sim.filtData)
+	dev.Generate(REG_BASELINE_0, sim.baselineData)
+}
+
+func (sim *simMPR) powerOn() {
+	for reg := 0; reg < 256; reg++ {
+		sim.dev.Set(byte(reg), 0)
+	}
+	sim.dev.Set(REG_CONFIG1, 0x10)
+	sim.dev.Set(REG_CONFIG2, 0x24)
+	sim.mu.Lock()
+	sim.n = 0
+	sim.touched = 0
+	sim.mu.Unlock()
+}
+
+func (sim *simMPR) onWrite(reg byte, value byte) {
+	switch reg {
+	case REG_SOFTRESET:
+		if value == PARAM_SOFTRESET {
+			sim.powerOn()
+		}
+	case REG_ECR:
+		n := int(value & PARAM_ELE_MASK)
+		if n > NUM_ELECTRODES {
+			n = NUM_ELECTRODES
+		}
+		if n > 0 && sim.dev.Get(REG_AUTOCONFIG0)&PARAM_ACE != 0 {
+			for ele := 0; ele < n; ele++ {
+				sim.dev.Set(REG_CHARGECURR_0+byte(ele), 16)
+			}
+			for i := 0; i < NUM_ELECTRODES/2; i++ {
+				sim.dev.Set(REG_CHARGETIME_0+byte(i), byte(CHARGE_TIME_0_5US)<<4|byte(CHARGE_TIME_0_5US))
+			}
+		}
+		sim.mu.Lock()
+		sim.n = n
+		sim.touched = 0
+		data := sim.fn()
+		for ele := range sim.baseline {
+			sim.baseline[ele] = data[ele]
+		}
+		sim.mu.Unlock()
+	}
+}
+
+// Take a measurement and update the baseline and touch status.
+func (sim *simMPR) measure() {
+	data := sim.fn()
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	for ele := 0; ele < NUM_ELECTRODES; ele++ {
+		if ele >= sim.n {
+			sim.filt[ele] = 0
+			continue
+		}
+		v := data[ele]
+		if v < 0 {
+			v = 0
+		}
+		if v > 1023 {
+			v = 1023
+		}
+		sim.filt[ele] = uint16(v)
+		delta := sim.baseline[ele] - v
+		bit := uint16(1) << uint(ele)
+		switch {
+		case sim.touched&bit == 0 && delta > float64(sim.dev.Get(REG_TOUCHTH_0+byte(2*ele))):
+			sim.touched |= bit
+		case sim.touched&bit != 0 && delta < float64(sim.dev.Get(REG_RELEASETH_0+byte(2*ele))):
+			sim.touched &^= bit
+		}
+		if sim.touched&bit == 0 {
+			sim.baseline[ele] += (v - sim.baseline[ele]) / 64
+		}
+	}
+}
+
+func (sim *simMPR) touchStatus() []byte {
+	sim.measure()
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return []byte{byte(sim.touched), byte(sim.touched >> 8)}
+}
+
+func (sim *simMPR) filtData() []byte {
+	sim.measure()
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	buf := make([]byte, 0, 2*NUM_ELECTRODES)
+	for _, v := range sim.filt {
+		buf = append(buf, byte(v), byte(v>>8))
+	}
+	return buf
+}
+
+func (sim *simMPR) baselineData() []byte {
+	sim.measure()
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	buf := make([]byte, NUM_ELECTRODES)
+	for ele, v := range sim.baseline {
+		if ele < sim.n {
+			buf[ele] = byte(uint16(v) >> 2)
+		}
+	}
+	return buf
+}
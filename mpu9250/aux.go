@@ -0,0 +1,248 @@
+package mpu9250
+
+import (
+	"../i2c"
+	"fmt"
+	"time"
+)
+
+const (
+	// INT_PIN_CFG bits
+	PARAM_BYPASS_EN = 0x02
+
+	// USER_CTRL bits
+	PARAM_USER_I2C_MST_RST = 0x02
+
+	// I2C_MST_CTRL bits
+	PARAM_I2C_MST_CLK_400KHZ = 0x0d
+
+	// I2C_SLVx_ADDR and I2C_SLVx_CTRL bits
+	PARAM_I2C_SLV_RNW       = 0x80 // read from the slave
+	PARAM_I2C_SLV_EN        = 0x80
+	PARAM_I2C_SLV_LENG_MASK = 0x0f
+
+	// I2C_MST_STATUS bits
+	PARAM_I2C_SLV4_DONE = 0x40
+	PARAM_I2C_SLV4_NACK = 0x10
+
+	// Number of EXT_SENS_DATA registers
+	EXT_SENS_DATA_SIZE = REG_EXT_SENS_DATA_23 - REG_EXT_SENS_DATA_00 + 1
+
+	// Time to wait for a SLV4 transfer
+	AUX_TIMEOUT = 50 * time.Millisecond
+)
+
+// SetBypass connects the auxiliary i2c bus, where the AK8963 is, to the
+// main i2c bus. The i2c master is disabled while in bypass.
+func (mpu *MPU9250) SetBypass(bypass bool) error {
+	if bypass {
+		if err := mpu.updateReg(REG_USER_CTRL, PARAM_USER_I2C_MST_EN, 0); err != nil {
+			return err
+		}
+		return mpu.updateReg(REG_INT_PIN_CFG, PARAM_BYPASS_EN, PARAM_BYPASS_EN)
+	}
+	return mpu.updateReg(REG_INT_PIN_CFG, PARAM_BYPASS_EN, 0)
+}
+
+// EnableMaster disables the bypass and lets the MPU-9250 drive the
+// auxiliary i2c bus at 400 kHz.
+func (mpu *MPU9250) EnableMaster() error {
+	if err := mpu.SetBypass(false); err != nil {
+		return err
+	}
+	if err := mpu.i2c.WriteRegU8(REG_I2C_MST_CTRL, PARAM_I2C_MST_CLK_400KHZ); err != nil {
+		return err
+	}
+	return mpu.updateReg(REG_USER_CTRL, PARAM_USER_I2C_MST_EN, PARAM_USER_I2C_MST_EN)
+}
+
+// StartAuxRead makes the i2c master read n bytes starting at reg of the
+// auxiliary device at addr on every sample, through SLV0. They end up in
+// EXT_SENS_DATA, see ReadExtSensData.
+func (mpu *MPU9250) StartAuxRead(addr uint8, reg byte, n int) error {
+	if n <= 0 || n > PARAM_I2C_SLV_LENG_MASK {
+		return fmt.Errorf("mpu9250: invalid auxiliary read length %d", n)
+	}
+	if err := mpu.i2c.WriteRegU8(REG_I2C_SLV0_ADDR, PARAM_I2C_SLV_RNW|addr); err != nil {
+		return err
+	}
+	if err := mpu.i2c.WriteRegU8(REG_I2C_SLV0_REG, reg); err != nil {
+		return err
+	}
+	return mpu.i2c.WriteRegU8(REG_I2C_SLV0_CTRL, PARAM_I2C_SLV_EN|byte(n))
+}
+
+// StopAuxRead stops the SLV0 reads.
+func (mpu *MPU9250) StopAuxRead() error {
+	return mpu.i2c.WriteRegU8(REG_I2C_SLV0_CTRL, 0)
+}
+
+// ReadExtSensData reads len(buf) bytes from EXT_SENS_DATA_00.
+func (mpu *MPU9250) ReadExtSensData(buf []byte) error {
+	if len(buf) > EXT_SENS_DATA_SIZE {
+		return fmt.Errorf("mpu9250: only %d EXT_SENS_DATA registers", EXT_SENS_DATA_SIZE)
+	}
+	if _, err := mpu.i2c.Write([]byte{REG_EXT_SENS_DATA_00}); err != nil {
+		return err
+	}
+	_, err := mpu.i2c.Read(buf)
+	return err
+}
+
+// AuxBus is a connection to a device on the auxiliary i2c bus through the
+// i2c master, one byte at a time with SLV4. It is slow, use it to configure
+// the device and StartAuxRead to read its data.
+type AuxBus struct {
+	mpu  *MPU9250
+	addr uint8
+	ptr  byte
+}
+
+// AuxBus returns a connection to the auxiliary device at addr. The i2c
+// master must be enabled, see EnableMaster.
+func (mpu *MPU9250) AuxBus(addr uint8) *AuxBus {
+	return &AuxBus{mpu: mpu, addr: addr}
+}
+
+// AuxOpener is an i2c.Opener for the auxiliary bus, the bus number is
+// ignored.
+func (mpu *MPU9250) AuxOpener() i2c.Opener {
+	return func(addr uint8, bus int) (i2c.Bus, error) {
+		return mpu.AuxBus(addr), nil
+	}
+}
+
+// Run a SLV4 transfer and wait for it to complete.
+func (aux *AuxBus) transfer(addr byte, reg byte) error {
+	dev := aux.mpu.i2c
+	if err := dev.WriteRegU8(REG_I2C_SLV4_ADDR, addr); err != nil {
+		return err
+	}
+	if err := dev.WriteRegU8(REG_I2C_SLV4_REG, reg); err != nil {
+		return err
+	}
+	if err := dev.WriteRegU8(REG_I2C_SLV4_CTRL, PARAM_I2C_SLV_EN); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(AUX_TIMEOUT)
+	for {
+		status, err := dev.ReadRegU8(REG_I2C_MST_STATUS)
+		if err != nil {
+			return err
+		}
+		if status&PARAM_I2C_SLV4_NACK != 0 {
+			return fmt.Errorf("mpu9250: auxiliary device %#02x did not acknowledge", aux.addr)
+		}
+		if status&PARAM_I2C_SLV4_DONE != 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("mpu9250: auxiliary device %#02x timed out", aux.addr)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Write sets the register pointer to buf[0] and writes the rest of buf
+// from there.
+func (aux *AuxBus) Write(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	aux.ptr = buf[0]
+	for i, v := range buf[1:] {
+		if err := aux.mpu.i2c.WriteRegU8(REG_I2C_SLV4_DO, v); err != nil {
+			return i + 1, err
+		}
+		if err := aux.transfer(aux.addr, aux.ptr); err != nil {
+			return i + 1, err
+		}
+		aux.ptr++
+	}
+	return len(buf), nil
+}
+
+// Read reads len(p) registers starting at the register pointer.
+func (aux *AuxBus) Read(p []byte) (int, error) {
+	for i := range p {
+		if err := aux.transfer(PARAM_I2C_SLV_RNW|aux.addr, aux.ptr); err != nil {
+			return i, err
+		}
+		v, err := aux.mpu.i2c.ReadRegU8(REG_I2C_SLV4_DI)
+		if err != nil {
+			return i, err
+		}
+		p[i] = v
+		aux.ptr++
+	}
+	return len(p), nil
+}
+
+// Close is a no-op, the MPU-9250 connection stays open.
+func (aux *AuxBus) Close() error {
+	return nil
+}
+
+func (aux *AuxBus) ReadRegU8(reg byte) (byte, error) {
+	if _, err := aux.Write([]byte{reg}); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1)
+	if _, err := aux.Read(buf); err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+func (aux *AuxBus) WriteRegU8(reg byte, value byte) error {
+	_, err := aux.Write([]byte{reg, value})
+	return err
+}
+
+func (aux *AuxBus) ReadRegU16BE(reg byte) (uint16, error) {
+	if _, err := aux.Write([]byte{reg}); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 2)
+	if _, err := aux.Read(buf); err != nil {
+		return 0, err
+	}
+	return uint16(buf[0])<<8 | uint16(buf[1]), nil
+}
+
+func (aux *AuxBus) ReadRegU16LE(reg byte) (uint16, error) {
+	w, err := aux.ReadRegU16BE(reg)
+	if err != nil {
+		return 0, err
+	}
+	return w<<8 | w>>8, nil
+}
+
+func (aux *AuxBus) ReadRegS16BE(reg byte) (int16, error) {
+	w, err := aux.ReadRegU16BE(reg)
+	return int16(w), err
+}
+
+func (aux *AuxBus) ReadRegS16LE(reg byte) (int16, error) {
+	w, err := aux.ReadRegU16LE(reg)
+	return int16(w), err
+}
+
+func (aux *AuxBus) WriteRegU16BE(reg byte, value uint16) error {
+	_, err := aux.Write([]byte{reg, byte(value >> 8), byte(value)})
+	return err
+}
+
+func (aux *AuxBus) WriteRegU16LE(reg byte, value uint16) error {
+	return aux.WriteRegU16BE(reg, value<<8|value>>8)
+}
+
+func (aux *AuxBus) WriteRegS16BE(reg byte, value int16) error {
+	return aux.WriteRegU16BE(reg, uint16(value))
+}
+
+func (aux *AuxBus) WriteRegS16LE(reg byte, value int16) error {
+	return aux.WriteRegU16LE(reg, uint16(value))
+}
+
+var _ i2c.Bus = (*AuxBus)(nil)
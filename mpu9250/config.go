@@ -0,0 +1,178 @@
+package mpu9250
+
+import (
+	"../i2c"
+	"fmt"
+)
+
+// Accelerometer full scale, the value is the ACCEL_FS_SEL field of ACCEL_CONFIG.
+type AccelFS byte
+
+const (
+	ACCEL_FS_2G  AccelFS = 0x00
+	ACCEL_FS_4G  AccelFS = 0x08
+	ACCEL_FS_8G  AccelFS = 0x10
+	ACCEL_FS_16G AccelFS = 0x18
+)
+
+// Accelerometer full scale from its value in g (2, 4, 8, 16).
+func AccelFSFromG(g int) (AccelFS, error) {
+	switch g {
+	case 2:
+		return ACCEL_FS_2G, nil
+	case 4:
+		return ACCEL_FS_4G, nil
+	case 8:
+		return ACCEL_FS_8G, nil
+	case 16:
+		return ACCEL_FS_16G, nil
+	}
+	return ACCEL_FS_2G, fmt.Errorf("invalid accelerometer full scale %d g", g)
+}
+
+// Full scale in g.
+func (fs AccelFS) G() int {
+	return 2 << (fs >> 3)
+}
+
+// Sensitivity in LSB/g.
+func (fs AccelFS) Sensitivity() float64 {
+	return 16384.0 / float64(int(1)<<(fs>>3))
+}
+
+func (fs AccelFS) String() string {
+	return fmt.Sprintf("%dg", fs.G())
+}
+
+// Gyroscope full scale, the value is the GYRO_FS_SEL field of GYRO_CONFIG.
+type GyroFS byte
+
+const (
+	GYRO_FS_250  GyroFS = 0x00
+	GYRO_FS_500  GyroFS = 0x08
+	GYRO_FS_1000 GyroFS = 0x10
+	GYRO_FS_2000 GyroFS = 0x18
+)
+
+// Gyroscope full scale from its value in dps (250, 500, 1000, 2000).
+func GyroFSFromDPS(dps int) (GyroFS, error) {
+	switch dps {
+	case 250:
+		return GYRO_FS_250, nil
+	case 500:
+		return GYRO_FS_500, nil
+	case 1000:
+		return GYRO_FS_1000, nil
+	case 2000:
+		return GYRO_FS_2000, nil
+	}
+	return GYRO_FS_250, fmt.Errorf("invalid gyroscope full scale %d dps", dps)
+}
+
+// Full scale in degrees per second.
+func (fs GyroFS) DPS() int {
+	return 250 << (fs >> 3)
+}
+
+// Sensitivity in LSB/(o/s), as given by the datasheet.
+func (fs GyroFS) Sensitivity() float64 {
+	switch fs {
+	case GYRO_FS_500:
+		return 65.5
+	case GYRO_FS_1000:
+		return 32.8
+	case GYRO_FS_2000:
+		return 16.4
+	}
+	return 131.0
+}
+
+func (fs GyroFS) String() string {
+	return fmt.Sprintf("%ddps", fs.DPS())
+}
+
+// Gyroscope and temperature digital low pass filter, the DLPF_CFG field of
+// CONFIG. The names are the gyroscope bandwidth.
+type DLPF byte
+
+const (
+	DLPF_250HZ  DLPF = 0 // 8 kHz internal sample rate
+	DLPF_184HZ  DLPF = 1
+	DLPF_92HZ   DLPF = 2
+	DLPF_41HZ   DLPF = 3
+	DLPF_20HZ   DLPF = 4
+	DLPF_10HZ   DLPF = 5
+	DLPF_5HZ    DLPF = 6
+	DLPF_3600HZ DLPF = 7 // 8 kHz internal sample rate
+)
+
+// Internal sample rate in Hz, before the sample rate divider.
+func (d DLPF) InternalRate() float64 {
+	if d == DLPF_250HZ || d == DLPF_3600HZ {
+		return 8000.0
+	}
+	return 1000.0
+}
+
+// Accelerometer digital low pass filter, the ACCEL_FCHOICE_B and A_DLPF_CFG
+// fields of ACCEL_CONFIG_2. The names are the accelerometer bandwidth.
+type AccelDLPF byte
+
+const (
+	ACCEL_DLPF_218HZ AccelDLPF = 1
+	ACCEL_DLPF_99HZ  AccelDLPF = 2
+	ACCEL_DLPF_45HZ  AccelDLPF = 3
+	ACCEL_DLPF_21HZ  AccelDLPF = 4
+	ACCEL_DLPF_10HZ  AccelDLPF = 5
+	ACCEL_DLPF_5HZ   AccelDLPF = 6
+	ACCEL_DLPF_420HZ AccelDLPF = 7
+	ACCEL_DLPF_OFF   AccelDLPF = PARAM_ACCEL_FCHOICE_b // 1.13 kHz bandwidth, 4 kHz rate
+)
+
+// Clock source, the CLKSEL field of PWR_MGMT_1.
+type ClockSource byte
+
+const (
+	CLOCK_INTERNAL ClockSource = 0 // internal 20 MHz oscillator
+	CLOCK_AUTO     ClockSource = 1 // PLL when ready, else the internal oscillator
+	CLOCK_STOP     ClockSource = 7 // stops the clock, keeps timing in reset
+)
+
+// Configuration registers, as recorded in the metadata of the acquisitions:
+// sampling, full scales, filters, FIFO, interrupts, auxiliary bus, power
+// and the offset registers.
+var CONFIG_REGISTERS = map[byte]string{
+	REG_XG_OFFSET_H:        "XG_OFFSET_H",
+	REG_XG_OFFSET_L:        "XG_OFFSET_L",
+	REG_YG_OFFSET_H:        "YG_OFFSET_H",
+	REG_YG_OFFSET_L:        "YG_OFFSET_L",
+	REG_ZG_OFFSET_H:        "ZG_OFFSET_H",
+	REG_ZG_OFFSET_L:        "ZG_OFFSET_L",
+	REG_SMPLRT_DIV:         "SMPLRT_DIV",
+	REG_CONFIG:             "CONFIG",
+	REG_GYRO_CONFIG:        "GYRO_CONFIG",
+	REG_ACCEL_CONFIG:       "ACCEL_CONFIG",
+	REG_ACCEL_CONFIG_2:     "ACCEL_CONFIG_2",
+	REG_FIFO_EN:            "FIFO_EN",
+	REG_I2C_MST_CTRL:       "I2C_MST_CTRL",
+	REG_I2C_SLV0_ADDR:      "I2C_SLV0_ADDR",
+	REG_I2C_SLV0_REG:       "I2C_SLV0_REG",
+	REG_I2C_SLV0_CTRL:      "I2C_SLV0_CTRL",
+	REG_INT_PIN_CFG:        "INT_PIN_CFG",
+	REG_INT_ENABLE:         "INT_ENABLE",
+	REG_I2C_MST_DELAY_CTRL: "I2C_MST_DELAY_CTRL",
+	REG_USER_CTRL:          "USER_CTRL",
+	REG_PWR_MGMT_1:         "PWR_MGMT_1",
+	REG_PWR_MGMT_2:         "PWR_MGMT_2",
+	REG_XA_OFFSET_H:        "XA_OFFSET_H",
+	REG_XA_OFFSET_L:        "XA_OFFSET_L",
+	REG_YA_OFFSET_H:        "YA_OFFSET_H",
+	REG_YA_OFFSET_L:        "YA_OFFSET_L",
+	REG_ZA_OFFSET_H:        "ZA_OFFSET_H",
+	REG_ZA_OFFSET_L:        "ZA_OFFSET_L",
+}
+
+// ReadConfig reads the configuration registers.
+func (mpu *MPU9250) ReadConfig() ([]i2c.Register, error) {
+	return i2c.ReadRegisters(mpu.i2c, CONFIG_REGISTERS)
+}
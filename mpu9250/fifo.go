@@ -0,0 +1,170 @@
+package mpu9250
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// FIFO_EN bits
+	PARAM_FIFO_TEMP   = 0x80
+	PARAM_FIFO_GYRO_X = 0x40
+	PARAM_FIFO_GYRO_Y = 0x20
+	PARAM_FIFO_GYRO_Z = 0x10
+	PARAM_FIFO_ACCEL  = 0x08
+	PARAM_FIFO_GYRO   = PARAM_FIFO_GYRO_X | PARAM_FIFO_GYRO_Y | PARAM_FIFO_GYRO_Z
+
+	// USER_CTRL bits
+	PARAM_USER_FIFO_EN    = 0x40
+	PARAM_USER_I2C_MST_EN = 0x20
+	PARAM_USER_FIFO_RST   = 0x04
+
+	// INT_ENABLE and INT_STATUS bits
+	PARAM_INT_FIFO_OFLOW  = 0x10
+	PARAM_INT_RAW_RDY     = 0x01
+	PARAM_FIFO_COUNT_MASK = 0x1f // FIFO_COUNTH
+
+	FIFO_SIZE       = 512 // bytes
+	FIFO_FRAME_SIZE = 12  // accel and gyro, 6 bytes each
+)
+
+// ErrFIFOOverflow is returned when the FIFO filled up before being read.
+// The FIFO is reset and the frames it held are lost.
+var ErrFIFOOverflow = errors.New("mpu9250: FIFO overflow")
+
+// A sample read from the FIFO, timestamped from the sample rate.
+type TimedSample struct {
+	Tim time.Time
+	Sample
+}
+
+// FIFOStream reads the accel and gyro frames the device pushes into its
+// FIFO at the configured sample rate. Timestamps are rebuilt from the
+// frame count, so consecutive samples are exactly one period apart.
+type FIFOStream struct {
+	mpu       *MPU9250
+	start     time.Time
+	period    time.Duration
+	n         int64 // frames since start, lost ones included
+	buf       []byte
+	Overflows int
+}
+
+// StartFIFO sets the sample rate (see SetSampleRate, the DLPF is switched
+// to DLPF_184HZ if it does not allow a divider) and starts pushing accel
+// and gyro frames into the FIFO.
+func (mpu *MPU9250) StartFIFO(rate float64) (*FIFOStream, error) {
+	if mpu.dlpf == DLPF_250HZ || mpu.dlpf == DLPF_3600HZ {
+		if err := mpu.SetDLPF(DLPF_184HZ); err != nil {
+			return nil, err
+		}
+	}
+	rate, err := mpu.SetSampleRate(rate)
+	if err != nil {
+		return nil, err
+	}
+	// stop instead of overwriting when full, so frames stay aligned
+	if err := mpu.updateReg(REG_CONFIG, PARAM_FIFO_MODE, PARAM_FIFO_MODE); err != nil {
+		return nil, err
+	}
+	if err := mpu.updateReg(REG_INT_ENABLE, PARAM_INT_FIFO_OFLOW, PARAM_INT_FIFO_OFLOW); err != nil {
+		return nil, err
+	}
+	if err := mpu.i2c.WriteRegU8(REG_FIFO_EN, PARAM_FIFO_ACCEL|PARAM_FIFO_GYRO); err != nil {
+		return nil, err
+	}
+	if err := mpu.ResetFIFO(); err != nil {
+		return nil, err
+	}
+	if err := mpu.updateReg(REG_USER_CTRL, PARAM_USER_FIFO_EN, PARAM_USER_FIFO_EN); err != nil {
+		return nil, err
+	}
+	return &FIFOStream{
+		mpu:    mpu,
+		start:  time.Now(),
+		period: time.Duration(float64(time.Second) / rate),
+		buf:    make([]byte, FIFO_SIZE),
+	}, nil
+}
+
+// ResetFIFO empties the FIFO.
+func (mpu *MPU9250) ResetFIFO() error {
+	return mpu.updateReg(REG_USER_CTRL, PARAM_USER_FIFO_RST, PARAM_USER_FIFO_RST)
+}
+
+// FIFOCount returns the number of bytes in the FIFO.
+func (mpu *MPU9250) FIFOCount() (int, error) {
+	buf := make([]byte, 2)
+	if _, err := mpu.i2c.Write([]byte{REG_FIFO_COUNTH}); err != nil {
+		return 0, err
+	}
+	if _, err := mpu.i2c.Read(buf); err != nil {
+		return 0, err
+	}
+	return int(buf[0]&PARAM_FIFO_COUNT_MASK)<<8 | int(buf[1]), nil
+}
+
+// Period between two samples.
+func (st *FIFOStream) Period() time.Duration {
+	return st.period
+}
+
+// Rate of the samples in Hz.
+func (st *FIFOStream) Rate() float64 {
+	return float64(time.Second) / float64(st.period)
+}
+
+// Read returns the complete frames in the FIFO, oldest first. On overflow
+// the FIFO is reset, the frame count is resynchronized with the clock and
+// ErrFIFOOverflow is returned.
+func (st *FIFOStream) Read() ([]TimedSample, error) {
+	status, err := st.mpu.i2c.ReadRegU8(REG_INT_STATUS)
+	if err != nil {
+		return nil, err
+	}
+	if status&PARAM_INT_FIFO_OFLOW != 0 {
+		st.Overflows++
+		if err := st.mpu.ResetFIFO(); err != nil {
+			return nil, err
+		}
+		st.n = int64(time.Since(st.start) / st.period)
+		return nil, ErrFIFOOverflow
+	}
+
+	count, err := st.mpu.FIFOCount()
+	if err != nil {
+		return nil, err
+	}
+	frames := count / FIFO_FRAME_SIZE
+	if frames == 0 {
+		return nil, nil
+	}
+	buf := st.buf[:frames*FIFO_FRAME_SIZE]
+	if _, err := st.mpu.i2c.Write([]byte{REG_FIFO_R_W}); err != nil {
+		return nil, err
+	}
+	if _, err := st.mpu.i2c.Read(buf); err != nil {
+		return nil, err
+	}
+
+	samples := make([]TimedSample, frames)
+	for i := range samples {
+		f := buf[i*FIFO_FRAME_SIZE:]
+		samples[i].Tim = st.start.Add(time.Duration(st.n) * st.period)
+		samples[i].Acc = ThreeDData{word(f, 0), word(f, 2), word(f, 4)}
+		samples[i].Gyr = ThreeDData{word(f, 6), word(f, 8), word(f, 10)}
+		st.n++
+	}
+	return samples, nil
+}
+
+// Stop disables the FIFO.
+func (st *FIFOStream) Stop() error {
+	if err := st.mpu.updateReg(REG_USER_CTRL, PARAM_USER_FIFO_EN, 0); err != nil {
+		return err
+	}
+	if err := st.mpu.i2c.WriteRegU8(REG_FIFO_EN, 0); err != nil {
+		return err
+	}
+	return st.mpu.ResetFIFO()
+}
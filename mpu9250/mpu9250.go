@@ -0,0 +1,299 @@
+// derived from d2r2, hathan-osman and mrmorphic
+
+// Package mpu9250 drives the accelerometer, gyroscope and temperature
+// sensor of the InvenSense MPU-9250 over an i2c.Bus.
+package mpu9250
+
+import (
+	"../i2c"
+	"fmt"
+	"time"
+)
+
+// Time the device needs to settle after a configuration write.
+const SETTLE_TIME = 10 * time.Millisecond
+
+type ThreeDData struct {
+	X int16
+	Y int16
+	Z int16
+}
+
+// Raw counts of one burst read of the sensor registers.
+type Sample struct {
+	Acc  ThreeDData //X, Y, Z  int16
+	Temp int16
+	Gyr  ThreeDData //X, Y, Z  int16
+}
+
+type MPU9250 struct {
+	i2c     i2c.Bus
+	accelFS AccelFS
+	gyroFS  GyroFS
+	dlpf    DLPF
+	div     byte
+	buf     []byte
+}
+
+// New drives the device behind dev with the given full scales, which are
+// written to the device by Config.
+func New(dev i2c.Bus, accelFS AccelFS, gyroFS GyroFS) *MPU9250 {
+	return &MPU9250{
+		i2c:     dev,
+		accelFS: accelFS,
+		gyroFS:  gyroFS,
+		dlpf:    DLPF_250HZ,
+		buf:     make([]byte, 14), //to store 14 bytes
+	}
+}
+
+// Open the device at the default address of the given bus.
+func Open(open i2c.Opener, bus int, accelFS AccelFS, gyroFS GyroFS) (*MPU9250, error) {
+	dev, err := open(DEVICE_ADDRESS, bus)
+	if err != nil {
+		return nil, err
+	}
+	return New(dev, accelFS, gyroFS), nil
+}
+
+// Bus returns the connection to the device.
+func (mpu *MPU9250) Bus() i2c.Bus {
+	return mpu.i2c
+}
+
+// Close the connection to the device.
+func (mpu *MPU9250) Close() error {
+	return mpu.i2c.Close()
+}
+
+// Read-modify-write the bits of reg selected by mask.
+func (mpu *MPU9250) updateReg(reg byte, mask byte, value byte) error {
+	v, err := mpu.i2c.ReadRegU8(reg)
+	if err != nil {
+		return err
+	}
+	v = v&^mask | value&mask
+	return mpu.i2c.WriteRegU8(reg, v)
+}
+
+// WhoAmI returns the content of the WHO_AM_I register.
+func (mpu *MPU9250) WhoAmI() (byte, error) {
+	return mpu.i2c.ReadRegU8(REG_WHO_AM_I)
+}
+
+// Verify checks that the device answers as a MPU-9250 (or MPU-9255).
+func (mpu *MPU9250) Verify() error {
+	id, err := mpu.WhoAmI()
+	if err != nil {
+		return err
+	}
+	if id != WHO_AM_I_MPU9250 && id != WHO_AM_I_MPU9255 {
+		return fmt.Errorf("mpu9250: unexpected WHO_AM_I %#02x", id)
+	}
+	return nil
+}
+
+// Reset all the registers to their default values. The device is asleep
+// afterwards.
+func (mpu *MPU9250) Reset() error {
+	if err := mpu.i2c.WriteRegU8(REG_PWR_MGMT_1, PARAM_H_RESET); err != nil {
+		return err
+	}
+	time.Sleep(100 * time.Millisecond)
+	mpu.dlpf = DLPF_250HZ
+	mpu.div = 0
+	return nil
+}
+
+// Wake the device. By default on power on, the device is asleep.
+// based on mrmorphic/hwio/gy520.go
+func (mpu *MPU9250) Wake() error {
+	return mpu.updateReg(REG_PWR_MGMT_1, PARAM_SLEEP, 0)
+}
+
+// Put the device back to sleep.
+// based on mrmorphic/hwio/gy520.go
+func (mpu *MPU9250) Sleep() error {
+	return mpu.updateReg(REG_PWR_MGMT_1, PARAM_SLEEP, PARAM_SLEEP)
+}
+
+// SetClockSource selects the clock of the device.
+func (mpu *MPU9250) SetClockSource(clk ClockSource) error {
+	return mpu.updateReg(REG_PWR_MGMT_1, PARAM_CLKSEL_MASK, byte(clk))
+}
+
+// SetAccelFS sets the accelerometer full scale.
+func (mpu *MPU9250) SetAccelFS(fs AccelFS) error {
+	if err := mpu.updateReg(REG_ACCEL_CONFIG, PARAM_ACCEL_FS_MASK, byte(fs)); err != nil {
+		return err
+	}
+	mpu.accelFS = fs
+	return nil
+}
+
+// AccelFS returns the accelerometer full scale.
+func (mpu *MPU9250) AccelFS() AccelFS {
+	return mpu.accelFS
+}
+
+// SetGyroFS sets the gyroscope full scale.
+func (mpu *MPU9250) SetGyroFS(fs GyroFS) error {
+	if err := mpu.updateReg(REG_GYRO_CONFIG, PARAM_GYRO_FS_MASK, byte(fs)); err != nil {
+		return err
+	}
+	mpu.gyroFS = fs
+	return nil
+}
+
+// GyroFS returns the gyroscope full scale.
+func (mpu *MPU9250) GyroFS() GyroFS {
+	return mpu.gyroFS
+}
+
+// SetDLPF sets the gyroscope and temperature low pass filter, which also
+// selects the internal sample rate. FCHOICE_B is cleared so the filter is
+// used.
+func (mpu *MPU9250) SetDLPF(dlpf DLPF) error {
+	if err := mpu.updateReg(REG_GYRO_CONFIG, PARAM_GYRO_FCHOICE_MASK, 0); err != nil {
+		return err
+	}
+	if err := mpu.updateReg(REG_CONFIG, PARAM_DLPF_CFG_MASK, byte(dlpf)); err != nil {
+		return err
+	}
+	mpu.dlpf = dlpf
+	return nil
+}
+
+// DLPF returns the gyroscope and temperature low pass filter.
+func (mpu *MPU9250) DLPF() DLPF {
+	return mpu.dlpf
+}
+
+// SetAccelDLPF sets the accelerometer low pass filter, ACCEL_DLPF_OFF
+// bypasses it.
+func (mpu *MPU9250) SetAccelDLPF(dlpf AccelDLPF) error {
+	return mpu.updateReg(REG_ACCEL_CONFIG_2, PARAM_ACCEL_CONFIG2_MASK, byte(dlpf))
+}
+
+// SetSampleRateDivider sets SMPLRT_DIV, the output rate is then
+// InternalRate/(1+div). It only applies when the DLPF is in use
+// (DLPF_184HZ to DLPF_5HZ).
+func (mpu *MPU9250) SetSampleRateDivider(div byte) error {
+	if err := mpu.i2c.WriteRegU8(REG_SMPLRT_DIV, div); err != nil {
+		return err
+	}
+	mpu.div = div
+	return nil
+}
+
+// SampleRate returns the output data rate in Hz for the current DLPF and
+// sample rate divider.
+func (mpu *MPU9250) SampleRate() float64 {
+	if mpu.dlpf == DLPF_250HZ || mpu.dlpf == DLPF_3600HZ {
+		return mpu.dlpf.InternalRate()
+	}
+	return mpu.dlpf.InternalRate() / float64(1+int(mpu.div))
+}
+
+// SetSampleRate picks the divider closest to the rate in Hz, given the
+// current DLPF, and returns the rate actually set.
+func (mpu *MPU9250) SetSampleRate(rate float64) (float64, error) {
+	if rate <= 0 {
+		return 0, fmt.Errorf("mpu9250: invalid sample rate %f", rate)
+	}
+	div := mpu.dlpf.InternalRate()/rate - 1
+	if div < 0 {
+		div = 0
+	}
+	if div > 255 {
+		div = 255
+	}
+	if err := mpu.SetSampleRateDivider(byte(div + 0.5)); err != nil {
+		return 0, err
+	}
+	return mpu.SampleRate(), nil
+}
+
+// Config writes the full scales and bypasses the accelerometer DLPF to get
+// the maximum data rate.
+func (mpu *MPU9250) Config() error {
+	if err := mpu.SetAccelFS(mpu.accelFS); err != nil {
+		return err
+	}
+	time.Sleep(SETTLE_TIME)
+
+	//Setup acc data rates to maximun avoiding DLPF
+	if err := mpu.SetAccelDLPF(ACCEL_DLPF_OFF); err != nil {
+		return err
+	}
+	time.Sleep(SETTLE_TIME)
+
+	if err := mpu.SetGyroFS(mpu.gyroFS); err != nil {
+		return err
+	}
+	time.Sleep(SETTLE_TIME)
+
+	return nil
+}
+
+// Decode the big endian two's complement word at buf[i].
+func word(buf []byte, i int) int16 {
+	return int16(uint16(buf[i])<<8 | uint16(buf[i+1]))
+}
+
+// ReadSample reads accelerometer, temperature and gyroscope in one
+// 14 bytes burst starting at ACCEL_XOUT_H.
+func (mpu *MPU9250) ReadSample() (Sample, error) {
+	var s Sample
+	if _, err := mpu.i2c.Write([]byte{REG_ACCEL_XOUT_H}); err != nil {
+		return s, err
+	}
+	if _, err := mpu.i2c.Read(mpu.buf); err != nil {
+		return s, err
+	}
+	s.Acc = ThreeDData{word(mpu.buf, 0), word(mpu.buf, 2), word(mpu.buf, 4)}
+	s.Temp = word(mpu.buf, 6)
+	s.Gyr = ThreeDData{word(mpu.buf, 8), word(mpu.buf, 10), word(mpu.buf, 12)}
+	return s, nil
+}
+
+// Read three consecutive words starting at reg.
+func (mpu *MPU9250) readThreeD(reg byte) (ThreeDData, error) {
+	buf := make([]byte, 6)
+	if _, err := mpu.i2c.Write([]byte{reg}); err != nil {
+		return ThreeDData{}, err
+	}
+	if _, err := mpu.i2c.Read(buf); err != nil {
+		return ThreeDData{}, err
+	}
+	return ThreeDData{word(buf, 0), word(buf, 2), word(buf, 4)}, nil
+}
+
+// GetAccel reads the accelerometer raw counts.
+func (mpu *MPU9250) GetAccel() (ThreeDData, error) {
+	return mpu.readThreeD(REG_ACCEL_XOUT_H)
+}
+
+// GetGyro reads the gyroscope raw counts.
+func (mpu *MPU9250) GetGyro() (ThreeDData, error) {
+	return mpu.readThreeD(REG_GYRO_XOUT_H)
+}
+
+// GetTemp reads the temperature raw counts.
+func (mpu *MPU9250) GetTemp() (int16, error) {
+	return mpu.i2c.ReadRegS16BE(REG_TEMP_OUT_H)
+}
+
+// Temperature reads the die temperature in degrees Celsius.
+func (mpu *MPU9250) Temperature() (float64, error) {
+	t, err := mpu.GetTemp()
+	if err != nil {
+		return 0, err
+	}
+	return TempCelsius(t), nil
+}
+
+// TempCelsius converts temperature raw counts to degrees Celsius.
+func TempCelsius(t int16) float64 {
+	return (float64(t)-TEMP_OFFSET)/TEMP_SENSITIVITY + TEMP_ROOM
+}
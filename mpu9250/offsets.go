@@ -0,0 +1,120 @@
+package mpu9250
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// XA_OFFSET_L bits, the reserved one keeps the factory temperature
+	// compensation and must be written back as read
+	PARAM_ACCEL_OFFSET_RESERVED = 0x01
+
+	// sensitivities of the offset registers, whatever the full scales: the
+	// accelerometer ones count in the ±16 g scale, over the 16 bits of the
+	// register, the gyroscope ones in the ±1000 dps scale
+	ACCEL_OFFSET_SENSITIVITY = 2048.0 // LSB/g
+	GYRO_OFFSET_SENSITIVITY  = 32.8   // LSB/(o/s)
+)
+
+var (
+	accelOffsetRegs = [3]byte{REG_XA_OFFSET_H, REG_YA_OFFSET_H, REG_ZA_OFFSET_H}
+	gyroOffsetRegs  = [3]byte{REG_XG_OFFSET_H, REG_YG_OFFSET_H, REG_ZG_OFFSET_H}
+)
+
+// Offsets of the offset registers, added by the device to its outputs. The
+// accelerometer ones hold the factory trim on power on, the gyroscope ones
+// are 0. The device forgets them when powered off.
+type Offsets struct {
+	Accel ThreeDData
+	Gyro  ThreeDData
+}
+
+// NewOffsets returns the offsets of the register counts, X, Y, Z.
+func NewOffsets(acc, gyr [3]int16) Offsets {
+	return Offsets{Accel: threeD(acc), Gyro: threeD(gyr)}
+}
+
+// Counts of the registers, X, Y, Z.
+func (o Offsets) Counts() (acc, gyr [3]int16) {
+	return [3]int16{o.Accel.X, o.Accel.Y, o.Accel.Z}, [3]int16{o.Gyro.X, o.Gyro.Y, o.Gyro.Z}
+}
+
+func threeD(v [3]int16) ThreeDData {
+	return ThreeDData{v[0], v[1], v[2]}
+}
+
+// ReadOffsets reads the offset registers.
+func (mpu *MPU9250) ReadOffsets() (Offsets, error) {
+	var acc, gyr [3]int16
+	for i := range acc {
+		var err error
+		if acc[i], err = mpu.i2c.ReadRegS16BE(accelOffsetRegs[i]); err != nil {
+			return Offsets{}, err
+		}
+		if gyr[i], err = mpu.i2c.ReadRegS16BE(gyroOffsetRegs[i]); err != nil {
+			return Offsets{}, err
+		}
+	}
+	return NewOffsets(acc, gyr), nil
+}
+
+// WriteOffsets writes the offset registers, keeping the reserved bit of
+// the accelerometer ones as the device has it, and reads them back to
+// check the device took them. It returns the offsets written.
+func (mpu *MPU9250) WriteOffsets(o Offsets) (Offsets, error) {
+	cur, err := mpu.ReadOffsets()
+	if err != nil {
+		return Offsets{}, err
+	}
+	acc, gyr := o.Counts()
+	curAcc, _ := cur.Counts()
+	for i, v := range curAcc {
+		acc[i] = acc[i]&^PARAM_ACCEL_OFFSET_RESERVED | v&PARAM_ACCEL_OFFSET_RESERVED
+		if err := mpu.i2c.WriteRegS16BE(accelOffsetRegs[i], acc[i]); err != nil {
+			return Offsets{}, err
+		}
+		if err := mpu.i2c.WriteRegS16BE(gyroOffsetRegs[i], gyr[i]); err != nil {
+			return Offsets{}, err
+		}
+	}
+	written := NewOffsets(acc, gyr)
+	got, err := mpu.ReadOffsets()
+	if err != nil {
+		return Offsets{}, err
+	}
+	if got != written {
+		return Offsets{}, fmt.Errorf("mpu9250: offsets written %v, read back %v", written, got)
+	}
+	return written, nil
+}
+
+// Cancel returns the offsets that cancel biases measured with the current
+// ones, the accelerometer ones in g and the gyroscope ones in o/s. The
+// accelerometer offsets move by steps of 2 LSB, so the reserved bit is
+// left as it is.
+func (o Offsets) Cancel(accBias, gyrBias [3]float64) Offsets {
+	acc, gyr := o.Counts()
+	for i := range acc {
+		acc[i] = addCounts(acc[i], -2*math.Round(accBias[i]*ACCEL_OFFSET_SENSITIVITY/2))
+		gyr[i] = addCounts(gyr[i], -math.Round(gyrBias[i]*GYRO_OFFSET_SENSITIVITY))
+	}
+	return NewOffsets(acc, gyr)
+}
+
+// Add counts to a register, saturating at the int16 range.
+func addCounts(v int16, d float64) int16 {
+	return int16(max(math.MinInt16, min(math.MaxInt16, float64(v)+d)))
+}
+
+// Sub returns the offsets added to the outputs by o over the ones of
+// from, the accelerations in g and the rotation rates in o/s.
+func (o Offsets) Sub(from Offsets) (acc, gyr [3]float64) {
+	oa, og := o.Counts()
+	fa, fg := from.Counts()
+	for i := range acc {
+		acc[i] = float64(int(oa[i]&^PARAM_ACCEL_OFFSET_RESERVED)-int(fa[i]&^PARAM_ACCEL_OFFSET_RESERVED)) / ACCEL_OFFSET_SENSITIVITY
+		gyr[i] = float64(int(og[i])-int(fg[i])) / GYRO_OFFSET_SENSITIVITY
+	}
+	return acc, gyr
+}
@@ -0,0 +1,136 @@
+package mpu9250
+
+// Register map, from the MPU-9250 Register Map and Descriptions rev 1.6
+const (
+	// This is the default address. Some devices may also respond to 0x69
+	DEVICE_ADDRESS     = 0x68
+	DEVICE_ADDRESS_ALT = 0x69
+
+	// self test registers
+	REG_SELF_TEST_X_GYRO  = 0x00
+	REG_SELF_TEST_Y_GYRO  = 0x01
+	REG_SELF_TEST_Z_GYRO  = 0x02
+	REG_SELF_TEST_X_ACCEL = 0x0d
+	REG_SELF_TEST_Y_ACCEL = 0x0e
+	REG_SELF_TEST_Z_ACCEL = 0x0f
+
+	// gyroscope offset registers, 16 bits two's complement
+	REG_XG_OFFSET_H = 0x13
+	REG_XG_OFFSET_L = 0x14
+	REG_YG_OFFSET_H = 0x15
+	REG_YG_OFFSET_L = 0x16
+	REG_ZG_OFFSET_H = 0x17
+	REG_ZG_OFFSET_L = 0x18
+
+	REG_SMPLRT_DIV     = 0x19
+	REG_CONFIG         = 0x1a
+	REG_GYRO_CONFIG    = 0x1b
+	REG_ACCEL_CONFIG   = 0x1c
+	REG_ACCEL_CONFIG_2 = 0x1d
+	REG_LP_ACCEL_ODR   = 0x1e
+	REG_WOM_THR        = 0x1f
+	REG_FIFO_EN        = 0x23
+
+	// auxiliary i2c master
+	REG_I2C_MST_CTRL   = 0x24
+	REG_I2C_SLV0_ADDR  = 0x25
+	REG_I2C_SLV0_REG   = 0x26
+	REG_I2C_SLV0_CTRL  = 0x27
+	REG_I2C_SLV1_ADDR  = 0x28
+	REG_I2C_SLV1_REG   = 0x29
+	REG_I2C_SLV1_CTRL  = 0x2a
+	REG_I2C_SLV2_ADDR  = 0x2b
+	REG_I2C_SLV2_REG   = 0x2c
+	REG_I2C_SLV2_CTRL  = 0x2d
+	REG_I2C_SLV3_ADDR  = 0x2e
+	REG_I2C_SLV3_REG   = 0x2f
+	REG_I2C_SLV3_CTRL  = 0x30
+	REG_I2C_SLV4_ADDR  = 0x31
+	REG_I2C_SLV4_REG   = 0x32
+	REG_I2C_SLV4_DO    = 0x33
+	REG_I2C_SLV4_CTRL  = 0x34
+	REG_I2C_SLV4_DI    = 0x35
+	REG_I2C_MST_STATUS = 0x36
+
+	// interrupts
+	REG_INT_PIN_CFG = 0x37
+	REG_INT_ENABLE  = 0x38
+	REG_INT_STATUS  = 0x3a
+
+	// accelerometer sensor registers, read-only
+	REG_ACCEL_XOUT_H = 0x3b
+	REG_ACCEL_XOUT_L = 0x3c
+	REG_ACCEL_YOUT_H = 0x3d
+	REG_ACCEL_YOUT_L = 0x3e
+	REG_ACCEL_ZOUT_H = 0x3f
+	REG_ACCEL_ZOUT_L = 0x40
+
+	// temperature sensor registers, read-only
+	REG_TEMP_OUT_H = 0x41
+	REG_TEMP_OUT_L = 0x42
+
+	// gyroscope sensor registers, read-only
+	REG_GYRO_XOUT_H = 0x43
+	REG_GYRO_XOUT_L = 0x44
+	REG_GYRO_YOUT_H = 0x45
+	REG_GYRO_YOUT_L = 0x46
+	REG_GYRO_ZOUT_H = 0x47
+	REG_GYRO_ZOUT_L = 0x48
+
+	// data read by the i2c master from the auxiliary sensors, 24 registers
+	REG_EXT_SENS_DATA_00 = 0x49
+	REG_EXT_SENS_DATA_23 = 0x60
+
+	REG_I2C_SLV0_DO        = 0x63
+	REG_I2C_SLV1_DO        = 0x64
+	REG_I2C_SLV2_DO        = 0x65
+	REG_I2C_SLV3_DO        = 0x66
+	REG_I2C_MST_DELAY_CTRL = 0x67
+	REG_SIGNAL_PATH_RESET  = 0x68
+	REG_MOT_DETECT_CTRL    = 0x69
+	REG_USER_CTRL          = 0x6a
+	REG_PWR_MGMT_1         = 0x6b
+	REG_PWR_MGMT_2         = 0x6c
+	REG_FIFO_COUNTH        = 0x72
+	REG_FIFO_COUNTL        = 0x73
+	REG_FIFO_R_W           = 0x74
+	REG_WHO_AM_I           = 0x75
+
+	// accelerometer offset registers, 15 bits, bit 0 of the low byte is reserved
+	REG_XA_OFFSET_H = 0x77
+	REG_XA_OFFSET_L = 0x78
+	REG_YA_OFFSET_H = 0x7a
+	REG_YA_OFFSET_L = 0x7b
+	REG_ZA_OFFSET_H = 0x7d
+	REG_ZA_OFFSET_L = 0x7e
+
+	// PWR_MGMT_1 bits
+	PARAM_H_RESET     = 0x80
+	PARAM_SLEEP       = 0x40
+	PARAM_CLKSEL_MASK = 0x07
+
+	// CONFIG bits
+	PARAM_FIFO_MODE     = 0x40
+	PARAM_DLPF_CFG_MASK = 0x07
+
+	// GYRO_CONFIG bits
+	PARAM_GYRO_FS_MASK      = 0x18
+	PARAM_GYRO_FCHOICE_MASK = 0x03
+
+	// ACCEL_CONFIG bits
+	PARAM_ACCEL_FS_MASK = 0x18
+
+	// ACCEL_CONFIG_2 bits
+	PARAM_ACCEL_FCHOICE_b    = 0x08
+	PARAM_A_DLPF_CFG_MASK    = 0x07
+	PARAM_ACCEL_CONFIG2_MASK = 0x0f
+
+	// WHO_AM_I values
+	WHO_AM_I_MPU9250 = 0x71
+	WHO_AM_I_MPU9255 = 0x73
+
+	// temperature conversion, TEMP_degC = (TEMP_OUT - offset)/sensitivity + 21
+	TEMP_SENSITIVITY = 333.87
+	TEMP_OFFSET      = 0.0
+	TEMP_ROOM        = 21.0
+)
@@ -0,0 +1,231 @@
+package mpu9250
+
+import (
+	"../i2c"
+	"math"
+	"sync"
+	"time"
+)
+
+// A simulated MPU-9250 on top of a register file.
+type simMPU struct {
+	dev  *i2c.SimDevice
+	aux  *i2c.Sim
+	fn   func() Sample
+	mu   sync.Mutex
+	fifo []byte
+	last time.Time // last time frames were pushed into the FIFO
+	due  float64   // frames owed to the FIFO since last
+	oflo bool      // FIFO overflow not yet reported in INT_STATUS
+}
+
+// Factory trim of the simulated accelerometer offset registers.
+var simAccelTrim = [3]int16{0x0b4d, -0x0d2a, 0x1a17}
+
+// Simulate makes a simulated i2c device behave like a MPU-9250 that has
+// just been powered on: it answers WHO_AM_I, starts asleep, honours
+// H_RESET and fills its FIFO at the configured sample rate. The sensor
+// values come from fn, called on every read of the sensor registers and
+// for every frame pushed into the FIFO, and are moved by the offset
+// registers written over the factory trim. The i2c master reaches the devices
+// of aux, if not nil; SLV0 reads them when EXT_SENS_DATA is read.
+func Simulate(dev *i2c.SimDevice, aux *i2c.Sim, fn func() Sample) {
+	sim := &simMPU{dev: dev, aux: aux, fn: fn}
+	sim.powerOn()
+
+	dev.OnWrite(sim.onWrite)
+
+	gen := func() []byte { return encodeSample(sim.sample()) }
+	dev.Generate(REG_ACCEL_XOUT_H, gen)
+	dev.Generate(REG_GYRO_XOUT_H, func() []byte { return gen()[8:] })
+	dev.Generate(REG_TEMP_OUT_H, func() []byte { return gen()[6:8] })
+	dev.Generate(REG_FIFO_COUNTH, sim.fifoCount)
+	dev.Generate(REG_INT_STATUS, sim.intStatus)
+	dev.Stream(REG_FIFO_R_W, sim.fifoRead)
+	dev.Generate(REG_EXT_SENS_DATA_00, sim.slv0Read)
+}
+
+func (sim *simMPU) powerOn() {
+	for reg := 0; reg < 256; reg++ {
+		sim.dev.Set(byte(reg), 0)
+	}
+	sim.dev.Set(REG_WHO_AM_I, WHO_AM_I_MPU9250)
+	for i, reg := range accelOffsetRegs {
+		sim.dev.Set(reg, byte(uint16(simAccelTrim[i])>>8))
+		sim.dev.Set(reg+1, byte(simAccelTrim[i]))
+	}
+	sim.dev.Set(REG_PWR_MGMT_1, PARAM_SLEEP)
+	sim.resetFIFO()
+}
+
+func (sim *simMPU) onWrite(reg byte, value byte) {
+	switch {
+	case reg == REG_PWR_MGMT_1 && value&PARAM_H_RESET != 0:
+		sim.powerOn()
+	case reg == REG_USER_CTRL && value&PARAM_USER_FIFO_RST != 0:
+		// self clearing bit
+		sim.dev.Set(REG_USER_CTRL, value&^PARAM_USER_FIFO_RST)
+		sim.resetFIFO()
+	case reg == REG_I2C_SLV4_CTRL && value&PARAM_I2C_SLV_EN != 0:
+		sim.slv4Transfer()
+	}
+}
+
+// Open the auxiliary device at the address of a I2C_SLVx_ADDR register if
+// the i2c master is enabled.
+func (sim *simMPU) auxDevice(addrReg byte) (i2c.Bus, bool) {
+	if sim.aux == nil || sim.dev.Get(REG_USER_CTRL)&PARAM_USER_I2C_MST_EN == 0 {
+		return nil, false
+	}
+	dev, err := sim.aux.Open(sim.dev.Get(addrReg)&^PARAM_I2C_SLV_RNW, 0)
+	return dev, err == nil
+}
+
+// SLV4 single byte transfer, completed right away.
+func (sim *simMPU) slv4Transfer() {
+	sim.dev.Set(REG_I2C_SLV4_CTRL, sim.dev.Get(REG_I2C_SLV4_CTRL)&^PARAM_I2C_SLV_EN)
+	dev, ok := sim.auxDevice(REG_I2C_SLV4_ADDR)
+	if !ok {
+		sim.dev.Set(REG_I2C_MST_STATUS, PARAM_I2C_SLV4_DONE|PARAM_I2C_SLV4_NACK)
+		return
+	}
+	reg := sim.dev.Get(REG_I2C_SLV4_REG)
+	if sim.dev.Get(REG_I2C_SLV4_ADDR)&PARAM_I2C_SLV_RNW != 0 {
+		v, _ := dev.ReadRegU8(reg)
+		sim.dev.Set(REG_I2C_SLV4_DI, v)
+	} else {
+		dev.WriteRegU8(reg, sim.dev.Get(REG_I2C_SLV4_DO))
+	}
+	sim.dev.Set(REG_I2C_MST_STATUS, PARAM_I2C_SLV4_DONE)
+}
+
+// SLV0 read into EXT_SENS_DATA.
+func (sim *simMPU) slv0Read() []byte {
+	ctrl := sim.dev.Get(REG_I2C_SLV0_CTRL)
+	if ctrl&PARAM_I2C_SLV_EN == 0 {
+		return nil
+	}
+	dev, ok := sim.auxDevice(REG_I2C_SLV0_ADDR)
+	if !ok {
+		return nil
+	}
+	buf := make([]byte, ctrl&PARAM_I2C_SLV_LENG_MASK)
+	if _, err := dev.Write([]byte{sim.dev.Get(REG_I2C_SLV0_REG)}); err != nil {
+		return nil
+	}
+	dev.Read(buf)
+	return buf
+}
+
+func (sim *simMPU) resetFIFO() {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.fifo = sim.fifo[:0]
+	sim.last = time.Now()
+	sim.due = 0
+	sim.oflo = false
+}
+
+// Output data rate given the registers, as SampleRate does.
+func (sim *simMPU) rate() float64 {
+	dlpf := DLPF(sim.dev.Get(REG_CONFIG) & PARAM_DLPF_CFG_MASK)
+	if dlpf == DLPF_250HZ || dlpf == DLPF_3600HZ {
+		return dlpf.InternalRate()
+	}
+	return dlpf.InternalRate() / float64(1+int(sim.dev.Get(REG_SMPLRT_DIV)))
+}
+
+// Push the frames produced since the last fill into the FIFO.
+func (sim *simMPU) fill() {
+	enabled := sim.dev.Get(REG_USER_CTRL)&PARAM_USER_FIFO_EN != 0 &&
+		sim.dev.Get(REG_FIFO_EN)&(PARAM_FIFO_ACCEL|PARAM_FIFO_GYRO) == PARAM_FIFO_ACCEL|PARAM_FIFO_GYRO
+	rate := sim.rate()
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	now := time.Now()
+	sim.due += now.Sub(sim.last).Seconds() * rate
+	sim.last = now
+	if !enabled {
+		sim.due = 0
+		return
+	}
+	for ; sim.due >= 1; sim.due-- {
+		if len(sim.fifo)+FIFO_FRAME_SIZE > FIFO_SIZE {
+			sim.oflo = true
+			if sim.dev.Get(REG_CONFIG)&PARAM_FIFO_MODE != 0 {
+				sim.due = 0
+				return
+			}
+			sim.fifo = sim.fifo[FIFO_FRAME_SIZE:]
+		}
+		frame := encodeSample(sim.sample())
+		sim.fifo = append(sim.fifo, frame[:6]...)
+		sim.fifo = append(sim.fifo, frame[8:]...)
+	}
+}
+
+func (sim *simMPU) fifoCount() []byte {
+	sim.fill()
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return []byte{byte(len(sim.fifo) >> 8), byte(len(sim.fifo))}
+}
+
+// INT_STATUS is cleared by reading it.
+func (sim *simMPU) intStatus() []byte {
+	sim.fill()
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	status := byte(PARAM_INT_RAW_RDY)
+	if sim.oflo {
+		status |= PARAM_INT_FIFO_OFLOW
+		sim.oflo = false
+	}
+	return []byte{status}
+}
+
+func (sim *simMPU) fifoRead(n int) []byte {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if n > len(sim.fifo) {
+		n = len(sim.fifo)
+	}
+	out := append([]byte(nil), sim.fifo[:n]...)
+	sim.fifo = sim.fifo[n:]
+	return out
+}
+
+// A sample of fn moved by the offset registers, as the device outputs it
+// at the configured full scales.
+func (sim *simMPU) sample() Sample {
+	s := sim.fn()
+	reg := func(r byte) int16 {
+		return int16(uint16(sim.dev.Get(r))<<8 | uint16(sim.dev.Get(r+1)))
+	}
+	o := Offsets{
+		Accel: ThreeDData{reg(REG_XA_OFFSET_H), reg(REG_YA_OFFSET_H), reg(REG_ZA_OFFSET_H)},
+		Gyro:  ThreeDData{reg(REG_XG_OFFSET_H), reg(REG_YG_OFFSET_H), reg(REG_ZG_OFFSET_H)},
+	}
+	acc, gyr := o.Sub(Offsets{Accel: threeD(simAccelTrim)})
+	accSens := AccelFS(sim.dev.Get(REG_ACCEL_CONFIG) & PARAM_ACCEL_FS_MASK).Sensitivity()
+	gyrSens := GyroFS(sim.dev.Get(REG_GYRO_CONFIG) & PARAM_GYRO_FS_MASK).Sensitivity()
+	move := func(v ThreeDData, d [3]float64, sens float64) ThreeDData {
+		return ThreeDData{
+			addCounts(v.X, math.Round(d[0]*sens)),
+			addCounts(v.Y, math.Round(d[1]*sens)),
+			addCounts(v.Z, math.Round(d[2]*sens)),
+		}
+	}
+	s.Acc, s.Gyr = move(s.Acc, acc, accSens), move(s.Gyr, gyr, gyrSens)
+	return s
+}
+
+// Encode a sample as the 14 sensor registers starting at ACCEL_XOUT_H.
+func encodeSample(s Sample) []byte {
+	buf := make([]byte, 0, 14)
+	for _, v := range []int16{s.Acc.X, s.Acc.Y, s.Acc.Z, s.Temp, s.Gyr.X, s.Gyr.Y, s.Gyr.Z} {
+		buf = append(buf, byte(uint16(v)>>8), byte(v))
+	}
+	return buf
+}
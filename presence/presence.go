@@ -0,0 +1,146 @@
+// Package presence turns the IR and capacitive sensors of the knob into
+// debounced touch/release events, waiting on GPIO edges (or the MPR121 IRQ
+// line) instead of busy-polling, so the IMU can be sampled at its own rate.
+package presence
+
+import (
+	"../gpio"
+	"time"
+)
+
+const (
+	// Poll period used when the pin can not report edges.
+	POLL_PERIOD = 5 * time.Millisecond
+	// How often Run checks whether it has been stopped.
+	STOP_CHECK = 100 * time.Millisecond
+)
+
+// Event is a change of presence, Present is true on touch and false on release.
+type Event struct {
+	Present bool
+	Time    time.Time // when the change was first seen, before debouncing
+}
+
+// Detector reports the changes of a presence level as events.
+type Detector struct {
+	// Read returns the current presence level.
+	Read func() (bool, error)
+	// Wait blocks until the level may have changed or timeout expires,
+	// reporting whether something happened.
+	Wait func(timeout time.Duration) (bool, error)
+	// A change is only reported once the level has been stable this long.
+	Debounce time.Duration
+}
+
+// FromPin builds a Detector over an input pin (the IR sensor) that is
+// present when it reads active. Edge capable pins are waited on, other
+// pins are polled every POLL_PERIOD.
+func FromPin(pin gpio.Pin, active gpio.Value, debounce time.Duration) *Detector {
+	read := func() (bool, error) {
+		v, err := pin.Read()
+		return v == active, err
+	}
+	d := &Detector{Read: read, Debounce: debounce}
+	if ep, ok := pin.(gpio.EdgePin); ok && ep.SetEdge(gpio.BOTH) == nil {
+		d.Wait = ep.WaitForEdge
+	} else {
+		d.Wait = pollWait(read)
+	}
+	return d
+}
+
+// FromTouch builds a Detector over a capacitive sensor. touched reads the
+// touch status; irq is the active-low MPR121 IRQ line, asserted on every
+// touch status change and released when the status is read. Without irq
+// the touch status is polled every POLL_PERIOD.
+func FromTouch(touched func() (bool, error), irq gpio.Pin, debounce time.Duration) *Detector {
+	d := &Detector{Read: touched, Debounce: debounce}
+	if ep, ok := irq.(gpio.EdgePin); ok && ep.SetEdge(gpio.FALLING) == nil {
+		d.Wait = func(timeout time.Duration) (bool, error) {
+			// the status read that follows releases the line
+			return ep.WaitForEdge(timeout)
+		}
+	} else {
+		d.Wait = pollWait(touched)
+	}
+	return d
+}
+
+// pollWait waits by reading the level every POLL_PERIOD until it differs
+// from the previous one.
+func pollWait(read func() (bool, error)) func(time.Duration) (bool, error) {
+	last, _ := read()
+	return func(timeout time.Duration) (bool, error) {
+		deadline := time.Now().Add(timeout)
+		for {
+			now, err := read()
+			if err != nil {
+				return false, err
+			}
+			if now != last {
+				last = now
+				return true, nil
+			}
+			if timeout >= 0 && time.Now().After(deadline) {
+				return false, nil
+			}
+			time.Sleep(POLL_PERIOD)
+		}
+	}
+}
+
+// Run sends an event on every debounced change of the level until stop is
+// closed. If the level is present when it starts, a touch is sent first.
+func (d *Detector) Run(events chan<- Event, stop <-chan struct{}) error {
+	level, err := d.Read()
+	if err != nil {
+		return err
+	}
+	if level {
+		select {
+		case events <- Event{Present: true, Time: time.Now()}:
+		case <-stop:
+			return nil
+		}
+	}
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		changed, err := d.Wait(STOP_CHECK)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+		seen := time.Now()
+
+		// debounce, wait for the level to settle
+		for d.Debounce > 0 {
+			bounced, err := d.Wait(d.Debounce)
+			if err != nil {
+				return err
+			}
+			if !bounced {
+				break
+			}
+		}
+
+		now, err := d.Read()
+		if err != nil {
+			return err
+		}
+		if now != level {
+			level = now
+			select {
+			case events <- Event{Present: level, Time: seen}:
+			case <-stop:
+				return nil
+			}
+		}
+	}
+}
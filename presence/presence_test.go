@@ -0,0 +1,175 @@
+package presence
+
+import (
+	"../gpio"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const ms = time.Millisecond
+
+// pollPin hides the edge detection of a pin, so it is polled.
+type pollPin struct {
+	gpio.Pin
+}
+
+// collect runs the detector d for run and returns the events it sent.
+func collect(t *testing.T, d *Detector, run time.Duration) []Event {
+	t.Helper()
+	events := make(chan Event, 16)
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Run(events, stop)
+	}()
+	time.Sleep(run)
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	close(events)
+	var got []Event
+	for ev := range events {
+		got = append(got, ev)
+	}
+	return got
+}
+
+func levels(events []Event) []bool {
+	var out []bool
+	for _, ev := range events {
+		out = append(out, ev.Present)
+	}
+	return out
+}
+
+func TestFromPin(t *testing.T) {
+	tests := []struct {
+		name  string
+		edges []gpio.FakeEdge
+		poll  bool
+		want  []bool
+	}{
+		{
+			name: "touch and release",
+			edges: []gpio.FakeEdge{
+				{After: 20 * ms, Value: gpio.HIGH},
+				{After: 120 * ms, Value: gpio.LOW},
+			},
+			want: []bool{true, false},
+		},
+		{
+			name: "bouncing touch",
+			edges: []gpio.FakeEdge{
+				{After: 20 * ms, Value: gpio.HIGH},
+				{After: 22 * ms, Value: gpio.LOW},
+				{After: 24 * ms, Value: gpio.HIGH},
+				{After: 26 * ms, Value: gpio.LOW},
+				{After: 28 * ms, Value: gpio.HIGH},
+				{After: 150 * ms, Value: gpio.LOW},
+			},
+			want: []bool{true, false},
+		},
+		{
+			name: "glitch shorter than the debounce",
+			edges: []gpio.FakeEdge{
+				{After: 20 * ms, Value: gpio.HIGH},
+				{After: 25 * ms, Value: gpio.LOW},
+			},
+			want: nil,
+		},
+		{
+			name:  "present at the start",
+			edges: []gpio.FakeEdge{{After: 0, Value: gpio.HIGH}, {After: 80 * ms, Value: gpio.LOW}},
+			want:  []bool{true, false},
+		},
+		{
+			name: "polled",
+			edges: []gpio.FakeEdge{
+				{After: 20 * ms, Value: gpio.HIGH},
+				{After: 120 * ms, Value: gpio.LOW},
+			},
+			poll: true,
+			want: []bool{true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pin := gpio.NewFakePin(22, gpio.IN)
+			pin.Script(tt.edges...)
+			var p gpio.Pin = pin
+			if tt.poll {
+				p = pollPin{pin}
+			}
+			start := time.Now() //of the script, on the first read
+			got := collect(t, FromPin(p, gpio.HIGH, 15*ms), 250*ms)
+			if l := levels(got); !reflect.DeepEqual(l, tt.want) {
+				t.Errorf("events %v, want %v", l, tt.want)
+			}
+			//the time of an event is the first change, before debouncing
+			if len(got) == 2 && len(tt.edges) > 1 && tt.edges[0].After > 0 {
+				if touch := got[0].Time.Sub(start); touch < tt.edges[0].After || touch > tt.edges[0].After+15*ms {
+					t.Errorf("touch at %v, want about %v", touch, tt.edges[0].After)
+				}
+			}
+		})
+	}
+}
+
+func TestActiveLow(t *testing.T) {
+	pin := gpio.NewFakePin(22, gpio.IN)
+	pin.Script(gpio.FakeEdge{After: 0, Value: gpio.HIGH}, gpio.FakeEdge{After: 30 * ms, Value: gpio.LOW})
+	got := collect(t, FromPin(pin, gpio.LOW, 10*ms), 100*ms)
+	if l := levels(got); !reflect.DeepEqual(l, []bool{true}) {
+		t.Errorf("events %v, want [true]", l)
+	}
+}
+
+// The touch status is read when the IRQ line falls, as the MPR121 asserts
+// it on every change of the status and releases it when the status is read.
+func TestFromTouch(t *testing.T) {
+	var touched atomic.Bool
+	irq := gpio.NewFakePin(17, gpio.IN)
+	irq.Set(gpio.HIGH)
+	status := func() (bool, error) {
+		irq.Set(gpio.HIGH)
+		return touched.Load(), nil
+	}
+	d := FromTouch(status, irq, 10*ms)
+	go func() {
+		time.Sleep(20 * ms)
+		touched.Store(true)
+		irq.Set(gpio.LOW)
+		time.Sleep(60 * ms)
+		touched.Store(false)
+		irq.Set(gpio.LOW)
+	}()
+	got := collect(t, d, 150*ms)
+	if l := levels(got); !reflect.DeepEqual(l, []bool{true, false}) {
+		t.Errorf("events %v, want [true false]", l)
+	}
+}
+
+// Run stops even when nobody takes its events.
+func TestStopWithoutConsumer(t *testing.T) {
+	pin := gpio.NewFakePin(22, gpio.IN)
+	pin.Script(gpio.FakeEdge{After: 0, Value: gpio.HIGH})
+	d := FromPin(pin, gpio.HIGH, 0)
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Run(make(chan Event), stop)
+	}()
+	time.Sleep(20 * ms)
+	close(stop)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("run: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("run not stopped")
+	}
+}
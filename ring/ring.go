@@ -0,0 +1,136 @@
+// Package ring provides the circular buffers of the acquisition: Ring keeps
+// the last samples, bounded by count or by duration, and SPSC hands samples
+// from one goroutine to another without locks.
+package ring
+
+import "time"
+
+// Minimum allocation of a duration bounded ring.
+const MIN_GROW = 64
+
+// Ring keeps the last samples pushed, oldest first. It is not safe for
+// concurrent use, see SPSC.
+type Ring[T any] struct {
+	buf   []T
+	start int // index of the oldest sample
+	n     int
+	age   time.Duration     // duration bound, 0 when bounded by count
+	stamp func(T) time.Time // time of a sample, duration bound only
+}
+
+// New returns a ring that keeps the last n samples. With n = 0 it keeps
+// nothing and is always full.
+func New[T any](n int) *Ring[T] {
+	if n < 0 {
+		n = 0
+	}
+	return &Ring[T]{buf: make([]T, n)}
+}
+
+// NewDuration returns a ring that keeps the samples within d of the newest
+// one, as timed by stamp. It grows as needed.
+func NewDuration[T any](d time.Duration, stamp func(T) time.Time) *Ring[T] {
+	return &Ring[T]{age: d, stamp: stamp}
+}
+
+// Len is the number of samples kept.
+func (r *Ring[T]) Len() int {
+	return r.n
+}
+
+// Full reports whether the ring holds its capacity: n samples, or samples
+// spanning its duration.
+func (r *Ring[T]) Full() bool {
+	if r.stamp == nil {
+		return r.n == len(r.buf)
+	}
+	if r.age == 0 {
+		return true
+	}
+	return r.n > 0 && r.stamp(r.At(r.n-1)).Sub(r.stamp(r.At(0))) >= r.age
+}
+
+// At returns the i-th sample, 0 is the oldest.
+func (r *Ring[T]) At(i int) T {
+	if i < 0 || i >= r.n {
+		panic("ring: index out of range")
+	}
+	return r.buf[(r.start+i)%len(r.buf)]
+}
+
+// Push adds a sample, dropping the oldest ones beyond the capacity.
+func (r *Ring[T]) Push(v T) {
+	if r.stamp == nil {
+		r.pushCount(v)
+		return
+	}
+	if r.n == len(r.buf) {
+		r.grow()
+	}
+	r.buf[(r.start+r.n)%len(r.buf)] = v
+	r.n++
+	for r.n > 1 && r.stamp(v).Sub(r.stamp(r.buf[r.start])) > r.age {
+		r.drop()
+	}
+}
+
+func (r *Ring[T]) pushCount(v T) {
+	if len(r.buf) == 0 {
+		return
+	}
+	if r.n == len(r.buf) {
+		r.buf[r.start] = v
+		r.start = (r.start + 1) % len(r.buf)
+		return
+	}
+	r.buf[(r.start+r.n)%len(r.buf)] = v
+	r.n++
+}
+
+// Drop the oldest sample.
+func (r *Ring[T]) drop() {
+	var zero T
+	r.buf[r.start] = zero
+	r.start = (r.start + 1) % len(r.buf)
+	r.n--
+}
+
+func (r *Ring[T]) grow() {
+	size := 2 * len(r.buf)
+	if size < MIN_GROW {
+		size = MIN_GROW
+	}
+	buf := make([]T, size)
+	r.copyTo(buf)
+	r.buf, r.start = buf, 0
+}
+
+// Copy the samples in order to dst, which must be large enough.
+func (r *Ring[T]) copyTo(dst []T) {
+	if r.n == 0 {
+		return
+	}
+	end := r.start + r.n
+	if end <= len(r.buf) {
+		copy(dst, r.buf[r.start:end])
+		return
+	}
+	k := copy(dst, r.buf[r.start:])
+	copy(dst[k:], r.buf[:end-len(r.buf)])
+}
+
+// Snapshot returns a copy of the samples, oldest first.
+func (r *Ring[T]) Snapshot() []T {
+	out := make([]T, r.n)
+	r.copyTo(out)
+	return out
+}
+
+// Reset empties the ring, keeping its capacity.
+func (r *Ring[T]) Reset() {
+	var zero T
+	for i := range r.buf {
+		r.buf[i] = zero
+	}
+	r.start, r.n = 0, 0
+}
@@ -0,0 +1,192 @@
+package ring
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRingCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      int
+		pushed []int
+		want   []int
+		full   bool
+	}{
+		{"empty", 3, nil, []int{}, false},
+		{"partial", 3, []int{1, 2}, []int{1, 2}, false},
+		{"exact", 3, []int{1, 2, 3}, []int{1, 2, 3}, true},
+		{"wrapped", 3, []int{1, 2, 3, 4, 5}, []int{3, 4, 5}, true},
+		{"wrapped twice", 3, []int{1, 2, 3, 4, 5, 6, 7}, []int{5, 6, 7}, true},
+		{"capacity 0", 0, []int{1, 2}, []int{}, true},
+		{"capacity 1", 1, []int{1, 2, 3}, []int{3}, true},
+		{"negative capacity", -1, []int{1}, []int{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New[int](tt.n)
+			for _, v := range tt.pushed {
+				r.Push(v)
+			}
+			if got := r.Snapshot(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("snapshot %v, want %v", got, tt.want)
+			}
+			if r.Len() != len(tt.want) {
+				t.Errorf("len %d, want %d", r.Len(), len(tt.want))
+			}
+			for i, v := range tt.want {
+				if r.At(i) != v {
+					t.Errorf("at %d: %d, want %d", i, r.At(i), v)
+				}
+			}
+			if r.Full() != tt.full {
+				t.Errorf("full %t, want %t", r.Full(), tt.full)
+			}
+		})
+	}
+}
+
+func TestRingReset(t *testing.T) {
+	r := New[int](2)
+	for _, v := range []int{1, 2, 3} {
+		r.Push(v)
+	}
+	r.Reset()
+	if r.Len() != 0 || r.Full() {
+		t.Fatalf("len %d, full %t after reset", r.Len(), r.Full())
+	}
+	r.Push(4)
+	if got := r.Snapshot(); !reflect.DeepEqual(got, []int{4}) {
+		t.Errorf("snapshot %v after reset, want [4]", got)
+	}
+}
+
+func TestRingDuration(t *testing.T) {
+	t0 := time.Unix(0, 0)
+	ms := func(v int) time.Time {
+		return t0.Add(time.Duration(v) * time.Millisecond)
+	}
+	many := make([]int, 3*MIN_GROW)
+	for i := range many {
+		many[i] = i
+	}
+	tests := []struct {
+		name   string
+		age    time.Duration
+		pushed []int // ms
+		want   []int
+		full   bool
+	}{
+		{"empty", 10 * time.Millisecond, nil, []int{}, false},
+		{"within", 10 * time.Millisecond, []int{0, 4, 8}, []int{0, 4, 8}, false},
+		{"span", 10 * time.Millisecond, []int{0, 5, 10}, []int{0, 5, 10}, true},
+		{"evicted", 10 * time.Millisecond, []int{0, 5, 10, 12, 21}, []int{12, 21}, false},
+		{"evicted full", 10 * time.Millisecond, []int{0, 5, 10, 15, 20}, []int{10, 15, 20}, true},
+		{"gap", 10 * time.Millisecond, []int{0, 1, 2, 50}, []int{50}, false},
+		{"grown", time.Second, many, many, false},
+		{"grown and evicted", 9 * time.Millisecond, many, many[len(many)-10:], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewDuration[int](tt.age, ms)
+			for _, v := range tt.pushed {
+				r.Push(v)
+			}
+			if got := r.Snapshot(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("snapshot %v, want %v", got, tt.want)
+			}
+			if r.Full() != tt.full {
+				t.Errorf("full %t, want %t", r.Full(), tt.full)
+			}
+		})
+	}
+}
+
+func TestSPSCFullEmpty(t *testing.T) {
+	q := NewSPSC[int](3)
+	if q.Cap() != 4 {
+		t.Fatalf("cap %d, want 4", q.Cap())
+	}
+	if _, ok := q.Pop(); ok {
+		t.Fatal("pop of an empty queue")
+	}
+	for i := 0; i < q.Cap(); i++ {
+		if !q.Push(i) {
+			t.Fatalf("push %d refused", i)
+		}
+	}
+	if q.Push(99) {
+		t.Fatal("push to a full queue")
+	}
+	if v, ok := q.Pop(); !ok || v != 0 {
+		t.Fatalf("pop %d %t, want 0", v, ok)
+	}
+	if !q.Push(4) {
+		t.Fatal("push refused after a pop")
+	}
+	if got := q.Drain(nil); !reflect.DeepEqual(got, []int{1, 2, 3, 4}) {
+		t.Errorf("drain %v, want [1 2 3 4]", got)
+	}
+	if q.Len() != 0 {
+		t.Errorf("len %d after drain", q.Len())
+	}
+}
+
+func TestSPSCCapacity(t *testing.T) {
+	for _, tt := range []struct{ capacity, want int }{{0, 1}, {1, 1}, {2, 2}, {5, 8}, {64, 64}} {
+		if got := NewSPSC[int](tt.capacity).Cap(); got != tt.want {
+			t.Errorf("capacity %d: cap %d, want %d", tt.capacity, got, tt.want)
+		}
+	}
+}
+
+// One producer and one consumer, each yielding when the queue is full or
+// empty: every sample goes through once, in order.
+func TestSPSCConcurrent(t *testing.T) {
+	const N = 10000
+	q := NewSPSC[int](8)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	refused := 0
+	go func() {
+		defer wg.Done()
+		for i := 0; i < N; {
+			if q.Push(i) {
+				i++
+			} else {
+				refused++
+				runtime.Gosched()
+			}
+		}
+	}()
+	var got []int
+	go func() {
+		defer wg.Done()
+		for len(got) < N {
+			n := len(got)
+			if n%2 == 0 {
+				if v, ok := q.Pop(); ok {
+					got = append(got, v)
+				}
+			} else {
+				got = q.Drain(got)
+			}
+			if len(got) == n {
+				runtime.Gosched()
+			}
+		}
+	}()
+	wg.Wait()
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("sample %d is %d", i, v)
+		}
+	}
+	if q.Len() != 0 {
+		t.Errorf("len %d at the end", q.Len())
+	}
+	t.Logf("%d pushes refused", refused)
+}
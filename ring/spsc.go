@@ -0,0 +1,71 @@
+package ring
+
+import "sync/atomic"
+
+// SPSC is a bounded lock-free queue for exactly one producer goroutine,
+// calling Push, and one consumer goroutine, calling Pop or Drain. Unlike
+// Ring it never overwrites: Push fails when the queue is full, so the
+// producer can tell the consumer is late.
+type SPSC[T any] struct {
+	buf  []T
+	mask uint64
+	head atomic.Uint64 // next to pop, written by the consumer
+	tail atomic.Uint64 // next to push, written by the producer
+}
+
+// NewSPSC returns a queue holding at least capacity samples, the capacity
+// is rounded up to a power of two.
+func NewSPSC[T any](capacity int) *SPSC[T] {
+	size := 1
+	for size < capacity {
+		size <<= 1
+	}
+	return &SPSC[T]{buf: make([]T, size), mask: uint64(size - 1)}
+}
+
+// Cap is the number of samples the queue can hold.
+func (q *SPSC[T]) Cap() int {
+	return len(q.buf)
+}
+
+// Len is the number of samples queued. It is only a hint while the other
+// side is running.
+func (q *SPSC[T]) Len() int {
+	return int(q.tail.Load() - q.head.Load())
+}
+
+// Push queues a sample, it returns false if the queue is full.
+func (q *SPSC[T]) Push(v T) bool {
+	tail := q.tail.Load()
+	if tail-q.head.Load() == uint64(len(q.buf)) {
+		return false
+	}
+	q.buf[tail&q.mask] = v
+	q.tail.Store(tail + 1)
+	return true
+}
+
+// Pop takes the oldest sample, it returns false if the queue is empty.
+func (q *SPSC[T]) Pop() (T, bool) {
+	var zero T
+	head := q.head.Load()
+	if head == q.tail.Load() {
+		return zero, false
+	}
+	v := q.buf[head&q.mask]
+	q.buf[head&q.mask] = zero
+	q.head.Store(head + 1)
+	return v, true
+}
+
+// Drain appends all the queued samples to dst, oldest first.
+func (q *SPSC[T]) Drain(dst []T) []T {
+	var zero T
+	head, tail := q.head.Load(), q.tail.Load()
+	for ; head != tail; head++ {
+		dst = append(dst, q.buf[head&q.mask])
+		q.buf[head&q.mask] = zero
+	}
+	q.head.Store(head)
+	return dst
+}
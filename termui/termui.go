@@ -0,0 +1,134 @@
+// Package termui draws the screens of the interactive tools on an ANSI
+// terminal, redrawn in place: a body set by the tool over the last lines
+// written to the screen, usually the log.
+package termui
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+)
+
+// ANSI escape sequences.
+const (
+	CLEAR      = "\x1b[2J"
+	HOME       = "\x1b[H"
+	CLEAR_LINE = "\x1b[K"
+	CLEAR_DOWN = "\x1b[J"
+	RESET      = "\x1b[0m"
+	BOLD       = "\x1b[1m"
+	RED        = "\x1b[31m"
+	GREEN      = "\x1b[32m"
+	YELLOW     = "\x1b[33m"
+	DIM        = "\x1b[2m"
+)
+
+// Screen is a terminal redrawn in place.
+type Screen struct {
+	mu      sync.Mutex
+	out     io.Writer
+	title   string
+	body    []string
+	lines   []string // last lines written
+	keep    int
+	partial string // written without its newline yet
+	cleared bool
+}
+
+// New returns a screen on out keeping the last logLines lines written to it.
+func New(out io.Writer, title string, logLines int) *Screen {
+	return &Screen{out: out, title: title, keep: logLines}
+}
+
+// Set replaces the body and redraws the screen.
+func (s *Screen) Set(body ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.body = body
+	s.draw()
+}
+
+// Write adds lines under the body, so the screen can be the output of the
+// log.
+func (s *Screen) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	text := s.partial + string(p)
+	lines := strings.Split(text, "\n")
+	s.partial = lines[len(lines)-1]
+	s.lines = append(s.lines, lines[:len(lines)-1]...)
+	if len(s.lines) > s.keep {
+		s.lines = s.lines[len(s.lines)-s.keep:]
+	}
+	s.draw()
+	return len(p), nil
+}
+
+// Close leaves the last screen as it is, the terminal going on under it.
+func (s *Screen) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprint(s.out, RESET+"\n")
+	return err
+}
+
+func (s *Screen) draw() {
+	var b strings.Builder
+	if !s.cleared {
+		b.WriteString(CLEAR)
+		s.cleared = true
+	}
+	b.WriteString(HOME)
+	line := func(text string) {
+		b.WriteString(text + RESET + CLEAR_LINE + "\n")
+	}
+	line(BOLD + s.title)
+	line("")
+	for _, text := range s.body {
+		line(text)
+	}
+	if s.keep > 0 {
+		line("")
+		for _, text := range s.lines {
+			line(DIM + text)
+		}
+	}
+	b.WriteString(CLEAR_DOWN)
+	fmt.Fprint(s.out, b.String())
+}
+
+// Color a text.
+func Color(color, text string) string {
+	return color + text + RESET
+}
+
+// Bar draws a value of [-scale, scale] as width cells filled from the
+// middle, an arrow at the end if it is out of the range.
+func Bar(value, scale float64, width int) string {
+	half := width / 2
+	cells := []rune(strings.Repeat("-", half) + "|" + strings.Repeat("-", half))
+	n := int(math.Round(math.Abs(value) / scale * float64(half)))
+	over := n > half
+	n = min(n, half)
+	for i := 1; i <= n; i++ {
+		if value < 0 {
+			cells[half-i] = '='
+		} else {
+			cells[half+i] = '='
+		}
+	}
+	if over && value < 0 {
+		cells[0] = '<'
+	} else if over {
+		cells[len(cells)-1] = '>'
+	}
+	return "[" + string(cells) + "]"
+}
+
+// Gauge draws a fraction of [0, 1] as width cells filled from the left.
+func Gauge(fraction float64, width int) string {
+	n := int(math.Round(math.Max(0, math.Min(1, fraction)) * float64(width)))
+	return "[" + strings.Repeat("#", n) + strings.Repeat(".", width-n) + "]"
+}